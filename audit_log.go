@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/yaitoo/sqle"
+	"github.com/yaitoo/sqle/shardid"
+)
+
+// audit actions
+const (
+	AuditUserCreate      = "user.create"
+	AuditUserUpdate      = "user.update"
+	AuditUserDelete      = "user.delete"
+	AuditUserRestore     = "user.restore"
+	AuditUserPurge       = "user.purge"
+	AuditUserVerify      = "user.verify"
+	AuditProfileUpdate   = "profile.update"
+	AuditProfileRevert   = "profile.revert"
+	AuditRoleCreate      = "role.create"
+	AuditPermGrant       = "perm.grant"
+	AuditPermRevoke      = "perm.revoke"
+	AuditRoleAddUsers    = "role.add_users"
+	AuditRoleDeleteUsers = "role.delete_users"
+)
+
+// audit tags
+const (
+	AuditTagUser = "user"
+	AuditTagRole = "role"
+	AuditTagPerm = "perm"
+)
+
+// AuditLog a state-changing call. UserID is the actor, it is 0 if the call is not made by a user.
+type AuditLog struct {
+	ID     shardid.ID `json:"id"`
+	UserID shardid.ID `json:"userID"`
+	Name   string     `json:"name"`
+	Tag    string     `json:"tag"`
+	// Metadata json of the call's arguments, and actor's ip/ua
+	Metadata  string    `json:"metadata"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Chain the shard that the log is chained on, Seq is its position in the chain
+	Chain int   `json:"chain"`
+	Seq   int64 `json:"seq"`
+	// PrevHash is Hash of the previous log in the chain
+	PrevHash string `json:"prevHash"`
+	Hash     string `json:"hash"`
+	// Checkpoint HMAC of Hash, it is written every N logs if audit checkpoint is enabled
+	Checkpoint sqle.String `json:"-"`
+}
+
+// AuditChainError is returned by VerifyAuditChain on the first broken or missing link, it matches ErrAuditChainBroken
+type AuditChainError struct {
+	Chain  int
+	Seq    int64
+	ID     int64
+	Reason string
+}
+
+func (e *AuditChainError) Error() string {
+	return ErrAuditChainBroken.Error() + ": chain " + strconv.Itoa(e.Chain) + " seq " + strconv.FormatInt(e.Seq, 10) + " " + e.Reason
+}
+
+func (e *AuditChainError) Is(target error) bool {
+	return target == ErrAuditChainBroken
+}
+
+// AuditLogFilter filters audit logs, zero fields are ignored
+type AuditLogFilter struct {
+	UserID int64
+	Name   string
+	Tag    string
+	From   time.Time
+	To     time.Time
+	// Cursor the next cursor returned by previous page, it is 0 for the first page
+	Cursor int64
+	// Limit page size, it is 20 by default
+	Limit int
+}
+
+var actorKey ctxKey = "actor"
+
+// WithActor returns a context that records uid as actor in audit logs. Handler's WithAuthn/WithAuthz uses current user by default.
+func WithActor(ctx context.Context, uid int64) context.Context {
+	return context.WithValue(ctx, actorKey, uid)
+}
+
+// getActor returns the actor and its ip/ua of ctx
+func getActor(ctx context.Context) (int64, string, string) {
+	if uid, ok := ctx.Value(actorKey).(int64); ok {
+		return uid, "", ""
+	}
+
+	if cu, ok := GetCurrentUser(ctx); ok {
+		return cu.UserID.Int64, cu.UserIP, cu.UserAgent
+	}
+
+	return 0, "", ""
+}
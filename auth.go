@@ -6,6 +6,7 @@ import (
 	"hash"
 	"log/slog"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/yaitoo/sqle"
@@ -17,19 +18,48 @@ var (
 	//go:embed migration
 	migration embed.FS
 
-	defaultAccessTokenTTL  = 1 * time.Minute
-	defaultRefreshTokenTTL = 1 * time.Hour
-	defaultTOTPIssuer      = "Yaitoo"
-	defaultTOPTAccountName = "Auth"
-	defaultDHTEmail        = "auth:email"
-	defaultDHTMobile       = "auth:mobile"
-	defaultLoginCodeLen    = 6
-	defaultLoginCodeTTL    = 60 * time.Second
+	defaultAccessTokenTTL    = 1 * time.Minute
+	defaultRefreshTokenTTL   = 1 * time.Hour
+	defaultTOTPIssuer        = "Yaitoo"
+	defaultTOPTAccountName   = "Auth"
+	defaultDHTEmail          = "auth:email"
+	defaultDHTMobile         = "auth:mobile"
+	defaultLoginCodeLen      = 6
+	defaultLoginCodeTTL      = 60 * time.Second
+	defaultLoginCodeAttempts = 5
+	defaultRPID              = "localhost"
+	defaultPasskeyTimeout    = 5 * time.Minute
+	defaultTrustedDeviceTTL  = 30 * 24 * time.Hour
+	defaultMagicLinkTTL      = 15 * time.Minute
+	defaultVerificationTTL   = 24 * time.Hour
+	defaultRevertTTL         = 7 * 24 * time.Hour
+	defaultDeleteGrace       = 30 * 24 * time.Hour
 )
 
+var defaultLockout = Lockout{
+	Threshold:   5,
+	Duration:    1 * time.Minute,
+	MaxDuration: 24 * time.Hour,
+	Exponential: true,
+}
+
+// defaultSendThrottle is used for the limits of SendThrottle that are nil
+var defaultSendThrottle = SendThrottle{
+	Identity: []Limit{{Count: 5, Window: time.Hour}},
+	IP:       []Limit{{Count: 20, Window: time.Hour}},
+}
+
+// defaultStuffingGuard is used to fill zero fields of StuffingGuard, thresholds are disabled by default
+var defaultStuffingGuard = StuffingGuard{
+	Window:   10 * time.Minute,
+	Cooldown: 15 * time.Minute,
+	Action:   RiskBlock,
+}
+
 var (
 	noSession     Session
 	noProfileData ProfileData
+	noRisk        Risk
 )
 
 type Auth struct {
@@ -39,7 +69,9 @@ type Auth struct {
 
 	hash func() hash.Hash
 
-	aesKey []byte
+	aesKey          []byte
+	keyStore        KeyStore
+	encryptedFields map[UserField]SearchIndex
 
 	accessTokenTTL  time.Duration
 	refreshTokenTTL time.Duration
@@ -48,15 +80,57 @@ type Auth struct {
 	totpIssuer      string
 	totpAccountName string
 
-	loginCodeSize int
-	loginCodeTTL  time.Duration
+	loginCodeSize     int
+	loginCodeTTL      time.Duration
+	loginCodeAttempts int
 
 	dhtEmail  string
 	dhtMobile string
 
+	rpID           string
+	rpName         string
+	rpOrigins      []string
+	passkeyTimeout time.Duration
+
+	trustedDeviceTTL time.Duration
+
+	magicLinkTTL     time.Duration
+	magicLinkOrigins []string
+
+	counters     CounterStore
+	sendThrottle SendThrottle
+
+	lockout Lockout
+
+	waitingPeriod time.Duration
+	deleteGrace   time.Duration
+
+	verificationTTL time.Duration
+	revertTTL       time.Duration
+	verifyActivate  bool
+	requireVerified bool
+
+	sources       SourceStore
+	stuffingGuard StuffingGuard
+
+	notifier  Notifier
+	templates *Templates
+
+	eventSink EventSink
+
+	geoResolver GeoResolver
+	riskEngine  RiskEngine
+
 	genUser     *shardid.Generator
 	genLoginLog *shardid.Generator
 	genAuditLog *shardid.Generator
+
+	auditCheckpointKey   []byte
+	auditCheckpointEvery int
+
+	userHooksMu sync.RWMutex
+	userHooks   map[int]func(id int64)
+	userHookID  int
 }
 
 // New create an auth provider with db and options
@@ -130,6 +204,86 @@ func New(db *sqle.DB, options ...Option) *Auth {
 		a.loginCodeTTL = defaultLoginCodeTTL
 	}
 
+	if a.loginCodeAttempts < 1 {
+		a.loginCodeAttempts = defaultLoginCodeAttempts
+	}
+
+	if a.rpID == "" {
+		a.rpID = defaultRPID
+	}
+
+	if a.rpName == "" {
+		a.rpName = a.totpIssuer
+	}
+
+	if len(a.rpOrigins) == 0 {
+		a.rpOrigins = []string{"https://" + a.rpID}
+	}
+
+	if a.passkeyTimeout <= 0 {
+		a.passkeyTimeout = defaultPasskeyTimeout
+	}
+
+	if a.trustedDeviceTTL <= 0 {
+		a.trustedDeviceTTL = defaultTrustedDeviceTTL
+	}
+
+	if a.magicLinkTTL <= 0 {
+		a.magicLinkTTL = defaultMagicLinkTTL
+	}
+
+	if a.verificationTTL <= 0 {
+		a.verificationTTL = defaultVerificationTTL
+	}
+
+	if a.revertTTL <= 0 {
+		a.revertTTL = defaultRevertTTL
+	}
+
+	if a.deleteGrace <= 0 {
+		a.deleteGrace = defaultDeleteGrace
+	}
+
+	if len(a.magicLinkOrigins) == 0 {
+		a.magicLinkOrigins = a.rpOrigins
+	}
+
+	if a.lockout.Threshold == 0 {
+		a.lockout = defaultLockout
+	}
+
+	if a.sources == nil {
+		a.sources = NewMemorySourceStore()
+	}
+
+	if a.stuffingGuard.Window <= 0 {
+		a.stuffingGuard.Window = defaultStuffingGuard.Window
+	}
+
+	if a.stuffingGuard.Cooldown <= 0 {
+		a.stuffingGuard.Cooldown = defaultStuffingGuard.Cooldown
+	}
+
+	if a.stuffingGuard.Action == "" {
+		a.stuffingGuard.Action = defaultStuffingGuard.Action
+	}
+
+	if a.counters == nil {
+		a.counters = NewMemoryCounterStore()
+	}
+
+	if a.sendThrottle.Identity == nil {
+		a.sendThrottle.Identity = defaultSendThrottle.Identity
+	}
+
+	if a.sendThrottle.IP == nil {
+		a.sendThrottle.IP = defaultSendThrottle.IP
+	}
+
+	if a.templates == nil {
+		a.templates = DefaultTemplates()
+	}
+
 	return a
 }
 
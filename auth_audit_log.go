@@ -0,0 +1,371 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yaitoo/sqle"
+	"github.com/yaitoo/sqle/shardid"
+)
+
+const auditLogPageSize = 20
+
+// QueryAuditLogs returns audit logs that match the filter from newest to oldest.
+// The returned next cursor is 0 if there are no more pages.
+func (a *Auth) QueryAuditLogs(ctx context.Context, filter AuditLogFilter) ([]AuditLog, int64, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = auditLogPageSize
+	}
+
+	b := a.createBuilder().Select("<prefix>audit_log")
+	b.Where().
+		If(filter.UserID > 0).And("user_id = {user_id}").
+		If(filter.Name != "").And("name = {name}").
+		If(filter.Tag != "").And("tag = {tag}").
+		If(!filter.From.IsZero()).And("created_at >= {from}").
+		If(!filter.To.IsZero()).And("created_at < {to}").
+		If(filter.Cursor > 0).And("id < {cursor}").
+		Param("user_id", filter.UserID).
+		Param("name", filter.Name).
+		Param("tag", filter.Tag).
+		Param("from", filter.From).
+		Param("to", filter.To).
+		Param("cursor", filter.Cursor)
+
+	b.SQL(" ORDER BY id DESC")
+
+	items, err := sqle.NewQuery[AuditLog](a.db).QueryLimit(ctx, b, func(i, j AuditLog) bool {
+		return i.ID.Int64 > j.ID.Int64
+	}, limit+1)
+
+	if err != nil {
+		a.logger.Error("auth: QueryAuditLogs",
+			slog.String("tag", "db"),
+			slog.Any("err", err))
+		return nil, 0, ErrBadDatabase
+	}
+
+	var next int64
+	if len(items) > limit {
+		items = items[:limit]
+		next = items[limit-1].ID.Int64
+	}
+
+	return items, next, nil
+}
+
+// VerifyAuditChain walks the audit log chains created in [from, to), and returns an *AuditChainError on the first broken or missing link.
+// Zero from/to means unbounded. Checkpoints are verified too if audit checkpoint is enabled.
+// If to is unbounded, the last log of each chain is checked against the chain's head row, so deleted newest logs are reported as truncated.
+func (a *Auth) VerifyAuditChain(ctx context.Context, from, to time.Time) error {
+	var heads map[int]AuditLog
+	if to.IsZero() {
+		// heads are loaded before the logs, so the logs appended meanwhile don't look truncated
+		var err error
+		heads, err = a.getAuditChainHeads(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	b := a.createBuilder().Select("<prefix>audit_log")
+	b.Where("seq > 0").
+		If(!from.IsZero()).And("created_at >= {from}").
+		If(!to.IsZero()).And("created_at < {to}").
+		Param("from", from).
+		Param("to", to)
+
+	b.SQL(" ORDER BY chain, seq")
+
+	items, err := sqle.NewQuery[AuditLog](a.db).Query(ctx, b, func(i, j AuditLog) bool {
+		if i.Chain != j.Chain {
+			return i.Chain < j.Chain
+		}
+		return i.Seq < j.Seq
+	})
+
+	if err != nil {
+		a.logger.Error("auth: VerifyAuditChain",
+			slog.String("tag", "db"),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	var prev *AuditLog
+	for i, it := range items {
+		if prev == nil || prev.Chain != it.Chain {
+			prev = nil
+			if it.Seq > 1 {
+				prevHash, err := a.getAuditLogHash(ctx, it.Chain, it.Seq-1)
+				if err != nil {
+					if errors.Is(err, sql.ErrNoRows) {
+						return &AuditChainError{Chain: it.Chain, Seq: it.Seq - 1, Reason: "missing"}
+					}
+					a.logger.Error("auth: VerifyAuditChain:prev",
+						slog.String("tag", "db"),
+						slog.Int("chain", it.Chain),
+						slog.Int64("seq", it.Seq),
+						slog.Any("err", err))
+					return ErrBadDatabase
+				}
+				prev = &AuditLog{Chain: it.Chain, Seq: it.Seq - 1, Hash: prevHash}
+			}
+		}
+
+		if prev != nil {
+			if it.Seq == prev.Seq {
+				return &AuditChainError{Chain: it.Chain, Seq: it.Seq, ID: it.ID.Int64, Reason: "duplicated"}
+			}
+
+			if it.Seq != prev.Seq+1 {
+				return &AuditChainError{Chain: it.Chain, Seq: prev.Seq + 1, Reason: "missing"}
+			}
+
+			if it.PrevHash != prev.Hash {
+				return &AuditChainError{Chain: it.Chain, Seq: it.Seq, ID: it.ID.Int64, Reason: "prev_hash_mismatch"}
+			}
+		} else if it.Seq == 1 && it.PrevHash != "" {
+			return &AuditChainError{Chain: it.Chain, Seq: it.Seq, ID: it.ID.Int64, Reason: "prev_hash_mismatch"}
+		}
+
+		if it.Hash != hashAuditLog(it) {
+			return &AuditChainError{Chain: it.Chain, Seq: it.Seq, ID: it.ID.Int64, Reason: "hash_mismatch"}
+		}
+
+		if a.isAuditCheckpoint(it.Seq) && it.Checkpoint.String() != a.signAuditCheckpoint(it.Hash) {
+			return &AuditChainError{Chain: it.Chain, Seq: it.Seq, ID: it.ID.Int64, Reason: "checkpoint_mismatch"}
+		}
+
+		if h, ok := heads[it.Chain]; ok && h.Seq == it.Seq {
+			if h.Hash != it.Hash {
+				return &AuditChainError{Chain: it.Chain, Seq: it.Seq, ID: it.ID.Int64, Reason: "truncated"}
+			}
+			delete(heads, it.Chain)
+		}
+
+		prev = &items[i]
+	}
+
+	// the last log of chain isn't verified, it is either deleted or created before from
+	for _, h := range heads {
+		hash, err := a.getAuditLogHash(ctx, h.Chain, h.Seq)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			a.logger.Error("auth: VerifyAuditChain:head",
+				slog.String("tag", "db"),
+				slog.Int("chain", h.Chain),
+				slog.Int64("seq", h.Seq),
+				slog.Any("err", err))
+			return ErrBadDatabase
+		}
+
+		if hash != h.Hash {
+			return &AuditChainError{Chain: h.Chain, Seq: h.Seq, Reason: "truncated"}
+		}
+	}
+
+	return nil
+}
+
+// audit writes an audit log on actor's shard, and links it to the last log of the shard's chain.
+// It doesn't fail the call, errors are logged only.
+func (a *Auth) audit(ctx context.Context, name, tag string, metadata map[string]any) {
+	actor, ip, ua := getActor(ctx)
+	if ip != "" {
+		metadata["ip"] = ip
+	}
+	if ua != "" {
+		metadata["ua"] = ua
+	}
+
+	buf, err := json.Marshal(metadata)
+	if err == nil {
+		err = a.appendAuditLog(ctx, AuditLog{
+			ID:       a.genAuditLog.Next(),
+			UserID:   shardid.Parse(actor),
+			Name:     name,
+			Tag:      tag,
+			Metadata: string(buf),
+			// datetime is stored in seconds
+			CreatedAt: time.Now().Truncate(time.Second),
+		})
+	}
+
+	if err != nil {
+		a.logger.Error("auth: audit",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", actor),
+			slog.String("name", name),
+			slog.Any("err", err))
+	}
+}
+
+// appendAuditLog appends l to the chain of actor's shard. The head row of the chain is locked by the transaction,
+// so logs are chained one by one across instances.
+func (a *Auth) appendAuditLog(ctx context.Context, l AuditLog) error {
+	l.Chain = int(l.UserID.DatabaseID)
+
+	var err error
+	// the head row of a new chain may be inserted by a concurrent log, so it is locked by update again
+	for i := 0; i < 2; i++ {
+		err = a.db.On(l.UserID).Transaction(ctx, &sql.TxOptions{}, func(ctx context.Context, tx *sqle.Tx) error {
+			r, err := tx.ExecBuilder(ctx, a.createBuilder().
+				Update("<prefix>audit_chain").
+				SetExpr("`seq` = `seq` + 1").
+				Where("chain = {chain}").
+				Param("chain", l.Chain))
+			if err != nil {
+				return err
+			}
+
+			if n, _ := r.RowsAffected(); n == 0 {
+				err = a.createAuditChain(ctx, tx, l.Chain)
+				if err != nil {
+					return err
+				}
+			}
+
+			err = tx.QueryRowBuilder(ctx, a.createBuilder().
+				Select("<prefix>audit_chain", "seq", "hash").
+				Where("chain = {chain}").
+				Param("chain", l.Chain)).
+				Scan(&l.Seq, &l.PrevHash)
+			if err != nil {
+				return err
+			}
+
+			l.Hash = hashAuditLog(l)
+
+			cb := a.createBuilder().
+				Insert("<prefix>audit_log").
+				Set("id", l.ID.Int64).
+				Set("user_id", l.UserID.Int64).
+				Set("name", l.Name).
+				Set("tag", l.Tag).
+				Set("metadata", l.Metadata).
+				Set("created_at", l.CreatedAt).
+				Set("chain", l.Chain).
+				Set("seq", l.Seq).
+				Set("prev_hash", l.PrevHash).
+				Set("hash", l.Hash)
+
+			if a.isAuditCheckpoint(l.Seq) {
+				cb.Set("checkpoint", a.signAuditCheckpoint(l.Hash))
+			}
+
+			_, err = tx.ExecBuilder(ctx, cb.End())
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.ExecBuilder(ctx, a.createBuilder().
+				Update("<prefix>audit_chain").
+				Set("hash", l.Hash).
+				Where("chain = {chain}").
+				Param("chain", l.Chain))
+
+			return err
+		})
+
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// createAuditChain creates the head row of chain that is next to the last log, the logs before the head row is used are chained too
+func (a *Auth) createAuditChain(ctx context.Context, tx *sqle.Tx, chain int) error {
+	var seq int64
+	var hash string
+	err := tx.QueryRowBuilder(ctx, a.createBuilder().
+		Select("<prefix>audit_log", "seq", "hash").
+		Where("chain = {chain}").
+		Param("chain", chain).
+		SQL(" ORDER BY seq DESC LIMIT 1")).
+		Scan(&seq, &hash)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	_, err = tx.ExecBuilder(ctx, a.createBuilder().
+		Insert("<prefix>audit_chain").
+		Set("chain", chain).
+		Set("seq", seq+1).
+		Set("hash", hash).
+		End())
+
+	return err
+}
+
+// getAuditChainHeads returns the head rows of all chains across shards
+func (a *Auth) getAuditChainHeads(ctx context.Context) (map[int]AuditLog, error) {
+	items, err := sqle.NewQuery[AuditLog](a.db).Query(ctx, a.createBuilder().
+		Select("<prefix>audit_chain", "chain", "seq", "hash"), func(i, j AuditLog) bool {
+		return i.Chain < j.Chain
+	})
+
+	if err != nil {
+		a.logger.Error("auth: getAuditChainHeads",
+			slog.String("tag", "db"),
+			slog.Any("err", err))
+		return nil, ErrBadDatabase
+	}
+
+	heads := make(map[int]AuditLog, len(items))
+	for _, it := range items {
+		heads[it.Chain] = it
+	}
+
+	return heads, nil
+}
+
+// getAuditLogHash returns the hash of the log at seq of chain
+func (a *Auth) getAuditLogHash(ctx context.Context, chain int, seq int64) (string, error) {
+	var h string
+	err := a.db.On(shardid.ID{DatabaseID: int16(chain)}).
+		QueryRowBuilder(ctx, a.createBuilder().
+			Select("<prefix>audit_log", "hash").
+			Where("chain = {chain} AND seq = {seq}").
+			Param("chain", chain).
+			Param("seq", seq)).
+		Scan(&h)
+
+	return h, err
+}
+
+func (a *Auth) isAuditCheckpoint(seq int64) bool {
+	return a.auditCheckpointEvery > 0 && seq%int64(a.auditCheckpointEvery) == 0
+}
+
+func (a *Auth) signAuditCheckpoint(h string) string {
+	m := hmac.New(sha256.New, a.auditCheckpointKey)
+	m.Write([]byte(h)) // nolint: errcheck
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// hashAuditLog hashes the log with its previous hash, so any change of a log breaks the chain
+func hashAuditLog(l AuditLog) string {
+	return hashToken(strings.Join([]string{
+		l.PrevHash,
+		strconv.Itoa(l.Chain),
+		strconv.FormatInt(l.Seq, 10),
+		strconv.FormatInt(l.ID.Int64, 10),
+		strconv.FormatInt(l.UserID.Int64, 10),
+		l.Name,
+		l.Tag,
+		l.Metadata,
+		strconv.FormatInt(l.CreatedAt.Unix(), 10),
+	}, "\n"))
+}
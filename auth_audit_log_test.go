@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	au := createAuthTest("./tests_audit_log.db")
+	ctx := context.Background()
+
+	admin, err := au.CreateUser(ctx, UserStatusActivated, "admin@mail.com", "", "abc123", "", "")
+	require.NoError(t, err)
+
+	begin := time.Now().Add(-time.Second)
+	actx := WithActor(ctx, admin.ID.Int64)
+
+	u, err := au.CreateUser(actx, UserStatusActivated, "audit@mail.com", "", "abc123", "", "")
+	require.NoError(t, err)
+
+	rid, err := au.CreateRole(actx, "auditor")
+	require.NoError(t, err)
+
+	err = au.RegisterPerm(actx, "audit:read", "audit")
+	require.NoError(t, err)
+
+	err = au.GrantPerms(actx, rid, "audit:read")
+	require.NoError(t, err)
+
+	err = au.AddRoleUsers(actx, rid, u.ID.Int64)
+	require.NoError(t, err)
+
+	err = au.UpdateUser(actx, u.ID.Int64, UserStatusActivated, "Audit", "User")
+	require.NoError(t, err)
+
+	t.Run("actor", func(t *testing.T) {
+		items, next, err := au.QueryAuditLogs(ctx, AuditLogFilter{UserID: admin.ID.Int64, From: begin})
+		require.NoError(t, err)
+		require.Zero(t, next)
+		require.Len(t, items, 5)
+
+		// newest first
+		require.Equal(t, AuditUserUpdate, items[0].Name)
+		require.Equal(t, AuditRoleAddUsers, items[1].Name)
+		require.Equal(t, AuditPermGrant, items[2].Name)
+		require.Equal(t, AuditRoleCreate, items[3].Name)
+		require.Equal(t, AuditUserCreate, items[4].Name)
+
+		var md struct {
+			UserID int64  `json:"user_id"`
+			Email  string `json:"email"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(items[4].Metadata), &md))
+		require.Equal(t, u.ID.Int64, md.UserID)
+		require.NotEqual(t, "audit@mail.com", md.Email)
+	})
+
+	t.Run("authz", func(t *testing.T) {
+		s, err := au.Login(ctx, "audit@mail.com", "abc123", LoginOption{})
+		require.NoError(t, err)
+
+		h := NewHandler(au)
+		fn := h.WithAuthz(ctx, "audit", "audit:read", func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			cu, ok := GetCurrentUser(ctx)
+			require.True(t, ok)
+			require.Equal(t, u.ID.Int64, cu.UserID.Int64)
+
+			require.NoError(t, au.UpdateUser(ctx, u.ID.Int64, UserStatusActivated, "Audit", "Self"))
+			WriteEmpty(w)
+		})
+
+		r := httptest.NewRequest(http.MethodPost, "/me", nil)
+		r.Header.Set("X-Access-Token", s.AccessToken)
+		w := httptest.NewRecorder()
+		fn(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		items, _, err := au.QueryAuditLogs(ctx, AuditLogFilter{UserID: u.ID.Int64, Name: AuditUserUpdate})
+		require.NoError(t, err)
+		require.Len(t, items, 1)
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		items, _, err := au.QueryAuditLogs(ctx, AuditLogFilter{Tag: AuditTagRole})
+		require.NoError(t, err)
+		require.Len(t, items, 2)
+
+		items, _, err = au.QueryAuditLogs(ctx, AuditLogFilter{Name: AuditPermGrant})
+		require.NoError(t, err)
+		require.Len(t, items, 1)
+
+		items, _, err = au.QueryAuditLogs(ctx, AuditLogFilter{To: begin})
+		require.NoError(t, err)
+		require.Len(t, items, 0)
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		items, next, err := au.QueryAuditLogs(ctx, AuditLogFilter{UserID: admin.ID.Int64, Limit: 3})
+		require.NoError(t, err)
+		require.Len(t, items, 3)
+		require.NotZero(t, next)
+
+		more, next, err := au.QueryAuditLogs(ctx, AuditLogFilter{UserID: admin.ID.Int64, Limit: 3, Cursor: next})
+		require.NoError(t, err)
+		require.Len(t, more, 2)
+		require.Zero(t, next)
+		require.Equal(t, AuditUserCreate, more[1].Name)
+	})
+}
+
+func TestAuditChain(t *testing.T) {
+	au := createAuthTest("./tests_audit_chain.db")
+	au.auditCheckpointKey = deriveKey([]byte("audit"), "audit:checkpoint")
+	au.auditCheckpointEvery = 2
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		_, err := au.CreateRole(ctx, "chain"+strconv.Itoa(i))
+		require.NoError(t, err)
+	}
+
+	items, _, err := au.QueryAuditLogs(ctx, AuditLogFilter{})
+	require.NoError(t, err)
+	require.Len(t, items, 5)
+
+	// newest first
+	require.EqualValues(t, 5, items[0].Seq)
+	require.Equal(t, items[1].Hash, items[0].PrevHash)
+	require.True(t, items[1].Checkpoint.Valid)
+	require.False(t, items[0].Checkpoint.Valid)
+
+	require.NoError(t, au.VerifyAuditChain(ctx, time.Time{}, time.Time{}))
+
+	t.Run("edited", func(t *testing.T) {
+		_, err := au.db.ExecContext(ctx, "UPDATE test_audit_log SET metadata = ? WHERE seq = 3", `{"name":"edited"}`)
+		require.NoError(t, err)
+		defer au.db.ExecContext(ctx, "UPDATE test_audit_log SET metadata = ? WHERE seq = 3", items[2].Metadata) // nolint: errcheck
+
+		err = au.VerifyAuditChain(ctx, time.Time{}, time.Time{})
+		require.ErrorIs(t, err, ErrAuditChainBroken)
+
+		var ce *AuditChainError
+		require.ErrorAs(t, err, &ce)
+		require.EqualValues(t, 3, ce.Seq)
+		require.Equal(t, "hash_mismatch", ce.Reason)
+	})
+
+	t.Run("rehashed", func(t *testing.T) {
+		l := items[2]
+		l.Metadata = `{"name":"rehashed"}`
+		h := hashAuditLog(l)
+
+		_, err := au.db.ExecContext(ctx, "UPDATE test_audit_log SET metadata = ?, hash = ? WHERE seq = 3", l.Metadata, h)
+		require.NoError(t, err)
+		defer au.db.ExecContext(ctx, "UPDATE test_audit_log SET metadata = ?, hash = ? WHERE seq = 3", items[2].Metadata, items[2].Hash) // nolint: errcheck
+
+		var ce *AuditChainError
+		require.ErrorAs(t, au.VerifyAuditChain(ctx, time.Time{}, time.Time{}), &ce)
+		require.EqualValues(t, 4, ce.Seq)
+		require.Equal(t, "prev_hash_mismatch", ce.Reason)
+
+		// the next log is rehashed too, but its checkpoint can't be signed without key
+		n := items[1]
+		n.PrevHash = h
+		_, err = au.db.ExecContext(ctx, "UPDATE test_audit_log SET prev_hash = ?, hash = ? WHERE seq = 4", n.PrevHash, hashAuditLog(n))
+		require.NoError(t, err)
+		defer au.db.ExecContext(ctx, "UPDATE test_audit_log SET prev_hash = ?, hash = ? WHERE seq = 4", items[1].PrevHash, items[1].Hash) // nolint: errcheck
+
+		require.ErrorAs(t, au.VerifyAuditChain(ctx, time.Time{}, time.Time{}), &ce)
+		require.EqualValues(t, 4, ce.Seq)
+		require.Equal(t, "checkpoint_mismatch", ce.Reason)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		// the newest logs are deleted, the rest of chain is still linked
+		_, err := au.db.ExecContext(ctx, "DELETE FROM test_audit_log WHERE seq >= 4")
+		require.NoError(t, err)
+		defer func() {
+			for _, it := range items[:2] {
+				_, err := au.db.ExecContext(ctx, "INSERT INTO test_audit_log(id, user_id, name, tag, metadata, created_at, chain, seq, prev_hash, hash, checkpoint) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+					it.ID.Int64, it.UserID.Int64, it.Name, it.Tag, it.Metadata, it.CreatedAt, it.Chain, it.Seq, it.PrevHash, it.Hash, it.Checkpoint)
+				require.NoError(t, err)
+			}
+			require.NoError(t, au.VerifyAuditChain(ctx, time.Time{}, time.Time{}))
+		}()
+
+		var ce *AuditChainError
+		require.ErrorAs(t, au.VerifyAuditChain(ctx, time.Time{}, time.Time{}), &ce)
+		require.EqualValues(t, 5, ce.Seq)
+		require.Equal(t, "truncated", ce.Reason)
+
+		// the head is only checked if to is unbounded
+		require.NoError(t, au.VerifyAuditChain(ctx, time.Time{}, time.Now().Add(time.Minute)))
+	})
+
+	t.Run("deleted", func(t *testing.T) {
+		require.NoError(t, au.VerifyAuditChain(ctx, time.Time{}, time.Time{}))
+
+		_, err := au.db.ExecContext(ctx, "DELETE FROM test_audit_log WHERE seq = 2")
+		require.NoError(t, err)
+
+		var ce *AuditChainError
+		require.ErrorAs(t, au.VerifyAuditChain(ctx, time.Time{}, time.Time{}), &ce)
+		require.EqualValues(t, 2, ce.Seq)
+		require.Equal(t, "missing", ce.Reason)
+
+		// the missing link is found even if it is out of range
+		require.ErrorAs(t, au.VerifyAuditChain(ctx, items[2].CreatedAt, time.Time{}), &ce)
+	})
+}
+
+func TestAuditChainConcurrent(t *testing.T) {
+	au := createAuthTest("./tests_audit_chain_concurrent.db")
+	ctx := context.Background()
+
+	// sqlite's shared cache fails concurrent transactions instead of waiting, the statements of goroutines still interleave on one connection
+	au.db.SetMaxOpenConns(1)
+	defer au.db.SetMaxOpenConns(0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			au.audit(ctx, AuditRoleCreate, AuditTagRole, map[string]any{"name": "concurrent" + strconv.Itoa(i)})
+		}(i)
+	}
+	wg.Wait()
+
+	items, _, err := au.QueryAuditLogs(ctx, AuditLogFilter{})
+	require.NoError(t, err)
+	require.Len(t, items, 10)
+
+	// ids are generated before logs are chained, so logs are ordered by seq
+	seqs := make([]int64, 0, len(items))
+	for _, it := range items {
+		seqs = append(seqs, it.Seq)
+	}
+	slices.Sort(seqs)
+	require.Equal(t, []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, seqs)
+
+	require.NoError(t, au.VerifyAuditChain(ctx, time.Time{}, time.Time{}))
+}
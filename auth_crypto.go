@@ -0,0 +1,552 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/yaitoo/sqle"
+	"github.com/yaitoo/sqle/shardid"
+)
+
+// encryptData encrypts text with the AES key if it is configured
+func (a *Auth) encryptData(text string) (string, error) {
+	if a.aesKey == nil {
+		return text, nil
+	}
+
+	ct, err := encryptText([]byte(text), a.aesKey)
+	if err != nil {
+		a.logger.Error("auth: encryptData",
+			slog.String("tag", "crypto"),
+			slog.Any("err", err))
+		return "", ErrUnknown
+	}
+
+	return ct, nil
+}
+
+// decryptData decrypts text encrypted by encryptData
+func (a *Auth) decryptData(text string) (string, error) {
+	if a.aesKey == nil {
+		return text, nil
+	}
+
+	pt, err := decryptText(text, a.aesKey)
+	if err != nil {
+		a.logger.Error("auth: decryptData",
+			slog.String("tag", "crypto"),
+			slog.Any("err", err))
+		return "", ErrUnknown
+	}
+
+	return pt, nil
+}
+
+// userDataPrefix marks the data that is encrypted with the data key of user. Hex-encoded data can't contain `:`,
+// so the data that is encrypted with the AES key directly can still be decrypted.
+const userDataPrefix = "k1:"
+
+// encryptUserData encrypts text with the data key of user if the AES key is configured, the data key is created if it doesn't exist.
+// The data becomes unreadable everywhere, including backups, once the data key is destroyed.
+func (a *Auth) encryptUserData(ctx context.Context, conn sqle.Connector, uid shardid.ID, text string) (string, error) {
+	if a.aesKey == nil {
+		return text, nil
+	}
+
+	key, err := a.getUserKey(ctx, conn, uid)
+	if errors.Is(err, ErrUserDataShredded) {
+		key, err = a.createUserKey(ctx, conn, uid)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	ct, err := encryptText([]byte(text), key)
+	if err != nil {
+		a.logger.Error("auth: encryptUserData",
+			slog.String("tag", "crypto"),
+			slog.Int64("user_id", uid.Int64),
+			slog.Any("err", err))
+		return "", ErrUnknown
+	}
+
+	return userDataPrefix + ct, nil
+}
+
+// decryptUserData decrypts text encrypted by encryptUserData, or by the AES key directly before data keys are used.
+// It returns ErrUserDataShredded if the data key of user is destroyed.
+func (a *Auth) decryptUserData(ctx context.Context, conn sqle.Connector, uid shardid.ID, text string) (string, error) {
+	if a.aesKey == nil {
+		return text, nil
+	}
+
+	ct, ok := strings.CutPrefix(text, userDataPrefix)
+	if !ok {
+		return a.decryptData(text)
+	}
+
+	key, err := a.getUserKey(ctx, conn, uid)
+	if err != nil {
+		return "", err
+	}
+
+	pt, err := decryptText(ct, key)
+	if err != nil {
+		a.logger.Error("auth: decryptUserData",
+			slog.String("tag", "crypto"),
+			slog.Int64("user_id", uid.Int64),
+			slog.Any("err", err))
+		return "", ErrUnknown
+	}
+
+	return pt, nil
+}
+
+type userKeyCacheKey struct{}
+
+// withUserKeyCache caches the data keys that are unwrapped in the call of ctx, so a call that decrypts the data of many users reads each data key once.
+// The cache isn't safe for concurrent use.
+func withUserKeyCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, userKeyCacheKey{}, make(map[int64][]byte))
+}
+
+// getUserKey returns the data key of user that is unwrapped with the AES key, or ErrUserDataShredded if it doesn't exist.
+// The data key is read from the key store if it is set, and from the user_key table if it isn't moved to the key store yet.
+func (a *Auth) getUserKey(ctx context.Context, conn sqle.Connector, uid shardid.ID) ([]byte, error) {
+	cache, _ := ctx.Value(userKeyCacheKey{}).(map[int64][]byte)
+	if key, ok := cache[uid.Int64]; ok {
+		return key, nil
+	}
+
+	var (
+		wrapped string
+		err     error
+	)
+
+	if a.keyStore != nil {
+		wrapped, err = a.keyStore.Get(ctx, uid.Int64)
+		if err != nil && !errors.Is(err, ErrUserDataShredded) {
+			a.logger.Error("auth: getUserKey",
+				slog.String("tag", "keystore"),
+				slog.Int64("user_id", uid.Int64),
+				slog.Any("err", err))
+			return nil, ErrUnknown
+		}
+	}
+
+	if wrapped == "" {
+		wrapped, err = a.getTableUserKey(ctx, conn, uid)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := decryptText(wrapped, a.aesKey)
+	if err != nil {
+		a.logger.Error("auth: getUserKey",
+			slog.String("step", "decryptText"),
+			slog.String("tag", "crypto"),
+			slog.Int64("user_id", uid.Int64),
+			slog.Any("err", err))
+		return nil, ErrUnknown
+	}
+
+	if cache != nil {
+		cache[uid.Int64] = []byte(key)
+	}
+
+	return []byte(key), nil
+}
+
+// getTableUserKey returns the wrapped data key of user in the user_key table, or ErrUserDataShredded if it doesn't exist
+func (a *Auth) getTableUserKey(ctx context.Context, conn sqle.Connector, uid shardid.ID) (string, error) {
+	var wrapped string
+	err := conn.
+		QueryRowBuilder(ctx, a.createBuilder().
+			Select("<prefix>user_key", "data_key").
+			Where("user_id = {user_id}").
+			Param("user_id", uid.Int64)).
+		Scan(&wrapped)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrUserDataShredded
+		}
+
+		a.logger.Error("auth: getTableUserKey",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid.Int64),
+			slog.Any("err", err))
+		return "", ErrBadDatabase
+	}
+
+	return wrapped, nil
+}
+
+// createUserKey creates a random data key for user, and saves it that is wrapped with the AES key in the key store,
+// or in the user_key table if the key store isn't set.
+func (a *Auth) createUserKey(ctx context.Context, conn sqle.Connector, uid shardid.ID) ([]byte, error) {
+	key := randBytes(32)
+
+	wrapped, err := encryptText(key, a.aesKey)
+	if err != nil {
+		a.logger.Error("auth: createUserKey",
+			slog.String("tag", "crypto"),
+			slog.Int64("user_id", uid.Int64),
+			slog.Any("err", err))
+		return nil, ErrUnknown
+	}
+
+	if a.keyStore != nil {
+		err = a.keyStore.Put(ctx, uid.Int64, wrapped)
+		if err != nil {
+			a.logger.Error("auth: createUserKey",
+				slog.String("tag", "keystore"),
+				slog.Int64("user_id", uid.Int64),
+				slog.Any("err", err))
+			return nil, ErrUnknown
+		}
+
+		return key, nil
+	}
+
+	_, err = conn.ExecBuilder(ctx, a.createBuilder().
+		Insert("<prefix>user_key").
+		Set("user_id", uid.Int64).
+		Set("data_key", wrapped).
+		Set("created_at", time.Now()).
+		End())
+
+	if err != nil {
+		a.logger.Error("auth: createUserKey",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid.Int64),
+			slog.Any("err", err))
+		return nil, ErrBadDatabase
+	}
+
+	return key, nil
+}
+
+const resealBatchSize = 100
+
+// ResealUserData moves the data keys in the user_key table to the key store if it is set, and seals the profile data and MFA secrets
+// that are encrypted with the AES key directly with the data keys of users. The user fields that are written before WithEncryptedField
+// is enabled are encrypted and indexed too. It returns how many rows are resealed, and it is safe to run it again.
+func (a *Auth) ResealUserData(ctx context.Context) (int, error) {
+	if a.aesKey == nil {
+		return 0, nil
+	}
+
+	if a.keyStore != nil {
+		err := a.moveUserKeys(ctx)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := a.resealProfiles(ctx)
+	if err != nil {
+		return n, err
+	}
+
+	m, err := a.resealMFASecrets(ctx)
+	n += m
+	if err != nil {
+		return n, err
+	}
+
+	m, err = a.resealUserFields(ctx)
+	return n + m, err
+}
+
+// moveUserKeys moves the data keys in the user_key table to the key store
+func (a *Auth) moveUserKeys(ctx context.Context) error {
+	type userKey struct {
+		UserID  int64
+		DataKey string
+	}
+
+	for {
+		b := a.createBuilder().
+			Select("<prefix>user_key", "user_id", "data_key").
+			SQL(" ORDER BY user_id")
+
+		items, err := sqle.NewQuery[userKey](a.db).QueryLimit(ctx, b, func(i, j userKey) bool {
+			return i.UserID < j.UserID
+		}, resealBatchSize)
+
+		if err != nil {
+			a.logger.Error("auth: moveUserKeys",
+				slog.String("tag", "db"),
+				slog.Any("err", err))
+			return ErrBadDatabase
+		}
+
+		for _, it := range items {
+			// the key is moved already if the last run fails before it is deleted
+			_, err = a.keyStore.Get(ctx, it.UserID)
+			if errors.Is(err, ErrUserDataShredded) {
+				err = a.keyStore.Put(ctx, it.UserID, it.DataKey)
+			}
+
+			if err != nil {
+				a.logger.Error("auth: moveUserKeys",
+					slog.String("tag", "keystore"),
+					slog.Int64("user_id", it.UserID),
+					slog.Any("err", err))
+				return ErrUnknown
+			}
+
+			_, err = a.db.On(shardid.Parse(it.UserID)).
+				ExecBuilder(ctx, a.createBuilder().
+					Delete("<prefix>user_key").
+					Where("user_id = {user_id}").
+					Param("user_id", it.UserID))
+
+			if err != nil {
+				a.logger.Error("auth: moveUserKeys:Delete",
+					slog.String("tag", "db"),
+					slog.Int64("user_id", it.UserID),
+					slog.Any("err", err))
+				return ErrBadDatabase
+			}
+		}
+
+		if len(items) < resealBatchSize {
+			return nil
+		}
+	}
+}
+
+// resealProfiles seals the profile data that is encrypted with the AES key directly with the data keys of users
+func (a *Auth) resealProfiles(ctx context.Context) (int, error) {
+	n := 0
+	for {
+		b := a.createBuilder().
+			Select("<prefix>user_profile", "user_id", "data").
+			Where("data NOT LIKE {sealed}").
+			End().
+			SQL(" ORDER BY user_id").
+			Param("sealed", userDataPrefix+"%")
+
+		items, err := sqle.NewQuery[Profile](a.db).QueryLimit(ctx, b, func(i, j Profile) bool {
+			return i.UserID.Int64 < j.UserID.Int64
+		}, resealBatchSize)
+
+		if err != nil {
+			a.logger.Error("auth: resealProfiles",
+				slog.String("tag", "db"),
+				slog.Any("err", err))
+			return n, ErrBadDatabase
+		}
+
+		for _, it := range items {
+			err = a.resealRow(ctx, it.UserID, "user_profile", "data", it.Data, "")
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+
+		if len(items) < resealBatchSize {
+			return n, nil
+		}
+	}
+}
+
+// resealMFASecrets seals the secrets of MFA devices that are encrypted with the AES key directly with the data keys of users
+func (a *Auth) resealMFASecrets(ctx context.Context) (int, error) {
+	n := 0
+	for {
+		b := a.createBuilder().
+			Select("<prefix>user_mfa", "user_id", "id", "secret").
+			Where("secret NOT LIKE {sealed}").
+			End().
+			SQL(" ORDER BY user_id, id").
+			Param("sealed", userDataPrefix+"%")
+
+		items, err := sqle.NewQuery[MFADevice](a.db).QueryLimit(ctx, b, func(i, j MFADevice) bool {
+			if i.UserID.Int64 == j.UserID.Int64 {
+				return i.ID < j.ID
+			}
+			return i.UserID.Int64 < j.UserID.Int64
+		}, resealBatchSize)
+
+		if err != nil {
+			a.logger.Error("auth: resealMFASecrets",
+				slog.String("tag", "db"),
+				slog.Any("err", err))
+			return n, ErrBadDatabase
+		}
+
+		for _, it := range items {
+			err = a.resealRow(ctx, it.UserID, "user_mfa", "secret", it.Secret, it.ID)
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+
+		if len(items) < resealBatchSize {
+			return n, nil
+		}
+	}
+}
+
+// resealRow seals the column of a row with the data key of user, the row is skipped if it is changed since it is read
+func (a *Auth) resealRow(ctx context.Context, uid shardid.ID, table, column, text, id string) error {
+	pt, err := a.decryptData(text)
+	if err != nil {
+		return err
+	}
+
+	db := a.db.On(uid)
+	ct, err := a.encryptUserData(ctx, db, uid, pt)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecBuilder(ctx, a.createBuilder().
+		Update("<prefix>"+table).
+		Set(column, ct).
+		Where("user_id = {user_id}").
+		If(id != "").And("id = {id}").
+		And(column+" = {text}").
+		Param("user_id", uid.Int64).
+		Param("id", id).
+		Param("text", text))
+
+	if err != nil {
+		a.logger.Error("auth: resealRow",
+			slog.String("tag", "db"),
+			slog.String("table", table),
+			slog.Int64("user_id", uid.Int64),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	return nil
+}
+
+// resealUserFields encrypts the encrypted fields of users that are still in plaintext, and indexes them if they are searchable
+func (a *Auth) resealUserFields(ctx context.Context) (int, error) {
+	var conds []string
+	for _, f := range []UserField{UserFirstName, UserLastName, UserEmail, UserMobile} {
+		if a.isEncryptedField(f) {
+			conds = append(conds, "("+string(f)+" <> '' AND "+string(f)+" NOT LIKE {sealed})")
+		}
+	}
+
+	if len(conds) == 0 {
+		return 0, nil
+	}
+
+	n := 0
+	for {
+		b := a.createBuilder().
+			Select("<prefix>user", "id", "first_name", "last_name", "email", "mobile").
+			Where("("+strings.Join(conds, " OR ")+")").
+			End().
+			SQL(" ORDER BY id").
+			Param("sealed", userDataPrefix+"%")
+
+		items, err := sqle.NewQuery[User](a.db).QueryLimit(ctx, b, func(i, j User) bool {
+			return i.ID.Int64 < j.ID.Int64
+		}, resealBatchSize)
+
+		if err != nil {
+			a.logger.Error("auth: resealUserFields",
+				slog.String("tag", "db"),
+				slog.Any("err", err))
+			return n, ErrBadDatabase
+		}
+
+		for _, it := range items {
+			err = a.resealUser(ctx, it)
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+
+		if len(items) < resealBatchSize {
+			return n, nil
+		}
+	}
+}
+
+// resealUser encrypts and indexes the plaintext fields of u, the user is skipped if it is changed since it is read
+func (a *Auth) resealUser(ctx context.Context, u User) error {
+	values := map[UserField]string{
+		UserFirstName: u.FirstName,
+		UserLastName:  u.LastName,
+		UserEmail:     u.Email,
+		UserMobile:    u.Mobile,
+	}
+
+	for f, v := range values {
+		if !a.isEncryptedField(f) || v == "" || strings.HasPrefix(v, userDataPrefix) {
+			delete(values, f)
+		}
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	return a.db.On(u.ID).Transaction(ctx, &sql.TxOptions{}, func(ctx context.Context, tx *sqle.Tx) error {
+		fields, err := a.encryptUserFields(ctx, tx, u.ID, values)
+		if err != nil {
+			return err
+		}
+
+		b := a.createBuilder()
+		ub := b.Update("<prefix>user")
+		for f, ct := range fields {
+			ub.Set(string(f), ct)
+		}
+
+		w := ub.Where("id = {id}")
+		b.Param("id", u.ID.Int64)
+		for f, v := range values {
+			w.And(string(f) + " = {old_" + string(f) + "}")
+			b.Param("old_"+string(f), v)
+		}
+
+		r, err := tx.ExecBuilder(ctx, b)
+		if err != nil {
+			a.logger.Error("auth: resealUser",
+				slog.String("tag", "db"),
+				slog.Int64("user_id", u.ID.Int64),
+				slog.Any("err", err))
+			return ErrBadDatabase
+		}
+
+		if n, _ := r.RowsAffected(); n == 0 {
+			return nil
+		}
+
+		// the user table only has the masked email/mobile, the full ones in profile are indexed
+		_, email := values[UserEmail]
+		_, mobile := values[UserMobile]
+		if email || mobile {
+			pd, err := a.getProfileData(ctx, tx, u.ID.Int64)
+			if err != nil && !errors.Is(err, ErrProfileNotFound) {
+				return err
+			}
+			if email {
+				values[UserEmail] = pd.Email
+			}
+			if mobile {
+				values[UserMobile] = pd.Mobile
+			}
+		}
+
+		return a.indexUserFields(ctx, tx, u.ID, values)
+	})
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/yaitoo/sqle"
+)
+
+func TestCryptoShredding(t *testing.T) {
+	au := createAuthTest("./tests_crypto_shredding.db")
+	ctx := context.Background()
+
+	u, err := au.CreateUser(ctx, UserStatusActivated, "shred@mail.com", "+6588880006", "abc123", "", "")
+	require.NoError(t, err)
+
+	rawProfile := func(id int64) string {
+		var data string
+		err := au.db.QueryRowContext(ctx, "SELECT data FROM test_user_profile WHERE user_id = ?", id).Scan(&data)
+		require.NoError(t, err)
+		return data
+	}
+
+	t.Run("sealed_with_data_key", func(t *testing.T) {
+		data := rawProfile(u.ID.Int64)
+		require.True(t, strings.HasPrefix(data, userDataPrefix))
+
+		// the data key is required, the AES key alone can't decrypt it
+		_, err := decryptText(strings.TrimPrefix(data, userDataPrefix), au.aesKey)
+		require.Error(t, err)
+
+		pd, err := au.GetProfileData(ctx, u.ID.Int64)
+		require.NoError(t, err)
+		require.Equal(t, "shred@mail.com", pd.Email)
+	})
+
+	t.Run("legacy_data_is_readable", func(t *testing.T) {
+		buf, _ := json.Marshal(ProfileData{Email: "shred@mail.com", Mobile: "+6588880006"})
+		ct, err := encryptText(buf, au.aesKey)
+		require.NoError(t, err)
+
+		_, err = au.db.ExecContext(ctx, "UPDATE test_user_profile SET data = ? WHERE user_id = ?", ct, u.ID.Int64)
+		require.NoError(t, err)
+
+		pd, err := au.GetProfileData(ctx, u.ID.Int64)
+		require.NoError(t, err)
+		require.Equal(t, "+6588880006", pd.Mobile)
+
+		// it is sealed with the data key once it is updated
+		require.NoError(t, au.UpdateProfile(ctx, u.ID.Int64, "shred@mail.com", "+6588880007"))
+		require.True(t, strings.HasPrefix(rawProfile(u.ID.Int64), userDataPrefix))
+	})
+
+	t.Run("shredded_on_purge", func(t *testing.T) {
+		// a copy of the profile in backups
+		backup := rawProfile(u.ID.Int64)
+
+		require.NoError(t, au.DeleteUser(ctx, u.ID.Int64))
+		_, err := au.db.ExecContext(ctx, "UPDATE test_user SET deleted_at = ? WHERE id = ?", time.Now().Add(-au.deleteGrace-time.Minute), u.ID.Int64)
+		require.NoError(t, err)
+
+		n, err := au.PurgeDeletedUsers(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+
+		_, err = au.getUserKey(ctx, au.db.On(u.ID), u.ID)
+		require.ErrorIs(t, err, ErrUserDataShredded)
+
+		// the backup is restored, but it can't be decrypted anymore
+		_, err = au.db.ExecContext(ctx, "INSERT INTO test_user_profile(user_id, data, created_at, updated_at) VALUES(?, ?, ?, ?)", u.ID.Int64, backup, time.Now(), time.Now())
+		require.NoError(t, err)
+
+		_, err = au.GetProfileData(ctx, u.ID.Int64)
+		require.ErrorIs(t, err, ErrUserDataShredded)
+	})
+}
+
+func TestKeyStore(t *testing.T) {
+	au := createAuthTest("./tests_key_store.db")
+	ctx := context.Background()
+
+	os.Remove("./tests_key_store_keys.db")
+	db, err := sql.Open("sqlite3", "file:./tests_key_store_keys.db?cache=shared&mode=rwc")
+	require.NoError(t, err)
+	ks := NewDBKeyStore(sqle.Open(db), "keys")
+	require.NoError(t, ks.Init(ctx))
+
+	countTableKeys := func(id int64) int {
+		var n int
+		err := au.db.QueryRowContext(ctx, "SELECT count(*) FROM test_user_key WHERE user_id = ?", id).Scan(&n)
+		require.NoError(t, err)
+		return n
+	}
+
+	// the user is created before the key store is used
+	legacy, err := au.CreateUser(ctx, UserStatusActivated, "legacy@mail.com", "", "abc123", "", "")
+	require.NoError(t, err)
+
+	buf, _ := json.Marshal(ProfileData{Email: "legacy@mail.com"})
+	ct, err := encryptText(buf, au.aesKey)
+	require.NoError(t, err)
+	_, err = au.db.ExecContext(ctx, "UPDATE test_user_profile SET data = ? WHERE user_id = ?", ct, legacy.ID.Int64)
+	require.NoError(t, err)
+
+	au.keyStore = ks
+
+	t.Run("outside_db", func(t *testing.T) {
+		u, err := au.CreateUser(ctx, UserStatusActivated, "keystore@mail.com", "", "abc123", "", "")
+		require.NoError(t, err)
+
+		require.Zero(t, countTableKeys(u.ID.Int64))
+		_, err = ks.Get(ctx, u.ID.Int64)
+		require.NoError(t, err)
+
+		pd, err := au.GetProfileData(ctx, u.ID.Int64)
+		require.NoError(t, err)
+		require.Equal(t, "keystore@mail.com", pd.Email)
+
+		require.NoError(t, au.DeleteUser(ctx, u.ID.Int64))
+		_, err = au.db.ExecContext(ctx, "UPDATE test_user SET deleted_at = ? WHERE id = ?", time.Now().Add(-au.deleteGrace-time.Minute), u.ID.Int64)
+		require.NoError(t, err)
+
+		_, err = au.PurgeDeletedUsers(ctx)
+		require.NoError(t, err)
+
+		_, err = ks.Get(ctx, u.ID.Int64)
+		require.ErrorIs(t, err, ErrUserDataShredded)
+	})
+
+	t.Run("purge_shredded", func(t *testing.T) {
+		u, err := au.CreateUser(ctx, UserStatusActivated, "shredded@mail.com", "", "abc123", "", "")
+		require.NoError(t, err)
+
+		require.NoError(t, au.DeleteUser(ctx, u.ID.Int64))
+		_, err = au.db.ExecContext(ctx, "UPDATE test_user SET deleted_at = ? WHERE id = ?", time.Now().Add(-au.deleteGrace-time.Minute), u.ID.Int64)
+		require.NoError(t, err)
+
+		// the key is destroyed by a purge that fails to delete the rows
+		require.NoError(t, ks.Delete(ctx, u.ID.Int64))
+
+		_, err = au.PurgeDeletedUsers(ctx)
+		require.NoError(t, err)
+
+		_, err = au.getUserByID(ctx, u.ID)
+		require.ErrorIs(t, err, ErrUserNotFound)
+	})
+
+	t.Run("reseal", func(t *testing.T) {
+		// the data key in the table is used until it is moved
+		require.Equal(t, 1, countTableKeys(legacy.ID.Int64))
+		pd, err := au.GetProfileData(ctx, legacy.ID.Int64)
+		require.NoError(t, err)
+		require.Equal(t, "legacy@mail.com", pd.Email)
+
+		n, err := au.ResealUserData(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+
+		require.Zero(t, countTableKeys(legacy.ID.Int64))
+		_, err = ks.Get(ctx, legacy.ID.Int64)
+		require.NoError(t, err)
+
+		var data string
+		err = au.db.QueryRowContext(ctx, "SELECT data FROM test_user_profile WHERE user_id = ?", legacy.ID.Int64).Scan(&data)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(data, userDataPrefix))
+
+		pd, err = au.GetProfileData(ctx, legacy.ID.Int64)
+		require.NoError(t, err)
+		require.Equal(t, "legacy@mail.com", pd.Email)
+
+		n, err = au.ResealUserData(ctx)
+		require.NoError(t, err)
+		require.Zero(t, n)
+	})
+}
@@ -20,7 +20,8 @@ func (a *Auth) createBuilder() *sqle.Builder {
 func (a *Auth) getUserByID(ctx context.Context, uid shardid.ID) (User, error) {
 	var u User
 
-	err := a.db.On(uid).
+	db := a.db.On(uid)
+	err := db.
 		QueryRowBuilder(ctx, a.createBuilder().
 			Select("<prefix>user").
 			Where("id = {id}").Param("id", uid.Int64)).
@@ -38,7 +39,7 @@ func (a *Auth) getUserByID(ctx context.Context, uid shardid.ID) (User, error) {
 		return u, ErrBadDatabase
 	}
 
-	return u, nil
+	return u, a.decryptUser(ctx, db, &u)
 }
 
 func (a *Auth) deleteUserToken(ctx context.Context, uid shardid.ID, token string) error {
@@ -78,16 +79,26 @@ func (a *Auth) createUser(ctx context.Context, conn sqle.Connector, id shardid.I
 	u.CreatedAt = now
 	u.UpdatedAt = now
 
-	_, err := conn.ExecBuilder(ctx, a.createBuilder().
+	fields, err := a.encryptUserFields(ctx, conn, id, map[UserField]string{
+		UserFirstName: firstName,
+		UserLastName:  lastName,
+		UserEmail:     masker.Email(email),
+		UserMobile:    masker.Mobile(mobile),
+	})
+	if err != nil {
+		return u, err
+	}
+
+	_, err = conn.ExecBuilder(ctx, a.createBuilder().
 		Insert("<prefix>user").
 		Set("id", id).
 		Set("status", status).
-		Set("first_name", firstName).
-		Set("last_name", lastName).
+		Set("first_name", fields[UserFirstName]).
+		Set("last_name", fields[UserLastName]).
 		Set("passwd", u.Passwd).
 		Set("salt", u.Salt).
-		Set("email", masker.Email(email)).
-		Set("mobile", masker.Mobile(mobile)).
+		Set("email", fields[UserEmail]).
+		Set("mobile", fields[UserMobile]).
 		Set("created_at", now).
 		Set("updated_at", now).
 		End())
@@ -99,6 +110,17 @@ func (a *Auth) createUser(ctx context.Context, conn sqle.Connector, id shardid.I
 			slog.Any("err", err))
 		return u, ErrBadDatabase
 	}
+
+	err = a.indexUserFields(ctx, conn, id, map[UserField]string{
+		UserFirstName: firstName,
+		UserLastName:  lastName,
+		UserEmail:     email,
+		UserMobile:    mobile,
+	})
+	if err != nil {
+		return u, err
+	}
+
 	return u, nil
 }
 
@@ -121,56 +143,211 @@ func (a *Auth) deleteUser(ctx context.Context, conn sqle.Connector, id shardid.I
 	return nil
 }
 
-func (a *Auth) createLoginCode(ctx context.Context, userID shardid.ID, userIP string) (string, error) {
+// codePurpose what a code in login_code is issued for, a code can be consumed for its purpose only
+type codePurpose string
+
+const (
+	codeLogin        codePurpose = "login"
+	codeMagicLink    codePurpose = "magic_link"
+	codeMFA          codePurpose = "mfa"
+	codeMFASetup     codePurpose = "mfa:setup"
+	codeVerifyMobile codePurpose = "verify:mobile"
+	codeChangeMobile codePurpose = "change:mobile"
+)
+
+// createLoginCode creates a code for user, the older codes of the purpose are superseded by it
+func (a *Auth) createLoginCode(ctx context.Context, userID shardid.ID, purpose codePurpose, userIP string) (string, error) {
 	code := randStr(a.loginCodeSize, dicNumber)
 
+	err := a.saveLoginCode(ctx, userID, purpose, code, userIP, a.loginCodeTTL)
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// saveLoginCode saves the code that expires after ttl, the older codes of the purpose are superseded by it
+func (a *Auth) saveLoginCode(ctx context.Context, userID shardid.ID, purpose codePurpose, code, userIP string, ttl time.Duration) error {
 	now := time.Now()
 
-	_, err := a.db.On(userID).
-		ExecBuilder(ctx, a.createBuilder().
+	err := a.db.On(userID).Transaction(ctx, &sql.TxOptions{}, func(ctx context.Context, tx *sqle.Tx) error {
+		_, err := tx.ExecBuilder(ctx, a.createBuilder().
+			Delete("<prefix>login_code").
+			Where("user_id = {user_id} AND purpose = {purpose}").
+			Param("user_id", userID.Int64).
+			Param("purpose", purpose))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecBuilder(ctx, a.createBuilder().
 			Insert("<prefix>login_code").
 			Set("user_id", userID.Int64).
+			Set("purpose", purpose).
 			Set("hash", generateHash(a.hash(), code, "")).
 			Set("user_ip", userIP).
-			Set("expires_on", now.Add(a.loginCodeTTL)).
+			Set("expires_on", now.Add(ttl)).
 			Set("created_at", now).
 			End())
 
+		return err
+	})
+
 	if err != nil {
-		a.logger.Error("auth: createLoginCode",
+		a.logger.Error("auth: saveLoginCode",
 			slog.Int64("user_id", userID.Int64),
+			slog.String("purpose", string(purpose)),
 			slog.Any("err", err))
-		return "", ErrBadDatabase
+		return ErrBadDatabase
 	}
-	return code, nil
+	return nil
 }
 
-func (a *Auth) getLoginCodeUserIP(ctx context.Context, userID shardid.ID, code string) (string, error) {
+// consumeLoginCode checks the code of the purpose and deletes it, so it can be used only once. It returns the ip that the code is created for.
+// A wrong guess counts against user's code of the purpose, and the code is invalidated after loginCodeAttempts wrong guesses.
+func (a *Auth) consumeLoginCode(ctx context.Context, userID shardid.ID, purpose codePurpose, code string) (string, error) {
 	h := generateHash(a.hash(), code, "")
+	db := a.db.On(userID)
 
 	var userIP string
-	err := a.db.On(userID).
-		QueryRowBuilder(ctx, a.createBuilder().
-			Select("<prefix>login_code", "user_ip").
-			Where("user_id = {user_id} AND hash = {hash}").
-			Param("user_id", userID.Int64).
-			Param("hash", h)).
-		Scan(&userIP)
+	var expiresOn time.Time
+	err := db.QueryRowBuilder(ctx, a.createBuilder().
+		Select("<prefix>login_code", "user_ip", "expires_on").
+		Where("user_id = {user_id} AND purpose = {purpose} AND hash = {hash}").
+		Param("user_id", userID.Int64).
+		Param("purpose", purpose).
+		Param("hash", h)).
+		Scan(&userIP, &expiresOn)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", ErrCodeNotMatched
+			return "", a.failLoginCode(ctx, userID, purpose)
 		}
-		a.logger.Error("auth: checkLoginCode",
+		a.logger.Error("auth: consumeLoginCode",
 			slog.Int64("user_id", userID.Int64),
-			slog.String("code", code),
 			slog.Any("err", err))
 		return "", ErrBadDatabase
 	}
 
+	r, err := db.ExecBuilder(ctx, a.createBuilder().
+		Delete("<prefix>login_code").
+		Where("user_id = {user_id} AND purpose = {purpose} AND hash = {hash}").
+		Param("user_id", userID.Int64).
+		Param("purpose", purpose).
+		Param("hash", h))
+
+	if err != nil {
+		a.logger.Error("auth: consumeLoginCode:Delete",
+			slog.Int64("user_id", userID.Int64),
+			slog.Any("err", err))
+		return "", ErrBadDatabase
+	}
+
+	// it has been consumed by another request
+	if n, _ := r.RowsAffected(); n == 0 {
+		return "", ErrCodeNotMatched
+	}
+
+	if !time.Now().Before(expiresOn) {
+		return "", ErrCodeExpired
+	}
+
 	return userIP, nil
 }
 
+// failLoginCode counts a wrong guess, and deletes the code of the purpose if it is guessed too many times
+func (a *Auth) failLoginCode(ctx context.Context, userID shardid.ID, purpose codePurpose) error {
+	db := a.db.On(userID)
+
+	_, err := db.ExecBuilder(ctx, a.createBuilder().
+		Update("<prefix>login_code").
+		SetExpr("`fails` = `fails` + 1").
+		Where("user_id = {user_id} AND purpose = {purpose}").
+		Param("user_id", userID.Int64).
+		Param("purpose", purpose))
+
+	if err != nil {
+		a.logger.Error("auth: failLoginCode",
+			slog.Int64("user_id", userID.Int64),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	r, err := db.ExecBuilder(ctx, a.createBuilder().
+		Delete("<prefix>login_code").
+		Where("user_id = {user_id} AND purpose = {purpose} AND fails >= {fails}").
+		Param("user_id", userID.Int64).
+		Param("purpose", purpose).
+		Param("fails", a.loginCodeAttempts))
+
+	if err != nil {
+		a.logger.Error("auth: failLoginCode:Delete",
+			slog.Int64("user_id", userID.Int64),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	if n, _ := r.RowsAffected(); n > 0 {
+		return ErrCodeAttemptsExceeded
+	}
+
+	return ErrCodeNotMatched
+}
+
+// createLoginSession creates session for user who passed all factors of a login if the login is not blocked by risk engine, and logs the login
+func (a *Auth) createLoginSession(ctx context.Context, u User, method LoginMethod, userIP, userAgent string) (Session, error) {
+	risk, err := a.assessRisk(ctx, u, method, userIP, userAgent)
+	if err != nil {
+		return noSession, err
+	}
+
+	return a.createAssessedSession(ctx, u, method, userIP, userAgent, risk)
+}
+
+// createAssessedSession creates session for the login that risk is assessed, it is failed if the risk is blocked or user's status doesn't allow to sign in
+func (a *Auth) createAssessedSession(ctx context.Context, u User, method LoginMethod, userIP, userAgent string, risk Risk) (Session, error) {
+	if err := a.checkUserStatus(u); err != nil {
+		a.createLoginLog(ctx, u.ID, method, false, userIP, userAgent, risk)
+		return noSession, err
+	}
+
+	if risk.Decision == RiskBlock {
+		a.createLoginLog(ctx, u.ID, method, false, userIP, userAgent, risk)
+		return noSession, ErrLoginBlocked
+	}
+
+	err := a.resetLoginFails(ctx, u.ID, userIP, userAgent)
+	if err != nil {
+		return noSession, err
+	}
+
+	a.createLoginLog(ctx, u.ID, method, true, userIP, userAgent, risk)
+
+	isNew, err := a.trackDevice(ctx, u.ID, userAgent)
+	if err != nil {
+		return noSession, err
+	}
+
+	err = a.trackGeo(ctx, u.ID, userIP)
+	if err != nil {
+		return noSession, err
+	}
+
+	if isNew {
+		a.alertNewDevice(ctx, u, userIP, userAgent)
+	}
+
+	s, err := a.createSession(ctx, u.ID, u.FirstName, u.LastName, userIP, userAgent)
+	if err != nil {
+		return noSession, err
+	}
+
+	s.NewDevice = isNew
+
+	return s, nil
+}
+
 func (a *Auth) createSession(ctx context.Context, userID shardid.ID, firstName, lastName, userIP, userAgent string) (Session, error) {
 	s := Session{
 		UserID:    userID.Int64,
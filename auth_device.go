@@ -0,0 +1,391 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/yaitoo/sqle/shardid"
+)
+
+const (
+	tokenTrustedDevice = "device:trust"
+
+	deviceUALen   = 255
+	deviceNameLen = 45
+)
+
+// ListDevices returns the devices that user signed in from.
+func (a *Auth) ListDevices(ctx context.Context, uid int64) ([]Device, error) {
+	var items []Device
+	rows, err := a.db.On(shardid.Parse(uid)).
+		QueryBuilder(ctx, a.createBuilder().
+			Select("<prefix>user_device").
+			Where("user_id = {user_id}").
+			Param("user_id", uid).
+			SQL(" ORDER BY updated_at DESC"))
+
+	if err != nil {
+		a.logger.Error("auth: ListDevices",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid),
+			slog.Any("err", err))
+		return nil, ErrBadDatabase
+	}
+
+	err = rows.Bind(&items)
+	if err != nil {
+		a.logger.Error("auth: ListDevices:Bind",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid),
+			slog.Any("err", err))
+		return nil, ErrBadDatabase
+	}
+
+	return items, nil
+}
+
+// ListTrustedDevices returns the devices that are remembered to skip MFA.
+func (a *Auth) ListTrustedDevices(ctx context.Context, uid int64) ([]Device, error) {
+	var items []Device
+	rows, err := a.db.On(shardid.Parse(uid)).
+		QueryBuilder(ctx, a.createBuilder().
+			Select("<prefix>user_device").
+			Where("user_id = {user_id} AND trust_hash IS NOT NULL").
+			Param("user_id", uid))
+
+	if err != nil {
+		a.logger.Error("auth: ListTrustedDevices",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid),
+			slog.Any("err", err))
+		return nil, ErrBadDatabase
+	}
+
+	err = rows.Bind(&items)
+	if err != nil {
+		a.logger.Error("auth: ListTrustedDevices:Bind",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid),
+			slog.Any("err", err))
+		return nil, ErrBadDatabase
+	}
+
+	now := time.Now()
+	trusted := items[:0]
+	for _, d := range items {
+		if d.TrustedUntil.Valid && now.Before(d.TrustedUntil.Time()) {
+			trusted = append(trusted, d)
+		}
+	}
+
+	return trusted, nil
+}
+
+// RevokeTrustedDevice forgets the device, its trusted-device token can't skip MFA anymore.
+func (a *Auth) RevokeTrustedDevice(ctx context.Context, uid int64, ua string) error {
+	r, err := a.db.On(shardid.Parse(uid)).
+		ExecBuilder(ctx, a.createBuilder().
+			Update("<prefix>user_device").
+			Set("trust_hash", nil).
+			Set("trusted_until", nil).
+			Where("user_id = {user_id} AND ua = {ua} AND trust_hash IS NOT NULL").
+			Param("user_id", uid).
+			Param("ua", deviceUA(ua)))
+
+	if err != nil {
+		a.logger.Error("auth: RevokeTrustedDevice",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	if n, _ := r.RowsAffected(); n == 0 {
+		return ErrDeviceNotFound
+	}
+
+	return nil
+}
+
+// trustDevice remembers the device, and issues a trusted-device token that is bound to the user and the device
+func (a *Auth) trustDevice(ctx context.Context, uid shardid.ID, userAgent string) (string, error) {
+	ua := deviceUA(userAgent)
+	token, err := a.signToken(tokenTrustedDevice, TokenClaims{
+		ID:    uid.Int64,
+		Data:  hashToken(ua),
+		Nonce: randStr(12, dicAlphaNumber),
+	}, a.trustedDeviceTTL)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	db := a.db.On(uid)
+
+	update := func() (int64, error) {
+		r, err := db.ExecBuilder(ctx, a.createBuilder().
+			Update("<prefix>user_device").
+			Set("trust_hash", hashToken(token)).
+			Set("trusted_until", now.Add(a.trustedDeviceTTL)).
+			Set("updated_at", now).
+			Where("user_id = {user_id} AND ua = {ua}").
+			Param("user_id", uid.Int64).
+			Param("ua", ua))
+		if err != nil {
+			return 0, err
+		}
+		return r.RowsAffected()
+	}
+
+	n, err := update()
+	if err == nil && n == 0 {
+		_, err = db.ExecBuilder(ctx, a.createBuilder().
+			Insert("<prefix>user_device").
+			Set("user_id", uid.Int64).
+			Set("ua", ua).
+			Set("name", deviceName(ua)).
+			Set("login_times", 0).
+			Set("trust_hash", hashToken(token)).
+			Set("trusted_until", now.Add(a.trustedDeviceTTL)).
+			Set("created_at", now).
+			Set("updated_at", now).
+			End())
+
+		// the device is inserted by a concurrent login, it is updated instead
+		if err != nil {
+			if n, _ = update(); n > 0 {
+				err = nil
+			}
+		}
+	}
+
+	if err != nil {
+		a.logger.Error("auth: trustDevice",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid.Int64),
+			slog.Any("err", err))
+		return "", ErrBadDatabase
+	}
+
+	return token, nil
+}
+
+// trackDevice counts a login on the device, and reports if it is the first login on the device
+func (a *Auth) trackDevice(ctx context.Context, uid shardid.ID, userAgent string) (bool, error) {
+	ua := deviceUA(userAgent)
+	now := time.Now()
+	db := a.db.On(uid)
+
+	var loginTimes int
+	get := func() error {
+		return db.QueryRowBuilder(ctx, a.createBuilder().
+			Select("<prefix>user_device", "login_times").
+			Where("user_id = {user_id} AND ua = {ua}").
+			Param("user_id", uid.Int64).
+			Param("ua", ua)).
+			Scan(&loginTimes)
+	}
+
+	incr := func() error {
+		_, err := db.ExecBuilder(ctx, a.createBuilder().
+			Update("<prefix>user_device").
+			SetExpr("`login_times` = `login_times` + 1").
+			Set("updated_at", now).
+			Where("user_id = {user_id} AND ua = {ua}").
+			Param("user_id", uid.Int64).
+			Param("ua", ua))
+		return err
+	}
+
+	err := get()
+	if err == nil {
+		err = incr()
+	} else if errors.Is(err, sql.ErrNoRows) {
+		_, err = db.ExecBuilder(ctx, a.createBuilder().
+			Insert("<prefix>user_device").
+			Set("user_id", uid.Int64).
+			Set("ua", ua).
+			Set("name", deviceName(ua)).
+			Set("login_times", 1).
+			Set("created_at", now).
+			Set("updated_at", now).
+			End())
+
+		// the device is inserted by a concurrent login or trustDevice, it is counted on the inserted row instead
+		if err != nil && get() == nil {
+			err = incr()
+		}
+	}
+
+	if err != nil {
+		a.logger.Error("auth: trackDevice",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid.Int64),
+			slog.Any("err", err))
+		return false, ErrBadDatabase
+	}
+
+	// a device that is remembered before its first login is new too
+	return loginTimes == 0, nil
+}
+
+// alertNewDevice raises a new device event, and notifies user by email if it is not the first device of user
+func (a *Auth) alertNewDevice(ctx context.Context, u User, userIP, userAgent string) {
+	now := time.Now()
+	name := deviceName(deviceUA(userAgent))
+
+	a.emit(ctx, Event{
+		Type:     EventNewDevice,
+		Time:     now,
+		UserID:   u.ID.Int64,
+		IP:       userIP,
+		UA:       userAgent,
+		Metadata: map[string]any{"device": name},
+	})
+
+	var n int
+	err := a.db.On(u.ID).
+		QueryRowBuilder(ctx, a.createBuilder().
+			Select("<prefix>user_device", "count(user_id)").
+			Where("user_id = {user_id} AND login_times > 0").
+			Param("user_id", u.ID.Int64)).
+		Scan(&n)
+
+	if err != nil {
+		a.logger.Error("auth: alertNewDevice",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", u.ID.Int64),
+			slog.Any("err", err))
+		return
+	}
+
+	if n < 2 {
+		return
+	}
+
+	// email in user is masked
+	pd, err := a.GetProfileData(ctx, u.ID.Int64)
+	if err != nil || pd.Email == "" {
+		return
+	}
+
+	a.notify(ctx, ChannelEmail, MessageNewDevice, "", pd.Email, MessageData{Device: name, IP: userIP, Time: now}) // nolint: errcheck
+}
+
+// isTrustedDevice checks if the trusted-device token is issued to the user on this device, and it is not revoked
+func (a *Auth) isTrustedDevice(ctx context.Context, uid shardid.ID, token, userAgent string) bool {
+	if token == "" {
+		return false
+	}
+
+	c, err := a.parseToken(tokenTrustedDevice, token)
+	if err != nil {
+		return false
+	}
+
+	ua := deviceUA(userAgent)
+	if c.ID != uid.Int64 || c.Data != hashToken(ua) {
+		return false
+	}
+
+	var d Device
+	err = a.db.On(uid).
+		QueryRowBuilder(ctx, a.createBuilder().
+			Select("<prefix>user_device").
+			Where("user_id = {user_id} AND ua = {ua}").
+			Param("user_id", uid.Int64).
+			Param("ua", ua)).
+		Bind(&d)
+
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			a.logger.Error("auth: isTrustedDevice",
+				slog.String("tag", "db"),
+				slog.Int64("user_id", uid.Int64),
+				slog.Any("err", err))
+		}
+		return false
+	}
+
+	return d.TrustHash.String() == hashToken(token) &&
+		d.TrustedUntil.Valid && time.Now().Before(d.TrustedUntil.Time())
+}
+
+// deviceUA returns the user agent that fits in user_device
+func deviceUA(ua string) string {
+	if len(ua) > deviceUALen {
+		return ua[:deviceUALen]
+	}
+	return ua
+}
+
+// deviceName returns a friendly name of the user agent, eg: Chrome on macOS
+func deviceName(ua string) string {
+	browser := matchUA(ua, uaBrowsers)
+	platform := matchUA(ua, uaPlatforms)
+
+	var name string
+	switch {
+	case browser != "" && platform != "":
+		name = browser + " on " + platform
+	case browser != "":
+		name = browser
+	case platform != "":
+		name = platform
+	case ua == "":
+		name = "Unknown device"
+	default:
+		name = ua
+	}
+
+	if n := []rune(name); len(n) > deviceNameLen {
+		return string(n[:deviceNameLen])
+	}
+	return name
+}
+
+type uaRule struct {
+	token string
+	name  string
+}
+
+// uaBrowsers browser rules in priority order, eg: Edge and Opera are Chrome-based, and Chrome has Safari token too
+var uaBrowsers = []uaRule{
+	{"Edg", "Edge"},
+	{"OPR/", "Opera"},
+	{"Opera", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"MSIE ", "Internet Explorer"},
+	{"Trident/", "Internet Explorer"},
+	{"curl/", "curl"},
+}
+
+// uaPlatforms platform rules in priority order, eg: Android has Linux token too
+var uaPlatforms = []uaRule{
+	{"iPhone", "iPhone"},
+	{"iPad", "iPad"},
+	{"Android", "Android"},
+	{"Windows", "Windows"},
+	{"CrOS", "ChromeOS"},
+	{"Mac OS X", "macOS"},
+	{"Macintosh", "macOS"},
+	{"Linux", "Linux"},
+}
+
+func matchUA(ua string, rules []uaRule) string {
+	for _, r := range rules {
+		if strings.Contains(ua, r.token) {
+			return r.name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedDevice(t *testing.T) {
+	au := createAuthTest("./tests_device.db")
+	ctx := context.Background()
+
+	u, err := au.CreateUser(ctx, UserStatusActivated, "device@mail.com", "", "abc123", "", "")
+	require.NoError(t, err)
+
+	setup, err := au.BeginMFADevice(ctx, u.ID.Int64, MFATOTP, "phone", "")
+	require.NoError(t, err)
+	code, err := totp.GenerateCode(setup.Secret, time.Now())
+	require.NoError(t, err)
+	phone, err := au.FinishMFADevice(ctx, u.ID.Int64, setup.Token, code)
+	require.NoError(t, err)
+
+	laptop := LoginOption{UserIP: "127.0.0.1", UserAgent: "laptop"}
+
+	t.Run("remember", func(t *testing.T) {
+		s, err := au.Login(ctx, "device@mail.com", "abc123", laptop)
+		require.ErrorIs(t, err, ErrMFARequired)
+
+		// not remembered
+		session, err := au.VerifyMFA(ctx, s.MFA.Token, phone.ID, code, false, ClientInfo{UserAgent: "laptop"})
+		require.NoError(t, err)
+		require.Empty(t, session.TrustedDevice)
+
+		// a code of the next time step, the code that is used can't be replayed
+		next, err := totp.GenerateCode(setup.Secret, time.Now().Add(totpPeriod))
+		require.NoError(t, err)
+		session, err = au.VerifyMFA(ctx, s.MFA.Token, phone.ID, next, true, ClientInfo{UserAgent: "laptop"})
+		require.NoError(t, err)
+		require.NotEmpty(t, session.TrustedDevice)
+		laptop.TrustedDevice = session.TrustedDevice
+	})
+
+	t.Run("skip_mfa", func(t *testing.T) {
+		s, err := au.Login(ctx, "device@mail.com", "abc123", laptop)
+		require.NoError(t, err)
+		require.Nil(t, s.MFA)
+		require.NotEmpty(t, s.AccessToken)
+
+		devices, err := au.ListTrustedDevices(ctx, u.ID.Int64)
+		require.NoError(t, err)
+		require.Len(t, devices, 1)
+		require.Equal(t, "laptop", devices[0].UA)
+	})
+
+	t.Run("other_device", func(t *testing.T) {
+		// token is bound to the device
+		_, err := au.Login(ctx, "device@mail.com", "abc123", LoginOption{UserAgent: "phone", TrustedDevice: laptop.TrustedDevice})
+		require.ErrorIs(t, err, ErrMFARequired)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		require.NoError(t, au.RevokeTrustedDevice(ctx, u.ID.Int64, "laptop"))
+		require.ErrorIs(t, au.RevokeTrustedDevice(ctx, u.ID.Int64, "laptop"), ErrDeviceNotFound)
+
+		// revoked device is challenged again
+		_, err := au.Login(ctx, "device@mail.com", "abc123", laptop)
+		require.ErrorIs(t, err, ErrMFARequired)
+
+		devices, err := au.ListTrustedDevices(ctx, u.ID.Int64)
+		require.NoError(t, err)
+		require.Empty(t, devices)
+	})
+}
+
+func TestDeviceName(t *testing.T) {
+	tests := []struct {
+		ua   string
+		name string
+	}{
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", "Chrome on macOS"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0", "Edge on Windows"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1", "Safari on iPhone"},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0", "Firefox on Linux"},
+		{"laptop", "laptop"},
+		{"", "Unknown device"},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.name, deviceName(test.ua))
+	}
+}
+
+func TestNewDevice(t *testing.T) {
+	au := createAuthTest("./tests_new_device.db")
+	n := NewMemoryNotifier()
+	au.notifier = n
+	sink := NewMemoryEventSink()
+	au.eventSink = sink
+	ctx := context.Background()
+
+	u, err := au.CreateUser(ctx, UserStatusActivated, "newdevice@mail.com", "", "abc123", "", "")
+	require.NoError(t, err)
+
+	mac := "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	iphone := "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1"
+
+	// first device of user is not alerted by email
+	s, err := au.Login(ctx, "newdevice@mail.com", "abc123", LoginOption{UserIP: "1.1.1.1", UserAgent: mac})
+	require.NoError(t, err)
+	require.True(t, s.NewDevice)
+	_, ok := n.Last("newdevice@mail.com")
+	require.False(t, ok)
+
+	s, err = au.Login(ctx, "newdevice@mail.com", "abc123", LoginOption{UserIP: "1.1.1.1", UserAgent: mac})
+	require.NoError(t, err)
+	require.False(t, s.NewDevice)
+
+	s, err = au.Login(ctx, "newdevice@mail.com", "abc123", LoginOption{UserIP: "2.2.2.2", UserAgent: iphone})
+	require.NoError(t, err)
+	require.True(t, s.NewDevice)
+
+	msg, ok := n.Last("newdevice@mail.com")
+	require.True(t, ok)
+	require.Equal(t, MessageNewDevice, msg.Type)
+	require.Contains(t, msg.Text, "Safari on iPhone")
+	require.Contains(t, msg.Text, "2.2.2.2")
+
+	var alerts int
+	for _, e := range sink.Events() {
+		if e.Type == EventNewDevice {
+			alerts++
+		}
+	}
+	require.Equal(t, 2, alerts)
+
+	devices, err := au.ListDevices(ctx, u.ID.Int64)
+	require.NoError(t, err)
+	require.Len(t, devices, 2)
+	require.Equal(t, "Safari on iPhone", devices[0].Name)
+	require.Equal(t, 1, devices[0].LoginTimes)
+	require.Equal(t, "Chrome on macOS", devices[1].Name)
+	require.Equal(t, 2, devices[1].LoginTimes)
+}
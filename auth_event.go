@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// emit sends the event to event sink. It doesn't fail the call, errors are logged only.
+func (a *Auth) emit(ctx context.Context, e Event) {
+	if a.eventSink == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	err := a.eventSink.Emit(ctx, e)
+	if err != nil {
+		a.logger.Error("auth: emit",
+			slog.String("tag", "event"),
+			slog.String("type", string(e.Type)),
+			slog.Int64("user_id", e.UserID),
+			slog.Any("err", err))
+	}
+}
+
+// emitRoleChanged emits a role change for each user, or one for the role if perms of the role are changed
+func (a *Auth) emitRoleChanged(ctx context.Context, rid int, action string, uIDs []int64, codes []string) {
+	actor, ip, ua := getActor(ctx)
+
+	e := Event{
+		Type:     EventRoleChanged,
+		ActorID:  actor,
+		IP:       ip,
+		UA:       ua,
+		Metadata: map[string]any{"role_id": rid, "action": action},
+	}
+
+	if len(codes) > 0 {
+		e.Metadata["codes"] = codes
+	}
+
+	if len(uIDs) == 0 {
+		a.emit(ctx, e)
+		return
+	}
+
+	for _, uid := range uIDs {
+		e.UserID = uid
+		a.emit(ctx, e)
+	}
+}
@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yaitoo/sqle"
+	"github.com/yaitoo/sqle/shardid"
+)
+
+const exportAuditPageSize = 100
+
+// ExportSession a refresh token of user without its hash
+type ExportSession struct {
+	UserIP    string    `json:"userIP"`
+	UserAgent string    `json:"userAgent"`
+	ExpiresOn time.Time `json:"expiresOn"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ExportOpenID an OpenID account that is linked to user
+type ExportOpenID struct {
+	OpenIDUser string    `json:"openIDUser"`
+	OpenIDApp  string    `json:"openIDApp"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// ExportUserData collects everything that is stored about user across shards into a zip of JSON files, eg: for GDPR/CCPA requests.
+// Secrets like password hash, TOTP key, token hashes and public keys are not exported.
+func (a *Auth) ExportUserData(ctx context.Context, uid int64) (io.Reader, error) {
+	id := shardid.Parse(uid)
+
+	u, err := a.getUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	pd, err := a.GetProfileData(ctx, uid)
+	if err != nil && !errors.Is(err, ErrProfileNotFound) {
+		return nil, err
+	}
+	pd.TKey = ""
+
+	files := []struct {
+		name string
+		load func() (any, error)
+	}{
+		{"user.json", func() (any, error) { return u, nil }},
+		{"profile.json", func() (any, error) { return pd, nil }},
+		{"roles.json", func() (any, error) { return a.GetUserRoles(ctx, uid) }},
+		{"perms.json", func() (any, error) { return a.GetUserPerms(ctx, uid) }},
+		{"sessions.json", func() (any, error) { return a.exportSessions(ctx, id) }},
+		{"login_logs.json", func() (any, error) { return a.exportLoginLogs(ctx, id) }},
+		{"devices.json", func() (any, error) { return a.ListDevices(ctx, uid) }},
+		{"geos.json", func() (any, error) { return a.ListUserGeos(ctx, uid) }},
+		{"mfa_devices.json", func() (any, error) { return a.ListMFADevices(ctx, uid) }},
+		{"passkeys.json", func() (any, error) { return a.getPasskeys(ctx, id) }},
+		{"openids.json", func() (any, error) { return a.exportOpenIDs(ctx, id) }},
+		{"audit_logs.json", func() (any, error) { return a.exportAuditLogs(ctx, uid) }},
+	}
+
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+
+	for _, f := range files {
+		v, err := f.load()
+		if err != nil {
+			return nil, err
+		}
+
+		w, err := zw.Create(f.name)
+		if err == nil {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(v)
+		}
+
+		if err != nil {
+			a.logger.Error("auth: ExportUserData",
+				slog.String("tag", "zip"),
+				slog.Int64("user_id", uid),
+				slog.String("file", f.name),
+				slog.Any("err", err))
+			return nil, ErrUnknown
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		a.logger.Error("auth: ExportUserData:Close",
+			slog.String("tag", "zip"),
+			slog.Int64("user_id", uid),
+			slog.Any("err", err))
+		return nil, ErrUnknown
+	}
+
+	return buf, nil
+}
+
+func (a *Auth) exportSessions(ctx context.Context, uid shardid.ID) ([]ExportSession, error) {
+	items := []ExportSession{}
+	err := a.exportRows(ctx, uid, "exportSessions", a.createBuilder().
+		Select("<prefix>user_token", "user_ip", "user_agent", "expires_on", "created_at").
+		Where("user_id = {user_id}").
+		Param("user_id", uid.Int64).
+		SQL(" ORDER BY created_at DESC"), &items)
+
+	return items, err
+}
+
+func (a *Auth) exportLoginLogs(ctx context.Context, uid shardid.ID) ([]LoginLog, error) {
+	items := []LoginLog{}
+	err := a.exportRows(ctx, uid, "exportLoginLogs", a.createBuilder().
+		Select("<prefix>login_log").
+		Where("user_id = {user_id}").
+		Param("user_id", uid.Int64).
+		SQL(" ORDER BY id DESC"), &items)
+
+	return items, err
+}
+
+func (a *Auth) exportOpenIDs(ctx context.Context, uid shardid.ID) ([]ExportOpenID, error) {
+	items := []ExportOpenID{}
+	err := a.exportRows(ctx, uid, "exportOpenIDs", a.createBuilder().
+		Select("<prefix>login_openid", "openid_user", "openid_app", "created_at").
+		Where("user_id = {user_id}").
+		Param("user_id", uid.Int64), &items)
+
+	return items, err
+}
+
+// exportAuditLogs returns all audit logs across shards that are made by user, or that user is the subject of (`user_id`/`user_ids` in metadata).
+// Other users in the logs are redacted by redactAuditLog.
+func (a *Auth) exportAuditLogs(ctx context.Context, uid int64) ([]AuditLog, error) {
+	items := []AuditLog{}
+	id := strconv.FormatInt(uid, 10)
+
+	var cursor int64
+	for {
+		// the LIKE is a coarse filter, the subject is checked on the decoded metadata
+		b := a.createBuilder().Select("<prefix>audit_log")
+		b.Where("(user_id = {user_id} OR metadata LIKE {subject})").
+			If(cursor > 0).And("id < {cursor}").
+			Param("user_id", uid).
+			Param("subject", "%"+id+"%").
+			Param("cursor", cursor)
+
+		b.SQL(" ORDER BY id DESC")
+
+		logs, err := sqle.NewQuery[AuditLog](a.db).QueryLimit(ctx, b, func(i, j AuditLog) bool {
+			return i.ID.Int64 > j.ID.Int64
+		}, exportAuditPageSize)
+
+		if err != nil {
+			a.logger.Error("auth: exportAuditLogs",
+				slog.String("tag", "db"),
+				slog.Int64("user_id", uid),
+				slog.Any("err", err))
+			return nil, ErrBadDatabase
+		}
+
+		for _, l := range logs {
+			if l, ok := redactAuditLog(l, uid); ok {
+				items = append(items, l)
+			}
+		}
+
+		if len(logs) < exportAuditPageSize {
+			return items, nil
+		}
+		cursor = logs[len(logs)-1].ID.Int64
+	}
+}
+
+// redactAuditLog removes other users from l, it returns false if l is neither made by user nor about user.
+// The actor and its ip/ua are removed if it's another user, and so are the ids of other subjects. The logs made by system are kept as they are.
+// The hashes are removed from a redacted log, they could be used to guess the removed values.
+func redactAuditLog(l AuditLog, uid int64) (AuditLog, bool) {
+	md := make(map[string]any)
+	dec := json.NewDecoder(strings.NewReader(l.Metadata))
+	dec.UseNumber()
+	if err := dec.Decode(&md); err != nil {
+		return l, l.UserID.Int64 == uid
+	}
+
+	id := json.Number(strconv.FormatInt(uid, 10))
+	isActor := l.UserID.Int64 == uid
+	isSubject := false
+	redacted := false
+
+	if v, ok := md["user_id"]; ok {
+		if v == id {
+			isSubject = true
+		} else {
+			delete(md, "user_id")
+			redacted = true
+		}
+	}
+
+	if v, ok := md["user_ids"].([]any); ok {
+		ids := []any{}
+		for _, it := range v {
+			if it == id {
+				ids = append(ids, it)
+			}
+		}
+		if len(ids) > 0 {
+			isSubject = true
+		}
+		if len(ids) < len(v) {
+			md["user_ids"] = ids
+			redacted = true
+		}
+	}
+
+	if !isActor && !isSubject {
+		return l, false
+	}
+
+	if !isActor && l.UserID.Int64 != 0 {
+		l.UserID = shardid.ID{}
+		delete(md, "ip")
+		delete(md, "ua")
+		redacted = true
+	}
+
+	if !redacted {
+		return l, true
+	}
+
+	buf, err := json.Marshal(md)
+	if err != nil {
+		return l, false
+	}
+
+	l.Metadata = string(buf)
+	l.PrevHash = ""
+	l.Hash = ""
+
+	return l, true
+}
+
+// exportRows binds all rows of the query on user's shard into dest
+func (a *Auth) exportRows(ctx context.Context, uid shardid.ID, pos string, b *sqle.Builder, dest any) error {
+	rows, err := a.db.On(uid).QueryBuilder(ctx, b)
+	if err == nil {
+		err = rows.Bind(dest)
+	}
+
+	if err != nil {
+		a.logger.Error("auth: "+pos,
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid.Int64),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	return nil
+}
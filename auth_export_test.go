@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportUserData(t *testing.T) {
+	au := createAuthTest("./tests_export.db")
+	ctx := context.Background()
+
+	u, err := au.CreateUser(ctx, UserStatusActivated, "export@mail.com", "+6588880005", "abc123", "Export", "User")
+	require.NoError(t, err)
+
+	rid, err := au.CreateRole(ctx, "exporter")
+	require.NoError(t, err)
+	require.NoError(t, au.RegisterPerm(ctx, "export:read", "export"))
+	require.NoError(t, au.GrantPerms(ctx, rid, "export:read"))
+	require.NoError(t, au.AddRoleUsers(ctx, rid, u.ID.Int64))
+
+	_, err = au.Login(ctx, "export@mail.com", "abc123", LoginOption{UserIP: "1.1.1.1", UserAgent: "laptop"})
+	require.NoError(t, err)
+
+	require.NoError(t, au.UpdateUser(WithActor(ctx, u.ID.Int64), u.ID.Int64, UserStatusActivated, "Exported", "User"))
+
+	export := func(t *testing.T, uid int64) map[string][]byte {
+		r, err := au.ExportUserData(ctx, uid)
+		require.NoError(t, err)
+
+		buf, err := io.ReadAll(r)
+		require.NoError(t, err)
+
+		zr, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+		require.NoError(t, err)
+
+		files := make(map[string][]byte)
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			require.NoError(t, err)
+			files[f.Name], err = io.ReadAll(rc)
+			require.NoError(t, err)
+			rc.Close()
+		}
+
+		return files
+	}
+
+	t.Run("files", func(t *testing.T) {
+		files := export(t, u.ID.Int64)
+		require.Len(t, files, 12)
+
+		var nu User
+		require.NoError(t, json.Unmarshal(files["user.json"], &nu))
+		require.Equal(t, "Exported", nu.FirstName)
+		require.NotContains(t, string(files["user.json"]), u.Passwd)
+
+		var pd ProfileData
+		require.NoError(t, json.Unmarshal(files["profile.json"], &pd))
+		require.Equal(t, "export@mail.com", pd.Email)
+		require.Equal(t, "+6588880005", pd.Mobile)
+		require.Empty(t, pd.TKey)
+
+		var roles []Role
+		require.NoError(t, json.Unmarshal(files["roles.json"], &roles))
+		require.Len(t, roles, 1)
+		require.Equal(t, "exporter", roles[0].Name)
+
+		var perms []string
+		require.NoError(t, json.Unmarshal(files["perms.json"], &perms))
+		require.Equal(t, []string{"export:read"}, perms)
+
+		var sessions []ExportSession
+		require.NoError(t, json.Unmarshal(files["sessions.json"], &sessions))
+		require.Len(t, sessions, 1)
+		require.Equal(t, "laptop", sessions[0].UserAgent)
+
+		var logs []LoginLog
+		require.NoError(t, json.Unmarshal(files["login_logs.json"], &logs))
+		require.Len(t, logs, 1)
+		require.Equal(t, "1.1.1.1", logs[0].IP)
+
+		var devices []Device
+		require.NoError(t, json.Unmarshal(files["devices.json"], &devices))
+		require.Len(t, devices, 1)
+
+		var geos []UserGeo
+		require.NoError(t, json.Unmarshal(files["geos.json"], &geos))
+		require.Len(t, geos, 1)
+
+		var audits []AuditLog
+		require.NoError(t, json.Unmarshal(files["audit_logs.json"], &audits))
+		require.Len(t, audits, 3)
+		require.Equal(t, AuditUserUpdate, audits[0].Name)
+		require.Equal(t, u.ID.Int64, audits[0].UserID.Int64)
+		// user is the subject of the logs made by system
+		require.Equal(t, AuditRoleAddUsers, audits[1].Name)
+		require.Equal(t, AuditUserCreate, audits[2].Name)
+		require.Zero(t, audits[2].UserID.Int64)
+		require.NotEmpty(t, audits[2].Hash)
+
+		require.JSONEq(t, "[]", string(files["openids.json"]))
+	})
+
+	t.Run("audit_logs_of_subject", func(t *testing.T) {
+		admin, err := au.CreateUser(ctx, UserStatusActivated, "export.admin@mail.com", "", "abc123", "", "")
+		require.NoError(t, err)
+		other, err := au.CreateUser(ctx, UserStatusActivated, "export.other@mail.com", "", "abc123", "", "")
+		require.NoError(t, err)
+
+		actx := context.WithValue(ctx, currentUser, CurrentUser{UserID: admin.ID, UserIP: "9.9.9.9", UserAgent: "admin"})
+		require.NoError(t, au.UpdateUser(actx, u.ID.Int64, UserStatusActivated, "Exported", "User"))
+		require.NoError(t, au.AddRoleUsers(actx, rid, other.ID.Int64, u.ID.Int64))
+		require.NoError(t, au.UpdateUser(actx, other.ID.Int64, UserStatusActivated, "", ""))
+
+		var audits []AuditLog
+		require.NoError(t, json.Unmarshal(export(t, u.ID.Int64)["audit_logs.json"], &audits))
+		require.Len(t, audits, 5)
+
+		// the logs that are made by admin
+		require.Equal(t, AuditRoleAddUsers, audits[0].Name)
+		require.Equal(t, AuditUserUpdate, audits[1].Name)
+		for _, l := range audits[:2] {
+			require.Zero(t, l.UserID.Int64)
+			require.Empty(t, l.Hash)
+			require.NotContains(t, l.Metadata, "9.9.9.9")
+			require.NotContains(t, l.Metadata, strconv.FormatInt(other.ID.Int64, 10))
+		}
+		require.Contains(t, audits[0].Metadata, strconv.FormatInt(u.ID.Int64, 10))
+
+		// the log that is made by user
+		require.Equal(t, u.ID.Int64, audits[2].UserID.Int64)
+		require.NotEmpty(t, audits[2].Hash)
+
+		// admin's own actions are exported with the other users removed
+		require.NoError(t, json.Unmarshal(export(t, admin.ID.Int64)["audit_logs.json"], &audits))
+		require.Len(t, audits, 4)
+		require.Equal(t, AuditUserCreate, audits[3].Name)
+		for _, l := range audits[:3] {
+			require.Equal(t, admin.ID.Int64, l.UserID.Int64)
+			require.Contains(t, l.Metadata, "9.9.9.9")
+			require.NotContains(t, l.Metadata, strconv.FormatInt(u.ID.Int64, 10))
+			require.NotContains(t, l.Metadata, strconv.FormatInt(other.ID.Int64, 10))
+		}
+	})
+}
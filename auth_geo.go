@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/yaitoo/sqle"
+	"github.com/yaitoo/sqle/shardid"
+)
+
+const (
+	geoIPLen      = 39
+	geoCountryLen = 25
+	geoRegionLen  = 45
+
+	geoPageSize = 100
+)
+
+// QueryUsersByCountry returns ids of users who signed in from country(and region if it is not empty), ordered by id.
+// The returned next cursor is 0 if there are no more pages.
+func (a *Auth) QueryUsersByCountry(ctx context.Context, country, region string, cursor int64) ([]int64, int64, error) {
+	b := a.createBuilder().Select("<prefix>user_geo", "user_id")
+	b.Where("country = {country}").
+		If(region != "").And("region = {region}").
+		If(cursor > 0).And("user_id > {cursor}").
+		Param("country", country).
+		Param("region", region).
+		Param("cursor", cursor)
+
+	b.SQL(" GROUP BY user_id ORDER BY user_id")
+
+	items, err := sqle.NewQuery[UserGeo](a.db).QueryLimit(ctx, b, func(i, j UserGeo) bool {
+		return i.UserID.Int64 < j.UserID.Int64
+	}, geoPageSize+1)
+
+	if err != nil {
+		a.logger.Error("auth: QueryUsersByCountry",
+			slog.String("tag", "db"),
+			slog.String("country", country),
+			slog.Any("err", err))
+		return nil, 0, ErrBadDatabase
+	}
+
+	var next int64
+	if len(items) > geoPageSize {
+		items = items[:geoPageSize]
+		next = items[geoPageSize-1].UserID.Int64
+	}
+
+	ids := make([]int64, 0, len(items))
+	for _, it := range items {
+		ids = append(ids, it.UserID.Int64)
+	}
+
+	return ids, next, nil
+}
+
+// QueryUsersByIP returns all accounts that signed in from ip, the latest first.
+func (a *Auth) QueryUsersByIP(ctx context.Context, ip string) ([]UserGeo, error) {
+	b := a.createBuilder().Select("<prefix>user_geo")
+	b.Where("ip = {ip}").
+		Param("ip", ip)
+
+	items, err := sqle.NewQuery[UserGeo](a.db).Query(ctx, b, func(i, j UserGeo) bool {
+		return i.UpdatedAt.After(j.UpdatedAt)
+	})
+
+	if err != nil {
+		a.logger.Error("auth: QueryUsersByIP",
+			slog.String("tag", "db"),
+			slog.String("ip", ip),
+			slog.Any("err", err))
+		return nil, ErrBadDatabase
+	}
+
+	return items, nil
+}
+
+// ListUserGeos returns the ips that user signed in from, the latest first.
+func (a *Auth) ListUserGeos(ctx context.Context, uid int64) ([]UserGeo, error) {
+	var items []UserGeo
+	rows, err := a.db.On(shardid.Parse(uid)).
+		QueryBuilder(ctx, a.createBuilder().
+			Select("<prefix>user_geo").
+			Where("user_id = {user_id}").
+			Param("user_id", uid).
+			SQL(" ORDER BY updated_at DESC"))
+
+	if err != nil {
+		a.logger.Error("auth: ListUserGeos",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid),
+			slog.Any("err", err))
+		return nil, ErrBadDatabase
+	}
+
+	err = rows.Bind(&items)
+	if err != nil {
+		a.logger.Error("auth: ListUserGeos:Bind",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid),
+			slog.Any("err", err))
+		return nil, ErrBadDatabase
+	}
+
+	return items, nil
+}
+
+// trackGeo counts a login from the ip, the ip is resolved by geo resolver on its first login
+func (a *Auth) trackGeo(ctx context.Context, uid shardid.ID, userIP string) error {
+	if userIP == "" {
+		return nil
+	}
+
+	ip := truncate(userIP, geoIPLen)
+	now := time.Now()
+	db := a.db.On(uid)
+
+	r, err := db.ExecBuilder(ctx, a.createBuilder().
+		Update("<prefix>user_geo").
+		SetExpr("`login_times` = `login_times` + 1").
+		Set("updated_at", now).
+		Where("user_id = {user_id} AND ip = {ip}").
+		Param("user_id", uid.Int64).
+		Param("ip", ip))
+
+	if err == nil {
+		if n, _ := r.RowsAffected(); n == 0 {
+			g := a.resolveGeo(ctx, ip)
+			_, err = db.ExecBuilder(ctx, a.createBuilder().
+				Insert("<prefix>user_geo").
+				Set("user_id", uid.Int64).
+				Set("ip", ip).
+				Set("country", truncate(g.Country, geoCountryLen)).
+				Set("region", truncate(g.Region, geoRegionLen)).
+				Set("login_times", 1).
+				Set("created_at", now).
+				Set("updated_at", now).
+				End())
+		}
+	}
+
+	if err != nil {
+		a.logger.Error("auth: trackGeo",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid.Int64),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	return nil
+}
+
+// resolveGeo resolves ip by geo resolver. An empty Geo is returned if it is not resolved.
+func (a *Auth) resolveGeo(ctx context.Context, ip string) Geo {
+	if a.geoResolver == nil {
+		return Geo{}
+	}
+
+	g, err := a.geoResolver.Resolve(ctx, ip)
+	if err != nil {
+		a.logger.Warn("auth: resolveGeo",
+			slog.String("tag", "geo"),
+			slog.String("ip", ip),
+			slog.Any("err", err))
+		return Geo{}
+	}
+
+	return g
+}
+
+// truncate cuts s to fit in a column of n bytes
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
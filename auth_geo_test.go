@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserGeo(t *testing.T) {
+	au := createAuthTest("./tests_user_geo.db")
+	ctx := context.Background()
+
+	w := newMMDBWriter(4)
+	w.insert("1.1.1.0/24", geoRecord("AU", "NSW"))
+	w.insert("8.8.0.0/16", geoRecord("US", "CA"))
+	r, err := newMMDBResolver(w.bytes())
+	require.NoError(t, err)
+	au.geoResolver = r
+
+	u1, err := au.CreateUser(ctx, UserStatusActivated, "geo1@mail.com", "", "abc123", "", "")
+	require.NoError(t, err)
+	u2, err := au.CreateUser(ctx, UserStatusActivated, "geo2@mail.com", "", "abc123", "", "")
+	require.NoError(t, err)
+
+	login := func(email, ip string) {
+		_, err := au.Login(ctx, email, "abc123", LoginOption{UserIP: ip, UserAgent: "laptop"})
+		require.NoError(t, err)
+	}
+
+	login("geo1@mail.com", "1.1.1.1")
+	login("geo1@mail.com", "1.1.1.1")
+	login("geo1@mail.com", "8.8.8.8")
+	login("geo2@mail.com", "8.8.8.8")
+	login("geo2@mail.com", "10.0.0.1")
+
+	geos, err := au.ListUserGeos(ctx, u1.ID.Int64)
+	require.NoError(t, err)
+	require.Len(t, geos, 2)
+	require.Equal(t, "8.8.8.8", geos[0].IP)
+	require.Equal(t, "US", geos[0].Country)
+	require.Equal(t, "CA", geos[0].Region)
+	require.Equal(t, "1.1.1.1", geos[1].IP)
+	require.Equal(t, 2, geos[1].LoginTimes)
+
+	t.Run("country", func(t *testing.T) {
+		ids, next, err := au.QueryUsersByCountry(ctx, "US", "", 0)
+		require.NoError(t, err)
+		require.Zero(t, next)
+		require.Equal(t, []int64{u1.ID.Int64, u2.ID.Int64}, ids)
+
+		ids, _, err = au.QueryUsersByCountry(ctx, "AU", "NSW", 0)
+		require.NoError(t, err)
+		require.Equal(t, []int64{u1.ID.Int64}, ids)
+
+		ids, _, err = au.QueryUsersByCountry(ctx, "US", "", u1.ID.Int64)
+		require.NoError(t, err)
+		require.Equal(t, []int64{u2.ID.Int64}, ids)
+	})
+
+	t.Run("ip", func(t *testing.T) {
+		items, err := au.QueryUsersByIP(ctx, "8.8.8.8")
+		require.NoError(t, err)
+		require.Len(t, items, 2)
+
+		items, err = au.QueryUsersByIP(ctx, "10.0.0.1")
+		require.NoError(t, err)
+		require.Len(t, items, 1)
+		require.Equal(t, u2.ID, items[0].UserID)
+		require.Empty(t, items[0].Country)
+	})
+}
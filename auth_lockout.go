@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/yaitoo/sqle"
+	"github.com/yaitoo/sqle/shardid"
+)
+
+// maxLoginFails fails is a tinyint in user_last
+const maxLoginFails = 127
+
+// UnlockUser unlocks the account and clears its failed logins.
+func (a *Auth) UnlockUser(ctx context.Context, uid int64) error {
+	id := shardid.Parse(uid)
+
+	_, err := a.getUserByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.db.On(id).
+		ExecBuilder(ctx, a.createBuilder().
+			Update("<prefix>user_last").
+			Set("fails", 0).
+			Set("is_locked", sqle.Bool(false)).
+			Set("locked_at", nil).
+			Where("user_id = {user_id}").
+			Param("user_id", uid))
+
+	if err != nil {
+		a.logger.Error("auth: UnlockUser",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	return nil
+}
+
+// checkLockout returns ErrAccountLocked if the account is locked now, or RetryAfterError if the source is blocked for credential stuffing,
+// and logs the rejected login
+func (a *Auth) checkLockout(ctx context.Context, uid shardid.ID, method LoginMethod, userIP, userAgent string) error {
+	if _, err := a.checkSource(ctx, userIP); err != nil {
+		a.createLoginLog(ctx, uid, method, false, userIP, userAgent, noRisk)
+		return err
+	}
+
+	if a.lockout.Threshold < 0 {
+		return nil
+	}
+
+	fails, lockedAt, err := a.getLoginFails(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	if lockedAt.Valid && time.Now().Before(lockedAt.Time().Add(a.lockout.lockDuration(fails/a.lockout.Threshold))) {
+		a.createLoginLog(ctx, uid, method, false, userIP, userAgent, noRisk)
+		return ErrAccountLocked
+	}
+
+	return nil
+}
+
+// failLogin logs and counts a failed login, and locks the account every Threshold fails
+func (a *Auth) failLogin(ctx context.Context, uid shardid.ID, method LoginMethod, userIP, userAgent string) error {
+	a.createLoginLog(ctx, uid, method, false, userIP, userAgent, noRisk)
+	a.failSource(ctx, userIP)
+
+	if a.lockout.Threshold < 0 {
+		return nil
+	}
+
+	fails, locked, err := a.incrLoginFails(ctx, uid, userIP, userAgent, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if locked {
+		a.emit(ctx, Event{
+			Type:     EventAccountLocked,
+			UserID:   uid.Int64,
+			Method:   method,
+			IP:       userIP,
+			UA:       userAgent,
+			Metadata: map[string]any{"fails": fails},
+		})
+	}
+
+	return nil
+}
+
+// resetLoginFails clears failed logins after a successful login, and records it as the last login
+func (a *Auth) resetLoginFails(ctx context.Context, uid shardid.ID, userIP, userAgent string) error {
+	return a.saveUserLast(ctx, uid, 0, sqle.Time{}, userIP, userAgent, time.Now())
+}
+
+// incrLoginFails counts a failed login and locks the account every Threshold fails in a transaction, so concurrent fails are neither lost nor skip the threshold.
+// It returns the stored fails, and whether the account is locked by this fail.
+func (a *Auth) incrLoginFails(ctx context.Context, uid shardid.ID, userIP, userAgent string, now time.Time) (int, bool, error) {
+	var (
+		fails  int
+		locked bool
+		err    error
+	)
+
+	// the row of a new user may be inserted by a concurrent fail, so it is counted again by update
+	for i := 0; i < 2; i++ {
+		err = a.db.On(uid).Transaction(ctx, &sql.TxOptions{}, func(ctx context.Context, tx *sqle.Tx) error {
+			r, err := tx.ExecBuilder(ctx, a.createBuilder().
+				Update("<prefix>user_last").
+				SetExpr("`fails` = CASE WHEN `fails` < "+strconv.Itoa(maxLoginFails)+" THEN `fails` + 1 ELSE `fails` END").
+				Set("last_at", now).
+				Set("last_ip", userIP).
+				Set("last_device_name", deviceName(userAgent)).
+				Where("user_id = {user_id}").
+				Param("user_id", uid.Int64))
+			if err != nil {
+				return err
+			}
+
+			if n, _ := r.RowsAffected(); n == 0 {
+				_, err = tx.ExecBuilder(ctx, a.createBuilder().
+					Insert("<prefix>user_last").
+					Set("user_id", uid.Int64).
+					Set("fails", 1).
+					Set("is_locked", sqle.Bool(false)).
+					Set("last_at", now).
+					Set("last_ip", userIP).
+					Set("last_device_name", deviceName(userAgent)).
+					End())
+				if err != nil {
+					return err
+				}
+			}
+
+			err = tx.QueryRowBuilder(ctx, a.createBuilder().
+				Select("<prefix>user_last", "fails").
+				Where("user_id = {user_id}").
+				Param("user_id", uid.Int64)).
+				Scan(&fails)
+			if err != nil {
+				return err
+			}
+
+			locked = fails%a.lockout.Threshold == 0 || fails == maxLoginFails
+			if !locked {
+				return nil
+			}
+
+			_, err = tx.ExecBuilder(ctx, a.createBuilder().
+				Update("<prefix>user_last").
+				Set("is_locked", sqle.Bool(true)).
+				Set("locked_at", now).
+				Where("user_id = {user_id}").
+				Param("user_id", uid.Int64))
+
+			return err
+		})
+
+		if err == nil {
+			return fails, locked, nil
+		}
+	}
+
+	a.logger.Error("auth: incrLoginFails",
+		slog.String("tag", "db"),
+		slog.Int64("user_id", uid.Int64),
+		slog.Any("err", err))
+	return 0, false, ErrBadDatabase
+}
+
+func (a *Auth) getLoginFails(ctx context.Context, uid shardid.ID) (int, sqle.Time, error) {
+	var fails int
+	var lockedAt sqle.Time
+	err := a.db.On(uid).
+		QueryRowBuilder(ctx, a.createBuilder().
+			Select("<prefix>user_last", "fails", "locked_at").
+			Where("user_id = {user_id}").
+			Param("user_id", uid.Int64)).
+		Scan(&fails, &lockedAt)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, lockedAt, nil
+		}
+		a.logger.Error("auth: getLoginFails",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid.Int64),
+			slog.Any("err", err))
+		return 0, lockedAt, ErrBadDatabase
+	}
+
+	return fails, lockedAt, nil
+}
+
+func (a *Auth) saveUserLast(ctx context.Context, uid shardid.ID, fails int, lockedAt sqle.Time, userIP, userAgent string, now time.Time) error {
+	db := a.db.On(uid)
+
+	r, err := db.ExecBuilder(ctx, a.createBuilder().
+		Update("<prefix>user_last").
+		Set("fails", fails).
+		Set("is_locked", sqle.Bool(lockedAt.Valid)).
+		Set("locked_at", lockedAt).
+		Set("last_at", now).
+		Set("last_ip", userIP).
+		Set("last_device_name", deviceName(userAgent)).
+		Where("user_id = {user_id}").
+		Param("user_id", uid.Int64))
+
+	if err == nil {
+		if n, _ := r.RowsAffected(); n == 0 {
+			_, err = db.ExecBuilder(ctx, a.createBuilder().
+				Insert("<prefix>user_last").
+				Set("user_id", uid.Int64).
+				Set("fails", fails).
+				Set("is_locked", sqle.Bool(lockedAt.Valid)).
+				Set("locked_at", lockedAt).
+				Set("last_at", now).
+				Set("last_ip", userIP).
+				Set("last_device_name", deviceName(userAgent)).
+				End())
+		}
+	}
+
+	if err != nil {
+		a.logger.Error("auth: saveUserLast",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid.Int64),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	l := Lockout{Threshold: 3, Duration: time.Minute, MaxDuration: 10 * time.Minute}
+	require.Equal(t, time.Duration(0), l.lockDuration(0))
+	require.Equal(t, time.Minute, l.lockDuration(1))
+	require.Equal(t, 3*time.Minute, l.lockDuration(3))
+	require.Equal(t, 10*time.Minute, l.lockDuration(20))
+
+	l.Exponential = true
+	require.Equal(t, time.Minute, l.lockDuration(1))
+	require.Equal(t, 4*time.Minute, l.lockDuration(3))
+	require.Equal(t, 10*time.Minute, l.lockDuration(5))
+}
+
+func TestLockout(t *testing.T) {
+	au := createAuthTest("./tests_lockout.db")
+	au.lockout = Lockout{Threshold: 3, Duration: 100 * time.Millisecond, Exponential: true}
+	ctx := context.Background()
+
+	u, err := au.CreateUser(ctx, UserStatusActivated, "lockout@mail.com", "", "abc123", "", "")
+	require.NoError(t, err)
+
+	t.Run("locked", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			_, err := au.Login(ctx, "lockout@mail.com", "wrong", LoginOption{UserIP: "127.0.0.1", UserAgent: "test"})
+			require.ErrorIs(t, err, ErrPasswdNotMatched)
+		}
+
+		// correct password is rejected while locked
+		_, err := au.Login(ctx, "lockout@mail.com", "abc123", LoginOption{})
+		require.ErrorIs(t, err, ErrAccountLocked)
+
+		_, err = au.LoginWithOTP(ctx, "lockout@mail.com", "000000", LoginOption{})
+		require.ErrorIs(t, err, ErrAccountLocked)
+	})
+
+	t.Run("longer_lock", func(t *testing.T) {
+		time.Sleep(110 * time.Millisecond)
+
+		// the 2nd lock is longer
+		for i := 0; i < 3; i++ {
+			_, err := au.LoginWithOTP(ctx, "lockout@mail.com", "000000", LoginOption{})
+			require.ErrorIs(t, err, ErrOtpNotMatched)
+		}
+
+		time.Sleep(110 * time.Millisecond)
+		_, err := au.Login(ctx, "lockout@mail.com", "abc123", LoginOption{})
+		require.ErrorIs(t, err, ErrAccountLocked)
+	})
+
+	t.Run("unlock", func(t *testing.T) {
+		require.NoError(t, au.UnlockUser(ctx, u.ID.Int64))
+
+		_, err := au.Login(ctx, "lockout@mail.com", "abc123", LoginOption{})
+		require.NoError(t, err)
+
+		// successful login clears fails
+		fails, lockedAt, err := au.getLoginFails(ctx, u.ID)
+		require.NoError(t, err)
+		require.Zero(t, fails)
+		require.False(t, lockedAt.Valid)
+
+		require.ErrorIs(t, au.UnlockUser(ctx, u.ID.Int64+1), ErrUserNotFound)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		// sqlite's shared cache fails concurrent transactions instead of waiting, the statements of goroutines still interleave on one connection
+		au.db.SetMaxOpenConns(1)
+		au.lockout = Lockout{Threshold: 5, Duration: time.Minute}
+		defer func() {
+			au.db.SetMaxOpenConns(0)
+			au.lockout = Lockout{Threshold: 3, Duration: 100 * time.Millisecond, Exponential: true}
+		}()
+
+		v, err := au.CreateUser(ctx, UserStatusActivated, "concurrent@mail.com", "", "abc123", "", "")
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := au.Login(ctx, "concurrent@mail.com", "wrong", LoginOption{UserIP: "127.0.0.2", UserAgent: "test"})
+				require.ErrorIs(t, err, ErrPasswdNotMatched)
+			}()
+		}
+		wg.Wait()
+
+		fails, lockedAt, err := au.getLoginFails(ctx, v.ID)
+		require.NoError(t, err)
+		require.Equal(t, 5, fails)
+		require.True(t, lockedAt.Valid)
+
+		_, err = au.Login(ctx, "concurrent@mail.com", "abc123", LoginOption{})
+		require.ErrorIs(t, err, ErrAccountLocked)
+	})
+}
@@ -5,50 +5,106 @@ import (
 	"errors"
 )
 
-// Login sign in with email and password.
+// Login sign in with email and password. It returns ErrMFARequired with Session.MFA if user has any second-factor device.
 func (a *Auth) Login(ctx context.Context, email, passwd string, option LoginOption) (Session, error) {
 	u, err := a.GetUserByEmail(ctx, email)
 
 	if err == nil {
+		if err = a.checkLockout(ctx, u.ID, LoginMethodPasswd, option.UserIP, option.UserAgent); err != nil {
+			return noSession, err
+		}
+
 		if verifyHash(a.hash(), u.Passwd, passwd, u.Salt) {
-			return a.createSession(ctx, u.ID, u.FirstName, u.LastName, option.UserIP, option.UserAgent)
+			if err = a.checkEmailVerified(u); err != nil {
+				a.createLoginLog(ctx, u.ID, LoginMethodPasswd, false, option.UserIP, option.UserAgent, noRisk)
+				return noSession, err
+			}
+			return a.createSessionOrChallenge(ctx, u, LoginMethodPasswd, false, option)
+		}
+
+		if err = a.failLogin(ctx, u.ID, LoginMethodPasswd, option.UserIP, option.UserAgent); err != nil {
+			return noSession, err
 		}
 
 		return noSession, ErrPasswdNotMatched
 	}
 
+	if !option.CreateIfNotExists && errors.Is(err, ErrEmailNotFound) {
+		a.createLoginLog(ctx, unknownUser, LoginMethodPasswd, false, option.UserIP, option.UserAgent, noRisk)
+		// unknown accounts are counted as failed logins of the source, and blocked sources get the same error as known accounts
+		if _, blocked := a.checkSource(ctx, option.UserIP); blocked != nil {
+			return noSession, blocked
+		}
+		a.failSource(ctx, option.UserIP)
+		return noSession, err
+	}
+
 	if option.CreateIfNotExists && errors.Is(err, ErrEmailNotFound) {
 		u, err = a.CreateUser(ctx, UserStatusWaiting, email, "", passwd, option.FirstName, option.LastName)
 		if err != nil {
 			return noSession, err
 		}
 
-		return a.createSession(ctx, u.ID, u.FirstName, u.LastName, option.UserIP, option.UserAgent)
+		// the new user signs in after the email is verified
+		if err = a.checkEmailVerified(u); err != nil {
+			a.createLoginLog(ctx, u.ID, LoginMethodPasswd, false, option.UserIP, option.UserAgent, noRisk)
+			return noSession, err
+		}
+
+		return a.createSessionOrChallenge(ctx, u, LoginMethodPasswd, false, option)
 	}
 
 	return noSession, err
 
 }
 
-// LoginMobile sign in with mobile and password.
+// LoginMobile sign in with mobile and password. It returns ErrMFARequired with Session.MFA if user has any second-factor device.
 func (a *Auth) LoginMobile(ctx context.Context, mobile, passwd string, option LoginOption) (Session, error) {
 	u, err := a.GetUserByMobile(ctx, mobile)
 
 	if err == nil {
+		if err = a.checkLockout(ctx, u.ID, LoginMethodPasswd, option.UserIP, option.UserAgent); err != nil {
+			return noSession, err
+		}
+
 		if verifyHash(a.hash(), u.Passwd, passwd, u.Salt) {
-			return a.createSession(ctx, u.ID, u.FirstName, u.LastName, option.UserIP, option.UserAgent)
+			if err = a.checkMobileVerified(u); err != nil {
+				a.createLoginLog(ctx, u.ID, LoginMethodPasswd, false, option.UserIP, option.UserAgent, noRisk)
+				return noSession, err
+			}
+			return a.createSessionOrChallenge(ctx, u, LoginMethodPasswd, false, option)
+		}
+
+		if err = a.failLogin(ctx, u.ID, LoginMethodPasswd, option.UserIP, option.UserAgent); err != nil {
+			return noSession, err
 		}
 
 		return noSession, ErrPasswdNotMatched
 	}
 
+	if !option.CreateIfNotExists && errors.Is(err, ErrMobileNotFound) {
+		a.createLoginLog(ctx, unknownUser, LoginMethodPasswd, false, option.UserIP, option.UserAgent, noRisk)
+		// unknown accounts are counted as failed logins of the source, and blocked sources get the same error as known accounts
+		if _, blocked := a.checkSource(ctx, option.UserIP); blocked != nil {
+			return noSession, blocked
+		}
+		a.failSource(ctx, option.UserIP)
+		return noSession, err
+	}
+
 	if option.CreateIfNotExists && errors.Is(err, ErrMobileNotFound) {
 		u, err = a.CreateUser(ctx, UserStatusWaiting, "", mobile, passwd, option.FirstName, option.LastName)
 		if err != nil {
 			return noSession, err
 		}
 
-		return a.createSession(ctx, u.ID, u.FirstName, u.LastName, option.UserIP, option.UserAgent)
+		// the new user signs in after the mobile is verified
+		if err = a.checkMobileVerified(u); err != nil {
+			a.createLoginLog(ctx, u.ID, LoginMethodPasswd, false, option.UserIP, option.UserAgent, noRisk)
+			return noSession, err
+		}
+
+		return a.createSessionOrChallenge(ctx, u, LoginMethodPasswd, false, option)
 	}
 
 	return noSession, err
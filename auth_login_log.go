@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/yaitoo/sqle"
+	"github.com/yaitoo/sqle/shardid"
+)
+
+const (
+	loginLogPageSize = 20
+	riskReasonsLen   = 100
+)
+
+// unknownUser the user that login attempts of unknown accounts are logged with, they are on the first database
+var unknownUser = shardid.ID{}
+
+// QueryLoginLogs returns login attempts of user in [from, to) from newest to oldest, a page at most 20 items. Attempts of unknown accounts are returned with uid 0.
+// cursor is 0 for the first page, and the returned next cursor is 0 if there are no more pages.
+func (a *Auth) QueryLoginLogs(ctx context.Context, uid int64, from, to time.Time, cursor int64) ([]LoginLog, int64, error) {
+	var items []LoginLog
+	rows, err := a.db.On(shardid.Parse(uid)).
+		QueryBuilder(ctx, a.createBuilder().
+			Select("<prefix>login_log").
+			Where("user_id = {user_id}").
+			And("created_at >= {from}").
+			And("created_at < {to}").
+			If(cursor > 0).And("id < {cursor}").
+			End().
+			SQL(" ORDER BY id DESC LIMIT {limit}").
+			Param("user_id", uid).
+			Param("from", from).
+			Param("to", to).
+			Param("cursor", cursor).
+			Param("limit", loginLogPageSize+1))
+
+	if err != nil {
+		a.logger.Error("auth: QueryLoginLogs",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid),
+			slog.Any("err", err))
+		return nil, 0, ErrBadDatabase
+	}
+
+	err = rows.Bind(&items)
+	if err != nil {
+		a.logger.Error("auth: QueryLoginLogs:Bind",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid),
+			slog.Any("err", err))
+		return nil, 0, ErrBadDatabase
+	}
+
+	var next int64
+	if len(items) > loginLogPageSize {
+		items = items[:loginLogPageSize]
+		next = items[loginLogPageSize-1].ID.Int64
+	}
+
+	return items, next, nil
+}
+
+// createLoginLog writes a login attempt on user's shard, and emits it as a login event. It doesn't fail the login, errors are logged only.
+func (a *Auth) createLoginLog(ctx context.Context, uid shardid.ID, method LoginMethod, ok bool, userIP, userAgent string, risk Risk) {
+	_, err := a.db.On(uid).
+		ExecBuilder(ctx, a.createBuilder().
+			Insert("<prefix>login_log").
+			Set("id", a.genLoginLog.Next().Int64).
+			Set("user_id", uid.Int64).
+			Set("method", method).
+			Set("is_ok", sqle.Bool(ok)).
+			Set("ip", userIP).
+			Set("ua", deviceUA(userAgent)).
+			Set("risk_score", risk.Score).
+			Set("risk_decision", risk.Decision).
+			Set("risk_reasons", truncate(strings.Join(risk.Reasons, ","), riskReasonsLen)).
+			Set("created_at", time.Now()).
+			End())
+
+	if err != nil {
+		a.logger.Error("auth: createLoginLog",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid.Int64),
+			slog.Any("err", err))
+	}
+
+	e := Event{
+		Type:   EventLoginSucceeded,
+		UserID: uid.Int64,
+		Method: method,
+		IP:     userIP,
+		UA:     userAgent,
+	}
+	if !ok {
+		e.Type = EventLoginFailed
+	}
+	if risk.Decision != "" {
+		e.Metadata = map[string]any{"risk": risk}
+	}
+
+	a.emit(ctx, e)
+}
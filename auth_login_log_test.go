@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginLog(t *testing.T) {
+	au := createAuthTest("./tests_login_log.db")
+	au.lockout = Lockout{Threshold: -1}
+	ctx := context.Background()
+
+	u, err := au.CreateUser(ctx, UserStatusActivated, "log@mail.com", "", "abc123", "", "")
+	require.NoError(t, err)
+
+	begin := time.Now().Add(-time.Second)
+
+	t.Run("every_attempt", func(t *testing.T) {
+		_, err := au.Login(ctx, "log@mail.com", "wrong", LoginOption{UserIP: "1.1.1.1", UserAgent: "laptop"})
+		require.ErrorIs(t, err, ErrPasswdNotMatched)
+
+		_, err = au.Login(ctx, "log@mail.com", "abc123", LoginOption{UserIP: "1.1.1.1", UserAgent: "laptop"})
+		require.NoError(t, err)
+
+		_, err = au.LoginWithOTP(ctx, "log@mail.com", "000000", LoginOption{})
+		require.ErrorIs(t, err, ErrOtpNotMatched)
+
+		code, err := au.CreateLoginCode(ctx, "log@mail.com", LoginOption{UserIP: "2.2.2.2"})
+		require.NoError(t, err)
+		_, err = au.LoginWithCode(ctx, "log@mail.com", code, LoginOption{UserIP: "2.2.2.2"})
+		require.NoError(t, err)
+
+		items, next, err := au.QueryLoginLogs(ctx, u.ID.Int64, begin, time.Now().Add(time.Second), 0)
+		require.NoError(t, err)
+		require.Zero(t, next)
+		require.Len(t, items, 4)
+
+		// newest first
+		require.Equal(t, LoginMethodPasswordless, items[0].Method)
+		require.True(t, bool(items[0].IsOK))
+		require.Equal(t, "2.2.2.2", items[0].IP)
+
+		require.Equal(t, LoginMethodTOTP, items[1].Method)
+		require.False(t, bool(items[1].IsOK))
+
+		require.Equal(t, LoginMethodPasswd, items[2].Method)
+		require.True(t, bool(items[2].IsOK))
+		require.Equal(t, "laptop", items[2].UA)
+
+		require.Equal(t, LoginMethodPasswd, items[3].Method)
+		require.False(t, bool(items[3].IsOK))
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		for i := 0; i < loginLogPageSize; i++ {
+			_, err := au.Login(ctx, "log@mail.com", "wrong", LoginOption{})
+			require.ErrorIs(t, err, ErrPasswdNotMatched)
+		}
+
+		items, next, err := au.QueryLoginLogs(ctx, u.ID.Int64, begin, time.Now().Add(time.Second), 0)
+		require.NoError(t, err)
+		require.Len(t, items, loginLogPageSize)
+		require.NotZero(t, next)
+
+		items, next, err = au.QueryLoginLogs(ctx, u.ID.Int64, begin, time.Now().Add(time.Second), next)
+		require.NoError(t, err)
+		require.Len(t, items, 4)
+		require.Zero(t, next)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		_, err := au.Login(ctx, "unknown@mail.com", "abc123", LoginOption{UserIP: "3.3.3.3"})
+		require.ErrorIs(t, err, ErrEmailNotFound)
+
+		// unknown accounts are logged with uid 0
+		items, _, err := au.QueryLoginLogs(ctx, 0, begin, time.Now().Add(time.Second), 0)
+		require.NoError(t, err)
+		require.Len(t, items, 1)
+		require.False(t, bool(items[0].IsOK))
+		require.Equal(t, "3.3.3.3", items[0].IP)
+
+		au.requireVerified = true
+		defer func() {
+			au.requireVerified = false
+		}()
+
+		_, err = au.Login(ctx, "log@mail.com", "abc123", LoginOption{UserIP: "4.4.4.4"})
+		require.ErrorIs(t, err, ErrEmailNotVerified)
+
+		items, _, err = au.QueryLoginLogs(ctx, u.ID.Int64, begin, time.Now().Add(time.Second), 0)
+		require.NoError(t, err)
+		require.False(t, bool(items[0].IsOK))
+		require.Equal(t, "4.4.4.4", items[0].IP)
+	})
+
+	t.Run("out_of_range", func(t *testing.T) {
+		items, _, err := au.QueryLoginLogs(ctx, u.ID.Int64, begin.Add(-time.Hour), begin, 0)
+		require.NoError(t, err)
+		require.Empty(t, items)
+	})
+}
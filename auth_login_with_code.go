@@ -5,8 +5,13 @@ import (
 	"errors"
 )
 
-// CreateLoginCode create a code for loging in by email
+// CreateLoginCode create a code for loging in by email. The code is sent by email if notifier is set.
 func (a *Auth) CreateLoginCode(ctx context.Context, email string, option LoginOption) (string, error) {
+	err := a.throttleSend(ctx, email, option.UserIP)
+	if err != nil {
+		return "", err
+	}
+
 	id, err := a.getUserIDByEmail(ctx, email)
 
 	if option.CreateIfNotExists && errors.Is(err, ErrEmailNotFound) {
@@ -16,28 +21,61 @@ func (a *Auth) CreateLoginCode(ctx context.Context, email string, option LoginOp
 		}
 
 		id = u.ID
+	} else if err != nil {
+		return "", err
+	}
+
+	code, err := a.createLoginCode(ctx, id, codeLogin, option.UserIP)
+	if err != nil {
+		return "", err
+	}
+
+	err = a.notify(ctx, ChannelEmail, MessageLoginCode, option.Locale, email, MessageData{Code: code, ExpiresIn: a.loginCodeTTL})
+	if err != nil {
+		return "", err
 	}
 
-	return a.createLoginCode(ctx, id, option.UserIP)
+	return code, nil
 }
 
-// LoginWithCode sign in with email and code.
-func (a *Auth) LoginWithCode(ctx context.Context, email, code string) (Session, error) {
+// LoginWithCode sign in with email and code. It returns ErrMFARequired with Session.MFA if user has any second-factor device.
+func (a *Auth) LoginWithCode(ctx context.Context, email, code string, option LoginOption) (Session, error) {
+	if option.UserAgent == "" {
+		option.UserAgent = "CODE"
+	}
+
 	u, err := a.GetUserByEmail(ctx, email)
 	if err != nil {
+		if errors.Is(err, ErrEmailNotFound) {
+			a.createLoginLog(ctx, unknownUser, LoginMethodPasswordless, false, option.UserIP, option.UserAgent, noRisk)
+		}
 		return noSession, err
 	}
 
-	userIP, err := a.getLoginCodeUserIP(ctx, u.ID, code)
+	if err = a.checkLockout(ctx, u.ID, LoginMethodPasswordless, option.UserIP, option.UserAgent); err != nil {
+		return noSession, err
+	}
+
+	_, err = a.consumeLoginCode(ctx, u.ID, codeLogin, code)
 	if err != nil {
+		if errors.Is(err, ErrCodeNotMatched) || errors.Is(err, ErrCodeAttemptsExceeded) {
+			if e := a.failLogin(ctx, u.ID, LoginMethodPasswordless, option.UserIP, option.UserAgent); e != nil {
+				return noSession, e
+			}
+		}
 		return noSession, err
 	}
 
-	return a.createSession(ctx, u.ID, u.FirstName, u.LastName, userIP, "CODE")
+	return a.createSessionOrChallenge(ctx, u, LoginMethodPasswordless, false, option)
 }
 
-// CreateLoginMobileCode create a code for loging in by mobile
+// CreateLoginMobileCode create a code for loging in by mobile. The code is sent by SMS if notifier is set.
 func (a *Auth) CreateLoginMobileCode(ctx context.Context, mobile string, option LoginOption) (string, error) {
+	err := a.throttleSend(ctx, mobile, option.UserIP)
+	if err != nil {
+		return "", err
+	}
+
 	id, err := a.getUserIDByMobile(ctx, mobile)
 
 	if option.CreateIfNotExists && errors.Is(err, ErrMobileNotFound) {
@@ -47,22 +85,50 @@ func (a *Auth) CreateLoginMobileCode(ctx context.Context, mobile string, option
 		}
 
 		id = u.ID
+	} else if err != nil {
+		return "", err
+	}
+
+	code, err := a.createLoginCode(ctx, id, codeLogin, option.UserIP)
+	if err != nil {
+		return "", err
+	}
+
+	err = a.notify(ctx, ChannelSMS, MessageLoginCode, option.Locale, mobile, MessageData{Code: code, ExpiresIn: a.loginCodeTTL})
+	if err != nil {
+		return "", err
 	}
 
-	return a.createLoginCode(ctx, id, option.UserIP)
+	return code, nil
 }
 
-// LoginMobileWithCode sign in with mobile and code.
-func (a *Auth) LoginMobileWithCode(ctx context.Context, mobile, code string) (Session, error) {
+// LoginMobileWithCode sign in with mobile and code. It returns ErrMFARequired with Session.MFA if user has any second-factor device.
+func (a *Auth) LoginMobileWithCode(ctx context.Context, mobile, code string, option LoginOption) (Session, error) {
+	if option.UserAgent == "" {
+		option.UserAgent = "CODE"
+	}
+
 	u, err := a.GetUserByMobile(ctx, mobile)
 	if err != nil {
+		if errors.Is(err, ErrMobileNotFound) {
+			a.createLoginLog(ctx, unknownUser, LoginMethodPasswordless, false, option.UserIP, option.UserAgent, noRisk)
+		}
 		return noSession, err
 	}
 
-	userIP, err := a.getLoginCodeUserIP(ctx, u.ID, code)
+	if err = a.checkLockout(ctx, u.ID, LoginMethodPasswordless, option.UserIP, option.UserAgent); err != nil {
+		return noSession, err
+	}
+
+	_, err = a.consumeLoginCode(ctx, u.ID, codeLogin, code)
 	if err != nil {
+		if errors.Is(err, ErrCodeNotMatched) || errors.Is(err, ErrCodeAttemptsExceeded) {
+			if e := a.failLogin(ctx, u.ID, LoginMethodPasswordless, option.UserIP, option.UserAgent); e != nil {
+				return noSession, e
+			}
+		}
 		return noSession, err
 	}
 
-	return a.createSession(ctx, u.ID, u.FirstName, u.LastName, userIP, "CODE")
+	return a.createSessionOrChallenge(ctx, u, LoginMethodPasswordless, false, option)
 }
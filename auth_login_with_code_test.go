@@ -3,7 +3,9 @@ package auth
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/pquerna/otp/totp"
 	"github.com/stretchr/testify/require"
 	"github.com/yaitoo/sqle/shardid"
 )
@@ -50,6 +52,107 @@ func TestLoginWithCode(t *testing.T) {
 			},
 			checkSession: true,
 		},
+		{
+			name:      "used_code_should_not_work",
+			email:     "used@sign_in_with_code.com",
+			wantedErr: ErrCodeNotMatched,
+			setup: func(r *require.Assertions) string {
+				code, err := authTest.CreateLoginCode(context.Background(), "used@sign_in_with_code.com", LoginOption{CreateIfNotExists: true})
+				r.NoError(err)
+
+				_, err = authTest.LoginWithCode(context.Background(), "used@sign_in_with_code.com", code, LoginOption{})
+				r.NoError(err)
+
+				return code
+			},
+		},
+		{
+			name:      "expired_code_should_not_work",
+			email:     "expired@sign_in_with_code.com",
+			wantedErr: ErrCodeExpired,
+			setup: func(r *require.Assertions) string {
+				code, err := authTest.CreateLoginCode(context.Background(), "expired@sign_in_with_code.com", LoginOption{CreateIfNotExists: true})
+				r.NoError(err)
+
+				id, err := authTest.getUserIDByEmail(context.Background(), "expired@sign_in_with_code.com")
+				r.NoError(err)
+
+				_, err = authTest.db.On(id).
+					ExecBuilder(context.Background(), authTest.createBuilder().
+						Update("<prefix>login_code").
+						Set("expires_on", time.Now().Add(-time.Second)).
+						Where("user_id = {user_id}").
+						Param("user_id", id.Int64))
+				r.NoError(err)
+
+				return code
+			},
+		},
+		{
+			name:      "superseded_code_should_not_work",
+			email:     "superseded@sign_in_with_code.com",
+			wantedErr: ErrCodeNotMatched,
+			setup: func(r *require.Assertions) string {
+				code, err := authTest.CreateLoginCode(context.Background(), "superseded@sign_in_with_code.com", LoginOption{CreateIfNotExists: true})
+				r.NoError(err)
+
+				for {
+					newCode, err := authTest.CreateLoginCode(context.Background(), "superseded@sign_in_with_code.com", LoginOption{})
+					r.NoError(err)
+					if newCode != code {
+						break
+					}
+				}
+
+				return code
+			},
+		},
+		{
+			name:      "code_should_be_invalidated_after_attempts",
+			email:     "attempts@sign_in_with_code.com",
+			wantedErr: ErrCodeNotMatched,
+			setup: func(r *require.Assertions) string {
+				code, err := authTest.CreateLoginCode(context.Background(), "attempts@sign_in_with_code.com", LoginOption{CreateIfNotExists: true})
+				r.NoError(err)
+
+				for i := 1; i < authTest.loginCodeAttempts; i++ {
+					_, err = authTest.LoginWithCode(context.Background(), "attempts@sign_in_with_code.com", "", LoginOption{})
+					r.ErrorIs(err, ErrCodeNotMatched)
+				}
+
+				_, err = authTest.LoginWithCode(context.Background(), "attempts@sign_in_with_code.com", "", LoginOption{})
+				r.ErrorIs(err, ErrCodeAttemptsExceeded)
+
+				// wrong guesses lock the account too
+				u, err := authTest.GetUserByEmail(context.Background(), "attempts@sign_in_with_code.com")
+				r.NoError(err)
+				r.NoError(authTest.UnlockUser(context.Background(), u.ID.Int64))
+
+				return code
+			},
+		},
+		{
+			name:      "mfa_device_should_challenge",
+			email:     "mfa@sign_in_with_code.com",
+			wantedErr: ErrMFARequired,
+			setup: func(r *require.Assertions) string {
+				ctx := context.Background()
+				code, err := authTest.CreateLoginCode(ctx, "mfa@sign_in_with_code.com", LoginOption{CreateIfNotExists: true})
+				r.NoError(err)
+
+				u, err := authTest.GetUserByEmail(ctx, "mfa@sign_in_with_code.com")
+				r.NoError(err)
+
+				setup, err := authTest.BeginMFADevice(ctx, u.ID.Int64, MFATOTP, "phone", "")
+				r.NoError(err)
+				otp, err := totp.GenerateCode(setup.Secret, time.Now())
+				r.NoError(err)
+				_, err = authTest.FinishMFADevice(ctx, u.ID.Int64, setup.Token, otp)
+				r.NoError(err)
+
+				return code
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -58,7 +161,7 @@ func TestLoginWithCode(t *testing.T) {
 
 			code := test.setup(r)
 
-			s, err := authTest.LoginWithCode(context.TODO(), test.email, code)
+			s, err := authTest.LoginWithCode(context.TODO(), test.email, code, LoginOption{})
 			if test.wantedErr == nil {
 				require.NoError(t, err)
 			} else {
@@ -123,6 +226,36 @@ func TestLoginMobileWithCode(t *testing.T) {
 			},
 			checkSession: true,
 		},
+		{
+			name:      "change_mobile_code_should_not_work",
+			mobile:    "1+555666777",
+			wantedErr: ErrCodeNotMatched,
+			setup: func(r *require.Assertions) string {
+				ctx := context.Background()
+				u, err := authTest.CreateUser(ctx, UserStatusActivated, "", "1+555666777", "abc123", "", "")
+				r.NoError(err)
+
+				code, err := authTest.ChangeMobile(ctx, u.ID.Int64, "1+555666888", LoginOption{})
+				r.NoError(err)
+
+				return code
+			},
+		},
+		{
+			name:   "other_codes_should_not_supersede_code",
+			mobile: "1+666777888",
+			setup: func(r *require.Assertions) string {
+				ctx := context.Background()
+				code, err := authTest.CreateLoginMobileCode(ctx, "1+666777888", LoginOption{CreateIfNotExists: true})
+				r.NoError(err)
+
+				_, err = authTest.SendMobileVerification(ctx, "1+666777888", LoginOption{})
+				r.NoError(err)
+
+				return code
+			},
+			checkSession: true,
+		},
 	}
 
 	for _, test := range tests {
@@ -131,7 +264,7 @@ func TestLoginMobileWithCode(t *testing.T) {
 
 			code := test.setup(r)
 
-			s, err := authTest.LoginMobileWithCode(context.TODO(), test.mobile, code)
+			s, err := authTest.LoginMobileWithCode(context.TODO(), test.mobile, code, LoginOption{})
 			if test.wantedErr == nil {
 				require.NoError(t, err)
 			} else {
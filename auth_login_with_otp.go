@@ -2,48 +2,92 @@ package auth
 
 import (
 	"context"
-
-	"github.com/pquerna/otp/totp"
+	"errors"
 )
 
-// LoginWithOTP sign in with email and otp.
-func (a *Auth) LoginWithOTP(ctx context.Context, email, otp string) (Session, error) {
+// LoginWithOTP sign in with email and otp. It returns ErrMFARequired with Session.MFA if user has any second-factor device.
+// The login is assessed with the ip/ua of option.
+func (a *Auth) LoginWithOTP(ctx context.Context, email, otp string, option LoginOption) (Session, error) {
+	if option.UserAgent == "" {
+		option.UserAgent = "OTP"
+	}
 
 	u, err := a.GetUserByEmail(ctx, email)
 
 	if err != nil {
+		a.createLoginLog(ctx, unknownUser, LoginMethodTOTP, false, option.UserIP, option.UserAgent, noRisk)
 		return noSession, ErrEmailNotFound
 	}
 
+	if err = a.checkLockout(ctx, u.ID, LoginMethodTOTP, option.UserIP, option.UserAgent); err != nil {
+		return noSession, err
+	}
+
 	pd, err := a.getProfileData(ctx, a.db.On(u.ID), u.ID.Int64)
 	if err != nil {
 		return noSession, err
 	}
 
-	if !totp.Validate(otp, pd.TKey) {
-		return noSession, ErrOtpNotMatched
+	if err = a.verifyOTP(ctx, u, pd.TKey, otp, option); err != nil {
+		return noSession, err
+	}
+
+	if err = a.checkEmailVerified(u); err != nil {
+		a.createLoginLog(ctx, u.ID, LoginMethodTOTP, false, option.UserIP, option.UserAgent, noRisk)
+		return noSession, err
 	}
 
-	return a.createSession(ctx, u.ID, u.FirstName, u.LastName, "", "OTP")
+	return a.createSessionOrChallenge(ctx, u, LoginMethodTOTP, false, option)
 
 }
 
-// LoginMobileWithOTP sign in with mobile and otp.
-func (a *Auth) LoginMobileWithOTP(ctx context.Context, mobile, otp string) (Session, error) {
+// LoginMobileWithOTP sign in with mobile and otp. It returns ErrMFARequired with Session.MFA if user has any second-factor device.
+// The login is assessed with the ip/ua of option.
+func (a *Auth) LoginMobileWithOTP(ctx context.Context, mobile, otp string, option LoginOption) (Session, error) {
+	if option.UserAgent == "" {
+		option.UserAgent = "OTP"
+	}
+
 	u, err := a.GetUserByMobile(ctx, mobile)
 
 	if err != nil {
+		a.createLoginLog(ctx, unknownUser, LoginMethodTOTP, false, option.UserIP, option.UserAgent, noRisk)
 		return noSession, ErrMobileNotFound
 	}
 
+	if err = a.checkLockout(ctx, u.ID, LoginMethodTOTP, option.UserIP, option.UserAgent); err != nil {
+		return noSession, err
+	}
+
 	pd, err := a.getProfileData(ctx, a.db.On(u.ID), u.ID.Int64)
 	if err != nil {
 		return noSession, err
 	}
 
-	if !totp.Validate(otp, pd.TKey) {
-		return noSession, ErrOtpNotMatched
+	if err = a.verifyOTP(ctx, u, pd.TKey, otp, option); err != nil {
+		return noSession, err
+	}
+
+	if err = a.checkMobileVerified(u); err != nil {
+		a.createLoginLog(ctx, u.ID, LoginMethodTOTP, false, option.UserIP, option.UserAgent, noRisk)
+		return noSession, err
+	}
+
+	return a.createSessionOrChallenge(ctx, u, LoginMethodTOTP, false, option)
+}
+
+// verifyOTP checks otp against the TOTP key of user, a code whose time step was already accepted is rejected as well.
+func (a *Auth) verifyOTP(ctx context.Context, u User, key, otp string, option LoginOption) error {
+	err := ErrOtpNotMatched
+	if step := validateTOTP(otp, key); step > 0 {
+		err = a.useTOTPStep(ctx, u.ID, "user_profile", "", step)
+	}
+
+	if errors.Is(err, ErrOtpNotMatched) {
+		if err := a.failLogin(ctx, u.ID, LoginMethodTOTP, option.UserIP, option.UserAgent); err != nil {
+			return err
+		}
 	}
 
-	return a.createSession(ctx, u.ID, u.FirstName, u.LastName, "", "OTP")
+	return err
 }
@@ -57,6 +57,25 @@ func TestLoginWithOTP(t *testing.T) {
 			},
 			checkSession: true,
 		},
+		{
+			name:      "otp_replayed_should_not_work",
+			email:     "otp_replayed@sign_in_with_otp.com",
+			wantedErr: ErrOtpNotMatched,
+			setup: func(r *require.Assertions) string {
+				u, err := authTest.CreateUser(context.Background(), UserStatusWaiting, "otp_replayed@sign_in_with_otp.com", "", "abc123", "", "")
+				r.NoError(err)
+
+				pd, err := authTest.getProfileData(context.Background(), authTest.db.On(u.ID), u.ID.Int64)
+				r.NoError(err)
+
+				code, err := totp.GenerateCode(pd.TKey, time.Now())
+				r.NoError(err)
+
+				_, err = authTest.LoginWithOTP(context.TODO(), "otp_replayed@sign_in_with_otp.com", code, LoginOption{})
+				r.NoError(err)
+				return code
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -65,7 +84,7 @@ func TestLoginWithOTP(t *testing.T) {
 
 			code := test.setup(r)
 
-			s, err := authTest.LoginWithOTP(context.TODO(), test.email, code)
+			s, err := authTest.LoginWithOTP(context.TODO(), test.email, code, LoginOption{})
 			if test.wantedErr == nil {
 				require.NoError(t, err)
 			} else {
@@ -144,7 +163,7 @@ func TestLoginMobileWithOTP(t *testing.T) {
 
 			code := test.setup(r)
 
-			s, err := authTest.LoginMobileWithOTP(context.TODO(), test.mobile, code)
+			s, err := authTest.LoginMobileWithOTP(context.TODO(), test.mobile, code, LoginOption{})
 			if test.wantedErr == nil {
 				require.NoError(t, err)
 			} else {
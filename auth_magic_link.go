@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+
+	"github.com/yaitoo/sqle/shardid"
+)
+
+const (
+	tokenMagicLink = "magic:login"
+
+	magicLinkNonceLen = 32
+	magicLinkParam    = "token"
+
+	// browserNonceMinLen the nonce of a browser must be long enough that it can't be guessed
+	browserNonceMinLen = 16
+)
+
+// CreateMagicLink create a signed, expiring and single-use link for loging in by email. The token is added to redirectURL as `token` parameter,
+// and redirectURL must be on one of magic link origins. The link is sent by email if notifier is set.
+// If option.SameBrowser is true, the link is bound to option.BrowserNonce, and it can only be used with the same nonce.
+func (a *Auth) CreateMagicLink(ctx context.Context, email, redirectURL string, option LoginOption) (string, error) {
+	link, err := a.parseLink(redirectURL)
+	if err != nil {
+		return "", err
+	}
+
+	if option.SameBrowser && len(option.BrowserNonce) < browserNonceMinLen {
+		return "", ErrBadRequest
+	}
+
+	err = a.throttleSend(ctx, email, option.UserIP)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := a.getUserIDByEmail(ctx, email)
+
+	if option.CreateIfNotExists && errors.Is(err, ErrEmailNotFound) {
+		u, err := a.CreateUser(ctx, UserStatusWaiting, email, "", randStr(12, dicAlphaNumber), option.FirstName, option.LastName)
+		if err != nil {
+			return "", err
+		}
+
+		id = u.ID
+	} else if err != nil {
+		return "", err
+	}
+
+	c := TokenClaims{
+		ID:    id.Int64,
+		Nonce: randStr(magicLinkNonceLen, dicAlphaNumber),
+	}
+
+	if option.SameBrowser {
+		c.Data = hashToken(option.BrowserNonce)
+	}
+
+	token, err := a.signToken(tokenMagicLink, c, a.magicLinkTTL)
+	if err != nil {
+		return "", err
+	}
+
+	// the nonce is saved as a login code, so the link is single-use and superseded by newer links/codes
+	err = a.saveLoginCode(ctx, id, codeMagicLink, c.Nonce, option.UserIP, a.magicLinkTTL)
+	if err != nil {
+		return "", err
+	}
+
+	q := link.Query()
+	q.Set(magicLinkParam, token)
+	link.RawQuery = q.Encode()
+
+	err = a.notify(ctx, ChannelEmail, MessageMagicLink, option.Locale, email, MessageData{Link: link.String(), ExpiresIn: a.magicLinkTTL})
+	if err != nil {
+		return "", err
+	}
+
+	return link.String(), nil
+}
+
+// parseLink parses the url that a link redirects to, it must be on one of magic link origins
+func (a *Auth) parseLink(rawURL string) (*url.URL, error) {
+	link, err := url.Parse(rawURL)
+	if err != nil || !slices.Contains(a.magicLinkOrigins, link.Scheme+"://"+link.Host) {
+		return nil, ErrBadRequest
+	}
+
+	return link, nil
+}
+
+// LoginWithMagicLink sign in with the token of a magic link. It returns ErrMFARequired with Session.MFA if user has any second-factor device.
+func (a *Auth) LoginWithMagicLink(ctx context.Context, token string, ci ClientInfo) (Session, error) {
+	c, err := a.parseToken(tokenMagicLink, token)
+	if err != nil {
+		return noSession, err
+	}
+
+	uid := shardid.Parse(c.ID)
+
+	if err = a.checkLockout(ctx, uid, LoginMethodPasswordless, ci.UserIP, ci.UserAgent); err != nil {
+		return noSession, err
+	}
+
+	if c.Data != "" && c.Data != hashToken(ci.BrowserNonce) {
+		a.createLoginLog(ctx, uid, LoginMethodPasswordless, false, ci.UserIP, ci.UserAgent, noRisk)
+		return noSession, ErrInvalidToken
+	}
+
+	_, err = a.consumeLoginCode(ctx, uid, codeMagicLink, c.Nonce)
+	if err != nil {
+		if errors.Is(err, ErrBadDatabase) {
+			return noSession, err
+		}
+		a.createLoginLog(ctx, uid, LoginMethodPasswordless, false, ci.UserIP, ci.UserAgent, noRisk)
+		return noSession, ErrInvalidToken
+	}
+
+	u, err := a.getUserByID(ctx, uid)
+	if err != nil {
+		return noSession, err
+	}
+
+	return a.createSessionOrChallenge(ctx, u, LoginMethodPasswordless, false, LoginOption{UserIP: ci.UserIP, UserAgent: ci.UserAgent, TrustedDevice: ci.TrustedDevice})
+}
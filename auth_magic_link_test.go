@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMagicLink(t *testing.T) {
+	au := createAuthTest("./tests_magic_link.db")
+	n := NewMemoryNotifier()
+	au.notifier = n
+	// the links are sent to one email more often than the default limit
+	au.sendThrottle.Identity = []Limit{}
+
+	ctx := context.Background()
+	origin := au.magicLinkOrigins[0]
+
+	t.Run("create", func(t *testing.T) {
+		_, err := au.CreateMagicLink(ctx, "magic@mail.com", "https://evil.com/login", LoginOption{CreateIfNotExists: true})
+		require.ErrorIs(t, err, ErrBadRequest)
+
+		_, err = au.CreateMagicLink(ctx, "magic@mail.com", origin+"/login", LoginOption{})
+		require.ErrorIs(t, err, ErrEmailNotFound)
+
+		link, err := au.CreateMagicLink(ctx, "magic@mail.com", origin+"/login?next=home", LoginOption{CreateIfNotExists: true})
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(link, origin+"/login?"))
+
+		msg, ok := n.Last("magic@mail.com")
+		require.True(t, ok)
+		require.Equal(t, MessageMagicLink, msg.Type)
+		require.Contains(t, msg.Text, link)
+	})
+
+	t.Run("login", func(t *testing.T) {
+		link, err := au.CreateMagicLink(ctx, "magic@mail.com", origin+"/login", LoginOption{})
+		require.NoError(t, err)
+		token := getMagicLinkToken(t, link)
+
+		// tokens of other purposes are rejected
+		_, err = au.LoginWithCode(ctx, "magic@mail.com", token, LoginOption{})
+		require.ErrorIs(t, err, ErrCodeNotMatched)
+
+		s, err := au.LoginWithMagicLink(ctx, token, ClientInfo{UserAgent: "phone"})
+		require.NoError(t, err)
+		require.NotEmpty(t, s.AccessToken)
+
+		// single-use
+		_, err = au.LoginWithMagicLink(ctx, token, ClientInfo{UserAgent: "phone"})
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("superseded", func(t *testing.T) {
+		link, err := au.CreateMagicLink(ctx, "magic@mail.com", origin+"/login", LoginOption{})
+		require.NoError(t, err)
+		old := getMagicLinkToken(t, link)
+
+		_, err = au.CreateMagicLink(ctx, "magic@mail.com", origin+"/login", LoginOption{})
+		require.NoError(t, err)
+
+		_, err = au.LoginWithMagicLink(ctx, old, ClientInfo{})
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("same_browser", func(t *testing.T) {
+		_, err := au.CreateMagicLink(ctx, "magic@mail.com", origin+"/login", LoginOption{SameBrowser: true, UserAgent: "laptop"})
+		require.ErrorIs(t, err, ErrBadRequest)
+
+		nonce := randStr(magicLinkNonceLen, dicAlphaNumber)
+		link, err := au.CreateMagicLink(ctx, "magic@mail.com", origin+"/login", LoginOption{SameBrowser: true, UserAgent: "laptop", BrowserNonce: nonce})
+		require.NoError(t, err)
+		token := getMagicLinkToken(t, link)
+
+		// the user agent can be copied by anyone
+		_, err = au.LoginWithMagicLink(ctx, token, ClientInfo{UserAgent: "laptop"})
+		require.ErrorIs(t, err, ErrInvalidToken)
+
+		_, err = au.LoginWithMagicLink(ctx, token, ClientInfo{UserAgent: "laptop", BrowserNonce: nonce})
+		require.NoError(t, err)
+	})
+
+	t.Run("locked", func(t *testing.T) {
+		link, err := au.CreateMagicLink(ctx, "magic@mail.com", origin+"/login", LoginOption{})
+		require.NoError(t, err)
+
+		u, err := au.GetUserByEmail(ctx, "magic@mail.com")
+		require.NoError(t, err)
+		for i := 0; i < au.lockout.Threshold; i++ {
+			_, err = au.LoginWithCode(ctx, "magic@mail.com", "wrong", LoginOption{})
+			require.Error(t, err)
+		}
+
+		_, err = au.LoginWithMagicLink(ctx, getMagicLinkToken(t, link), ClientInfo{})
+		require.ErrorIs(t, err, ErrAccountLocked)
+		require.NoError(t, au.UnlockUser(ctx, u.ID.Int64))
+	})
+}
+
+func getMagicLinkToken(t *testing.T, link string) string {
+	u, err := url.Parse(link)
+	require.NoError(t, err)
+	return u.Query().Get(magicLinkParam)
+}
@@ -0,0 +1,642 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/yaitoo/auth/masker"
+	"github.com/yaitoo/sqle/shardid"
+)
+
+const (
+	tokenMFASetup = "mfa:setup"
+	tokenMFALogin = "mfa:login"
+
+	// mfaFallback marks the challenge of a risky login that user has no second-factor device for, codes are sent to the profile email/mobile instead
+	mfaFallback       = "fallback"
+	mfaFallbackEmail  = "@email"
+	mfaFallbackMobile = "@mobile"
+
+	mfaTokenTTL    = 5 * time.Minute
+	mfaNameLen     = 45
+	mfaDeviceIDLen = 12
+
+	// totpPeriod the period of TOTP codes, it is the default of totp.Generate
+	totpPeriod = 30 * time.Second
+)
+
+// pendingMFADevice the device that is waiting for its first code, it is carried by the setup token
+type pendingMFADevice struct {
+	Kind   MFAKind `json:"kind"`
+	Name   string  `json:"name"`
+	Secret string  `json:"secret"`
+}
+
+// BeginMFADevice starts enrolling a second-factor device for the user.
+// For TOTP target is the account name shown in authenticator apps, and the setup contains the secret and otpauth url.
+// For email/SMS target is the email/mobile, and the setup contains the code that should be delivered to it.
+// Passkeys are enrolled by BeginPasskeyRegistration.
+func (a *Auth) BeginMFADevice(ctx context.Context, uid int64, kind MFAKind, name, target string) (MFASetup, error) {
+	var s MFASetup
+	id := shardid.Parse(uid)
+
+	_, err := a.getUserByID(ctx, id)
+	if err != nil {
+		return s, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = string(kind)
+	}
+
+	if n := []rune(name); len(n) > mfaNameLen {
+		name = string(n[:mfaNameLen])
+	}
+
+	pd := pendingMFADevice{
+		Kind: kind,
+		Name: name,
+	}
+
+	switch kind {
+	case MFATOTP:
+		if target == "" {
+			target = a.totpAccountName
+		}
+
+		key, err := totp.Generate(totp.GenerateOpts{
+			Issuer:      a.totpIssuer,
+			AccountName: target,
+		})
+		if err != nil {
+			a.logger.Error("auth: BeginMFADevice",
+				slog.String("tag", "crypto"),
+				slog.Int64("user_id", uid),
+				slog.Any("err", err))
+			return s, ErrUnknown
+		}
+
+		pd.Secret = key.Secret()
+		s.Secret = key.Secret()
+		s.URL = key.URL()
+	case MFAEmail, MFASMS:
+		if target == "" {
+			return s, ErrBadRequest
+		}
+
+		pd.Secret = target
+		err = a.throttleSend(ctx, target, "")
+		if err != nil {
+			return s, err
+		}
+
+		s.Code, err = a.createLoginCode(ctx, id, codeMFASetup, "")
+		if err != nil {
+			return s, err
+		}
+
+		err = a.notify(ctx, kind.channel(), MessageVerification, "", target, MessageData{Code: s.Code, ExpiresIn: a.loginCodeTTL})
+		if err != nil {
+			return s, err
+		}
+	default:
+		return s, ErrBadRequest
+	}
+
+	buf, _ := json.Marshal(pd)
+	data, err := a.encryptData(string(buf))
+	if err != nil {
+		return s, err
+	}
+
+	s.Token, err = a.signToken(tokenMFASetup, TokenClaims{ID: uid, Data: data}, mfaTokenTTL)
+	if err != nil {
+		return s, err
+	}
+
+	return s, nil
+}
+
+// FinishMFADevice confirms the enrollment with the first code generated by/sent to the device, and saves it.
+func (a *Auth) FinishMFADevice(ctx context.Context, uid int64, token, code string) (MFADevice, error) {
+	var d MFADevice
+
+	c, err := a.parseToken(tokenMFASetup, token)
+	if err != nil {
+		return d, err
+	}
+
+	if c.ID != uid {
+		return d, ErrInvalidToken
+	}
+
+	data, err := a.decryptData(c.Data)
+	if err != nil {
+		return d, ErrInvalidToken
+	}
+
+	var pd pendingMFADevice
+	if err = json.Unmarshal([]byte(data), &pd); err != nil {
+		return d, ErrInvalidToken
+	}
+
+	id := shardid.Parse(uid)
+
+	switch pd.Kind {
+	case MFATOTP:
+		if !totp.Validate(code, pd.Secret) {
+			return d, ErrOtpNotMatched
+		}
+	case MFAEmail, MFASMS:
+		_, err = a.consumeLoginCode(ctx, id, codeMFASetup, code)
+		if err != nil {
+			return d, err
+		}
+	default:
+		return d, ErrInvalidToken
+	}
+
+	secret, err := a.encryptUserData(ctx, a.db.On(id), id, pd.Secret)
+	if err != nil {
+		return d, err
+	}
+
+	d = MFADevice{
+		UserID:    id,
+		ID:        randStr(mfaDeviceIDLen, dicAlphaNumber),
+		Kind:      pd.Kind,
+		Name:      pd.Name,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}
+
+	err = a.createMFADevice(ctx, d)
+	if err != nil {
+		return d, err
+	}
+
+	return d, nil
+}
+
+// ListMFADevices returns all second-factor devices of the user, passkeys included.
+func (a *Auth) ListMFADevices(ctx context.Context, uid int64) ([]MFADevice, error) {
+	id := shardid.Parse(uid)
+
+	var items []MFADevice
+	rows, err := a.db.On(id).
+		QueryBuilder(ctx, a.createBuilder().
+			Select("<prefix>user_mfa").
+			Where("user_id = {user_id}").
+			Param("user_id", uid))
+
+	if err != nil {
+		a.logger.Error("auth: ListMFADevices",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid),
+			slog.Any("err", err))
+		return nil, ErrBadDatabase
+	}
+
+	err = rows.Bind(&items)
+	if err != nil {
+		a.logger.Error("auth: ListMFADevices:Bind",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid),
+			slog.Any("err", err))
+		return nil, ErrBadDatabase
+	}
+
+	keys, err := a.getPasskeys(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range keys {
+		items = append(items, MFADevice{
+			UserID:     k.UserID,
+			ID:         k.Hash,
+			Kind:       MFAPasskey,
+			Name:       k.Name,
+			CreatedAt:  k.CreatedAt,
+			LastUsedAt: k.LastUsedAt,
+		})
+	}
+
+	return items, nil
+}
+
+// RemoveMFADevice removes a second-factor device of the user, other devices keep working.
+func (a *Auth) RemoveMFADevice(ctx context.Context, uid int64, deviceID string) error {
+	id := shardid.Parse(uid)
+	db := a.db.On(id)
+
+	r, err := db.ExecBuilder(ctx, a.createBuilder().
+		Delete("<prefix>user_mfa").
+		Where("user_id = {user_id} AND id = {id}").
+		Param("user_id", uid).
+		Param("id", deviceID))
+
+	if err != nil {
+		a.logger.Error("auth: RemoveMFADevice",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid),
+			slog.String("device_id", deviceID),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	if n, _ := r.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	// passkeys are listed with their hash as device id
+	r, err = db.ExecBuilder(ctx, a.createBuilder().
+		Delete("<prefix>user_passkey").
+		Where("user_id = {user_id} AND hash = {hash}").
+		Param("user_id", uid).
+		Param("hash", deviceID))
+
+	if err != nil {
+		a.logger.Error("auth: RemoveMFADevice:Passkey",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid),
+			slog.String("device_id", deviceID),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	if n, _ := r.RowsAffected(); n == 0 {
+		return ErrMFADeviceNotFound
+	}
+
+	return nil
+}
+
+// SendMFACode creates a code for an email/SMS device of the MFA challenge, the code is sent to the device if notifier is set.
+func (a *Auth) SendMFACode(ctx context.Context, token, deviceID string) (string, error) {
+	c, err := a.parseToken(tokenMFALogin, token)
+	if err != nil {
+		return "", err
+	}
+
+	uid := shardid.Parse(c.ID)
+	d, target, err := a.getChallengeDevice(ctx, c, deviceID)
+	if err != nil {
+		return "", err
+	}
+
+	if d.Kind != MFAEmail && d.Kind != MFASMS {
+		return "", ErrBadRequest
+	}
+
+	err = a.throttleSend(ctx, target, "")
+	if err != nil {
+		return "", err
+	}
+
+	code, err := a.createLoginCode(ctx, uid, codeMFA, "")
+	if err != nil {
+		return "", err
+	}
+
+	err = a.notify(ctx, d.Kind.channel(), MessageLoginCode, "", target, MessageData{Code: code, ExpiresIn: a.loginCodeTTL})
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// VerifyMFA passes the MFA challenge with the code of a TOTP/email/SMS device, and signs the user in.
+// If rememberDevice is true, Session.TrustedDevice is issued to skip MFA on this device later.
+// Passkey methods are passed by signing in with BeginPasskeyLogin/FinishPasskeyLogin instead.
+func (a *Auth) VerifyMFA(ctx context.Context, token, deviceID, code string, rememberDevice bool, ci ClientInfo) (Session, error) {
+	c, err := a.parseToken(tokenMFALogin, token)
+	if err != nil {
+		return noSession, err
+	}
+
+	uid := shardid.Parse(c.ID)
+	d, _, err := a.getChallengeDevice(ctx, c, deviceID)
+	if err != nil {
+		return noSession, err
+	}
+
+	method := d.Kind.loginMethod()
+	if err = a.checkLockout(ctx, uid, method, ci.UserIP, ci.UserAgent); err != nil {
+		return noSession, err
+	}
+
+	err = a.verifyMFACode(ctx, d, code)
+	if err != nil {
+		if errors.Is(err, ErrOtpNotMatched) || errors.Is(err, ErrCodeNotMatched) || errors.Is(err, ErrCodeAttemptsExceeded) {
+			if e := a.failLogin(ctx, uid, method, ci.UserIP, ci.UserAgent); e != nil {
+				return noSession, e
+			}
+		}
+		return noSession, err
+	}
+
+	if c.Data != mfaFallback {
+		err = a.updateMFADeviceUsage(ctx, uid, d.ID, time.Now())
+		if err != nil {
+			return noSession, err
+		}
+	}
+
+	u, err := a.getUserByID(ctx, uid)
+	if err != nil {
+		return noSession, err
+	}
+
+	s, err := a.createLoginSession(ctx, u, method, ci.UserIP, ci.UserAgent)
+	if err != nil {
+		return noSession, err
+	}
+
+	if rememberDevice {
+		s.TrustedDevice, err = a.trustDevice(ctx, u.ID, ci.UserAgent)
+		if err != nil {
+			return noSession, err
+		}
+	}
+
+	return s, nil
+}
+
+// verifyMFACode checks the code of a TOTP/email/SMS device
+func (a *Auth) verifyMFACode(ctx context.Context, d MFADevice, code string) error {
+	switch d.Kind {
+	case MFATOTP:
+		secret, err := a.decryptUserData(ctx, a.db.On(d.UserID), d.UserID, d.Secret)
+		if err != nil {
+			return err
+		}
+
+		step := validateTOTP(code, secret)
+		if step == 0 {
+			return ErrOtpNotMatched
+		}
+
+		return a.useTOTPStep(ctx, d.UserID, "user_mfa", d.ID, step)
+	case MFAEmail, MFASMS:
+		_, err := a.consumeLoginCode(ctx, d.UserID, codeMFA, code)
+		if err != nil {
+			return err
+		}
+	default:
+		return ErrBadRequest
+	}
+
+	return nil
+}
+
+// validateTOTP returns the time step that code of secret is generated in, or 0 if it isn't valid. Like totp.Validate, the previous and next steps are accepted.
+func validateTOTP(code, secret string) int64 {
+	now := time.Now()
+	for _, d := range []time.Duration{-totpPeriod, 0, totpPeriod} {
+		t := now.Add(d)
+		ok, _ := totp.ValidateCustom(code, secret, t, totp.ValidateOpts{
+			Period:    uint(totpPeriod.Seconds()),
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if ok {
+			return t.Unix() / int64(totpPeriod.Seconds())
+		}
+	}
+
+	return 0
+}
+
+// useTOTPStep records step as the last used step of the TOTP secret in table, so a code can't be replayed in its valid period.
+// It returns ErrOtpNotMatched if the step isn't newer than the last used step.
+func (a *Auth) useTOTPStep(ctx context.Context, uid shardid.ID, table, id string, step int64) error {
+	r, err := a.db.On(uid).ExecBuilder(ctx, a.createBuilder().
+		Update("<prefix>"+table).
+		Set("totp_step", step).
+		Where("user_id = {user_id}").
+		If(id != "").And("id = {id}").
+		And("totp_step < {step}").
+		Param("user_id", uid.Int64).
+		Param("id", id).
+		Param("step", step))
+
+	if err != nil {
+		a.logger.Error("auth: useTOTPStep",
+			slog.String("tag", "db"),
+			slog.String("table", table),
+			slog.Int64("user_id", uid.Int64),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	if n, _ := r.RowsAffected(); n == 0 {
+		return ErrOtpNotMatched
+	}
+
+	return nil
+}
+
+// createSessionOrChallenge creates session for user who passed the first factor,
+// or returns a MFA challenge with ErrMFARequired if user has any second-factor device and the device is not trusted.
+// A strong first factor (eg. passkey) is only challenged if the login is risky.
+// A risky login is challenged even if the device is trusted, and it is challenged with a code sent to the profile email/mobile if user has no other second-factor device.
+func (a *Auth) createSessionOrChallenge(ctx context.Context, u User, method LoginMethod, strong bool, option LoginOption) (Session, error) {
+	if err := a.checkUserStatus(u); err != nil {
+		a.createLoginLog(ctx, u.ID, method, false, option.UserIP, option.UserAgent, noRisk)
+		return noSession, err
+	}
+
+	risk, err := a.assessRisk(ctx, u, method, option.UserIP, option.UserAgent)
+	if err != nil {
+		return noSession, err
+	}
+
+	if risk.Decision == RiskBlock {
+		return a.createAssessedSession(ctx, u, method, option.UserIP, option.UserAgent, risk)
+	}
+
+	devices, err := a.ListMFADevices(ctx, u.ID.Int64)
+	if err != nil {
+		return noSession, err
+	}
+
+	if strong {
+		// the passkey that user signed in with can't pass its own challenge
+		devices = slices.DeleteFunc(devices, func(d MFADevice) bool {
+			return d.Kind == MFAPasskey
+		})
+	}
+
+	if risk.Decision == RiskAllow &&
+		(strong || len(devices) == 0 || a.isTrustedDevice(ctx, u.ID, option.TrustedDevice, option.UserAgent)) {
+		return a.createAssessedSession(ctx, u, method, option.UserIP, option.UserAgent, risk)
+	}
+
+	c := TokenClaims{ID: u.ID.Int64}
+	ch := &MFAChallenge{}
+
+	if len(devices) == 0 {
+		c.Data = mfaFallback
+		if u.Email != "" {
+			ch.Methods = append(ch.Methods, MFAMethod{ID: mfaFallbackEmail, Kind: MFAEmail, Hint: masker.Email(u.Email)})
+		}
+
+		if u.Mobile != "" {
+			ch.Methods = append(ch.Methods, MFAMethod{ID: mfaFallbackMobile, Kind: MFASMS, Hint: masker.Mobile(u.Mobile)})
+		}
+
+		// nothing can be challenged
+		if len(ch.Methods) == 0 {
+			risk.Decision = RiskBlock
+			return a.createAssessedSession(ctx, u, method, option.UserIP, option.UserAgent, risk)
+		}
+	}
+
+	ch.Token, err = a.signToken(tokenMFALogin, c, mfaTokenTTL)
+	if err != nil {
+		return noSession, err
+	}
+
+	for _, d := range devices {
+		m := MFAMethod{
+			ID:   d.ID,
+			Kind: d.Kind,
+			Name: d.Name,
+		}
+
+		if d.Kind == MFAEmail || d.Kind == MFASMS {
+			target, err := a.decryptUserData(ctx, a.db.On(d.UserID), d.UserID, d.Secret)
+			if err != nil {
+				return noSession, err
+			}
+
+			if d.Kind == MFAEmail {
+				m.Hint = masker.Email(target)
+			} else {
+				m.Hint = masker.Mobile(target)
+			}
+		}
+
+		ch.Methods = append(ch.Methods, m)
+	}
+
+	return Session{UserID: u.ID.Int64, MFA: ch}, ErrMFARequired
+}
+
+// getChallengeDevice returns the device of the MFA challenge, and the email/mobile that codes of the device are sent to.
+// The profile email/mobile are the only devices of a fallback challenge.
+func (a *Auth) getChallengeDevice(ctx context.Context, c TokenClaims, deviceID string) (MFADevice, string, error) {
+	uid := shardid.Parse(c.ID)
+
+	if c.Data != mfaFallback {
+		d, err := a.getMFADevice(ctx, uid, deviceID)
+		if err != nil || (d.Kind != MFAEmail && d.Kind != MFASMS) {
+			return d, "", err
+		}
+
+		target, err := a.decryptUserData(ctx, a.db.On(d.UserID), d.UserID, d.Secret)
+		return d, target, err
+	}
+
+	d := MFADevice{UserID: uid, ID: deviceID}
+	u, err := a.getUserByID(ctx, uid)
+	if err != nil {
+		return d, "", err
+	}
+
+	var target string
+	switch deviceID {
+	case mfaFallbackEmail:
+		d.Kind = MFAEmail
+		target = u.Email
+	case mfaFallbackMobile:
+		d.Kind = MFASMS
+		target = u.Mobile
+	}
+
+	if target == "" {
+		return d, "", ErrMFADeviceNotFound
+	}
+
+	return d, target, nil
+}
+
+func (a *Auth) getMFADevice(ctx context.Context, uid shardid.ID, deviceID string) (MFADevice, error) {
+	var d MFADevice
+	err := a.db.On(uid).
+		QueryRowBuilder(ctx, a.createBuilder().
+			Select("<prefix>user_mfa").
+			Where("user_id = {user_id} AND id = {id}").
+			Param("user_id", uid.Int64).
+			Param("id", deviceID)).
+		Bind(&d)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return d, ErrMFADeviceNotFound
+		}
+		a.logger.Error("auth: getMFADevice",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid.Int64),
+			slog.String("device_id", deviceID),
+			slog.Any("err", err))
+		return d, ErrBadDatabase
+	}
+
+	return d, nil
+}
+
+func (a *Auth) createMFADevice(ctx context.Context, d MFADevice) error {
+	_, err := a.db.On(d.UserID).
+		ExecBuilder(ctx, a.createBuilder().
+			Insert("<prefix>user_mfa").
+			Set("user_id", d.UserID.Int64).
+			Set("id", d.ID).
+			Set("kind", d.Kind).
+			Set("name", d.Name).
+			Set("secret", d.Secret).
+			Set("created_at", d.CreatedAt).
+			End())
+
+	if err != nil {
+		a.logger.Error("auth: createMFADevice",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", d.UserID.Int64),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	return nil
+}
+
+func (a *Auth) updateMFADeviceUsage(ctx context.Context, uid shardid.ID, deviceID string, now time.Time) error {
+	_, err := a.db.On(uid).
+		ExecBuilder(ctx, a.createBuilder().
+			Update("<prefix>user_mfa").
+			Set("last_used_at", now).
+			Where("user_id = {user_id} AND id = {id}").
+			Param("user_id", uid.Int64).
+			Param("id", deviceID))
+
+	if err != nil {
+		a.logger.Error("auth: updateMFADeviceUsage",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid.Int64),
+			slog.String("device_id", deviceID),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	return nil
+}
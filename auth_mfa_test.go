@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/require"
+	"github.com/yaitoo/auth/masker"
+	"github.com/yaitoo/sqle/shardid"
+)
+
+func TestMFA(t *testing.T) {
+	au := createAuthTest("./tests_mfa.db")
+	ctx := context.Background()
+
+	u, err := au.CreateUser(ctx, UserStatusActivated, "mfa@mail.com", "", "abc123", "", "")
+	require.NoError(t, err)
+
+	ci := ClientInfo{UserIP: "127.0.0.1", UserAgent: "test"}
+
+	var (
+		totpSecret string
+		phone      MFADevice
+		backup     MFADevice
+	)
+
+	t.Run("no_device", func(t *testing.T) {
+		s, err := au.Login(ctx, "mfa@mail.com", "abc123", LoginOption{})
+		require.NoError(t, err)
+		require.Nil(t, s.MFA)
+		require.NotEmpty(t, s.AccessToken)
+	})
+
+	t.Run("add_totp", func(t *testing.T) {
+		setup, err := au.BeginMFADevice(ctx, u.ID.Int64, MFATOTP, "phone", "mfa@mail.com")
+		require.NoError(t, err)
+		require.NotEmpty(t, setup.Secret)
+		require.NotEmpty(t, setup.URL)
+
+		_, err = au.FinishMFADevice(ctx, u.ID.Int64+1, setup.Token, "000000")
+		require.ErrorIs(t, err, ErrInvalidToken)
+
+		code, err := totp.GenerateCode(setup.Secret, time.Now())
+		require.NoError(t, err)
+
+		phone, err = au.FinishMFADevice(ctx, u.ID.Int64, setup.Token, code)
+		require.NoError(t, err)
+		require.Equal(t, MFATOTP, phone.Kind)
+		require.Equal(t, "phone", phone.Name)
+		totpSecret = setup.Secret
+	})
+
+	t.Run("add_email", func(t *testing.T) {
+		setup, err := au.BeginMFADevice(ctx, u.ID.Int64, MFAEmail, "", "backup@mail.com")
+		require.NoError(t, err)
+		require.NotEmpty(t, setup.Code)
+
+		_, err = au.FinishMFADevice(ctx, u.ID.Int64, setup.Token, "xxxxxx")
+		require.ErrorIs(t, err, ErrCodeNotMatched)
+
+		backup, err = au.FinishMFADevice(ctx, u.ID.Int64, setup.Token, setup.Code)
+		require.NoError(t, err)
+		require.Equal(t, "email", backup.Name)
+
+		devices, err := au.ListMFADevices(ctx, u.ID.Int64)
+		require.NoError(t, err)
+		require.Len(t, devices, 2)
+
+		// setup token can't pass a challenge
+		_, err = au.VerifyMFA(ctx, setup.Token, backup.ID, setup.Code, false, ci)
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("challenge", func(t *testing.T) {
+		s, err := au.Login(ctx, "mfa@mail.com", "abc123", LoginOption{})
+		require.ErrorIs(t, err, ErrMFARequired)
+		require.Empty(t, s.AccessToken)
+		require.NotNil(t, s.MFA)
+		require.Len(t, s.MFA.Methods, 2)
+		for _, m := range s.MFA.Methods {
+			if m.ID == backup.ID {
+				require.Equal(t, masker.Email("backup@mail.com"), m.Hint)
+			} else {
+				require.Empty(t, m.Hint)
+			}
+		}
+
+		_, err = au.VerifyMFA(ctx, s.MFA.Token, phone.ID, "000000", false, ci)
+		require.ErrorIs(t, err, ErrOtpNotMatched)
+
+		code, err := au.SendMFACode(ctx, s.MFA.Token, backup.ID)
+		require.NoError(t, err)
+
+		_, err = au.SendMFACode(ctx, s.MFA.Token, phone.ID)
+		require.ErrorIs(t, err, ErrBadRequest)
+
+		session, err := au.VerifyMFA(ctx, s.MFA.Token, backup.ID, code, false, ci)
+		require.NoError(t, err)
+		require.Equal(t, u.ID.Int64, session.UserID)
+		require.NoError(t, au.checkRefreshToken(ctx, shardid.Parse(session.UserID), session.RefreshToken))
+
+		d, err := au.getMFADevice(ctx, u.ID, backup.ID)
+		require.NoError(t, err)
+		require.True(t, d.LastUsedAt.Valid)
+	})
+
+	t.Run("totp_replay", func(t *testing.T) {
+		code, err := totp.GenerateCode(totpSecret, time.Now())
+		require.NoError(t, err)
+
+		s, err := au.Login(ctx, "mfa@mail.com", "abc123", LoginOption{})
+		require.ErrorIs(t, err, ErrMFARequired)
+		_, err = au.VerifyMFA(ctx, s.MFA.Token, phone.ID, code, false, ci)
+		require.NoError(t, err)
+
+		// the code is still valid, but its time step was accepted
+		s, err = au.Login(ctx, "mfa@mail.com", "abc123", LoginOption{})
+		require.ErrorIs(t, err, ErrMFARequired)
+		_, err = au.VerifyMFA(ctx, s.MFA.Token, phone.ID, code, false, ci)
+		require.ErrorIs(t, err, ErrOtpNotMatched)
+
+		// an older step can't be used either
+		code, err = totp.GenerateCode(totpSecret, time.Now().Add(-totpPeriod))
+		require.NoError(t, err)
+		_, err = au.VerifyMFA(ctx, s.MFA.Token, phone.ID, code, false, ci)
+		require.ErrorIs(t, err, ErrOtpNotMatched)
+
+		code, err = totp.GenerateCode(totpSecret, time.Now().Add(totpPeriod))
+		require.NoError(t, err)
+		_, err = au.VerifyMFA(ctx, s.MFA.Token, phone.ID, code, false, ci)
+		require.NoError(t, err)
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		// removing one device keeps the others
+		require.NoError(t, au.RemoveMFADevice(ctx, u.ID.Int64, backup.ID))
+		require.ErrorIs(t, au.RemoveMFADevice(ctx, u.ID.Int64, backup.ID), ErrMFADeviceNotFound)
+
+		s, err := au.Login(ctx, "mfa@mail.com", "abc123", LoginOption{})
+		require.ErrorIs(t, err, ErrMFARequired)
+		require.Len(t, s.MFA.Methods, 1)
+
+		code, err := totp.GenerateCode(totpSecret, time.Now())
+		require.NoError(t, err)
+		_, err = au.VerifyMFA(ctx, s.MFA.Token, backup.ID, code, false, ci)
+		require.ErrorIs(t, err, ErrMFADeviceNotFound)
+
+		require.NoError(t, au.RemoveMFADevice(ctx, u.ID.Int64, phone.ID))
+
+		s, err = au.Login(ctx, "mfa@mail.com", "abc123", LoginOption{})
+		require.NoError(t, err)
+		require.Nil(t, s.MFA)
+	})
+}
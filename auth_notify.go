@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+)
+
+// notify renders and sends a message to user if notifier is set
+func (a *Auth) notify(ctx context.Context, ch Channel, typ MessageType, locale, to string, data MessageData) error {
+	if a.notifier == nil {
+		return nil
+	}
+
+	if data.AppName == "" {
+		data.AppName = a.totpIssuer
+	}
+	data.To = to
+
+	msg, err := a.templates.Render(ch, typ, locale, to, data)
+	if err != nil {
+		a.logger.Error("auth: notify:Render",
+			slog.String("tag", "notify"),
+			slog.String("channel", string(ch)),
+			slog.String("type", string(typ)),
+			slog.String("locale", locale),
+			slog.Any("err", err))
+		return ErrNotifyFailed
+	}
+
+	err = a.notifier.Send(ctx, msg)
+	if err != nil {
+		a.logger.Error("auth: notify:Send",
+			slog.String("tag", "notify"),
+			slog.String("channel", string(ch)),
+			slog.String("type", string(typ)),
+			slog.Any("err", err))
+		return ErrNotifyFailed
+	}
+
+	return nil
+}
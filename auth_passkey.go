@@ -0,0 +1,561 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/yaitoo/sqle/shardid"
+)
+
+const (
+	tokenPasskeyRegistration = "passkey:reg"
+	tokenPasskeyLogin        = "passkey:login"
+
+	passkeyNameLen = 45
+)
+
+// BeginPasskeyRegistration starts a registration ceremony for the user.
+// Options should be passed to navigator.credentials.create(), and Token should be sent back with the attestation.
+func (a *Auth) BeginPasskeyRegistration(ctx context.Context, uid int64) (PasskeyRegistration, error) {
+	var r PasskeyRegistration
+	id := shardid.Parse(uid)
+
+	u, err := a.getUserByID(ctx, id)
+	if err != nil {
+		return r, err
+	}
+
+	pd, err := a.getProfileData(ctx, a.db.On(id), uid)
+	if err != nil {
+		return r, err
+	}
+
+	keys, err := a.getPasskeys(ctx, id)
+	if err != nil {
+		return r, err
+	}
+
+	challenge, err := a.createPasskeyChallenge(ctx)
+	if err != nil {
+		return r, err
+	}
+
+	r.Token, err = a.signToken(tokenPasskeyRegistration, TokenClaims{ID: uid, Nonce: challenge}, a.passkeyTimeout)
+	if err != nil {
+		return r, err
+	}
+
+	name := pd.Email
+	if name == "" {
+		name = pd.Mobile
+	}
+
+	displayName := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	if displayName == "" {
+		displayName = name
+	}
+
+	r.PublicKey = PasskeyCreationOptions{
+		Challenge: challenge,
+		RP: PasskeyRP{
+			ID:   a.rpID,
+			Name: a.rpName,
+		},
+		User: PasskeyUser{
+			ID:          encodeBase64URL(userHandle(uid)),
+			Name:        name,
+			DisplayName: displayName,
+		},
+		PubKeyCredParams: []PasskeyParam{
+			{Type: "public-key", Alg: coseES256},
+			{Type: "public-key", Alg: coseEdDSA},
+			{Type: "public-key", Alg: coseRS256},
+		},
+		Timeout: a.passkeyTimeout.Milliseconds(),
+		AuthenticatorSelection: PasskeySelection{
+			ResidentKey:      "preferred",
+			UserVerification: "preferred",
+		},
+		Attestation: "none",
+	}
+
+	for _, k := range keys {
+		r.PublicKey.ExcludeCredentials = append(r.PublicKey.ExcludeCredentials, k.descriptor())
+	}
+
+	return r, nil
+}
+
+// FinishPasskeyRegistration verifies the attestation returned by navigator.credentials.create(), and saves the passkey with name.
+// The ceremony must be started and finished by the same user.
+func (a *Auth) FinishPasskeyRegistration(ctx context.Context, uid int64, token, name string, cred PasskeyAttestation) (Passkey, error) {
+	var pk Passkey
+
+	c, err := a.parseToken(tokenPasskeyRegistration, token)
+	if err != nil {
+		return pk, err
+	}
+
+	if c.ID != uid {
+		return pk, ErrInvalidToken
+	}
+
+	err = a.consumePasskeyChallenge(ctx, c.Nonce)
+	if err != nil {
+		return pk, err
+	}
+
+	if cred.Type != "public-key" {
+		return pk, ErrBadRequest
+	}
+
+	rawID, err := decodeBase64URL(cred.RawID)
+	if err != nil {
+		return pk, ErrBadRequest
+	}
+
+	cdj, err := decodeBase64URL(cred.Response.ClientDataJSON)
+	if err != nil {
+		return pk, ErrBadRequest
+	}
+
+	att, err := decodeBase64URL(cred.Response.AttestationObject)
+	if err != nil {
+		return pk, ErrBadRequest
+	}
+
+	id := shardid.Parse(uid)
+
+	ad, err := a.verifyAttestation(cdj, att, c.Nonce)
+	if err != nil {
+		a.logger.Warn("auth: FinishPasskeyRegistration",
+			slog.String("tag", "webauthn"),
+			slog.Int64("user_id", uid),
+			slog.Any("err", err))
+		return pk, ErrPasskeyNotMatched
+	}
+
+	if string(ad.CredentialID) != string(rawID) {
+		return pk, ErrPasskeyNotMatched
+	}
+
+	credID := encodeBase64URL(rawID)
+	h := generateHash(a.hash(), credID, "")
+
+	_, err = a.getPasskey(ctx, id, h)
+	if err == nil {
+		return pk, ErrPasskeyExists
+	}
+
+	if !errors.Is(err, ErrPasskeyNotFound) {
+		return pk, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Passkey"
+	}
+
+	if n := []rune(name); len(n) > passkeyNameLen {
+		name = string(n[:passkeyNameLen])
+	}
+
+	pk = Passkey{
+		UserID:     id,
+		Hash:       h,
+		CredID:     credID,
+		Name:       name,
+		PublicKey:  encodeBase64URL(ad.PublicKey),
+		SignCount:  int64(ad.SignCount),
+		AAGUID:     formatAAGUID(ad.AAGUID),
+		Transports: strings.Join(cred.Response.Transports, ","),
+		CreatedAt:  time.Now(),
+	}
+
+	err = a.createPasskey(ctx, pk)
+	if err != nil {
+		return pk, err
+	}
+
+	return pk, nil
+}
+
+// BeginPasskeyLogin starts an authentication ceremony. If email is empty, any discoverable passkey of the rp can be used.
+// Options should be passed to navigator.credentials.get(), and Token should be sent back with the assertion.
+func (a *Auth) BeginPasskeyLogin(ctx context.Context, email string) (PasskeyLogin, error) {
+	var l PasskeyLogin
+
+	var uid int64
+	var allows []PasskeyDescriptor
+
+	if email != "" {
+		u, err := a.GetUserByEmail(ctx, email)
+		if err != nil {
+			return l, err
+		}
+
+		keys, err := a.getPasskeys(ctx, u.ID)
+		if err != nil {
+			return l, err
+		}
+
+		if len(keys) == 0 {
+			return l, ErrPasskeyNotFound
+		}
+
+		for _, k := range keys {
+			allows = append(allows, k.descriptor())
+		}
+
+		uid = u.ID.Int64
+	}
+
+	challenge, err := a.createPasskeyChallenge(ctx)
+	if err != nil {
+		return l, err
+	}
+
+	token, err := a.signToken(tokenPasskeyLogin, TokenClaims{ID: uid, Nonce: challenge}, a.passkeyTimeout)
+	if err != nil {
+		return l, err
+	}
+
+	l.Token = token
+	l.PublicKey = PasskeyRequestOptions{
+		Challenge:        challenge,
+		RPID:             a.rpID,
+		Timeout:          a.passkeyTimeout.Milliseconds(),
+		AllowCredentials: allows,
+		UserVerification: "required",
+	}
+
+	return l, nil
+}
+
+// FinishPasskeyLogin verifies the assertion returned by navigator.credentials.get(), and signs the user in.
+// The passkey is a second factor itself, so the login is only challenged with ErrMFARequired if it is risky.
+func (a *Auth) FinishPasskeyLogin(ctx context.Context, token string, cred PasskeyAssertion, ci ClientInfo) (Session, error) {
+	c, err := a.parseToken(tokenPasskeyLogin, token)
+	if err != nil {
+		return noSession, err
+	}
+
+	err = a.consumePasskeyChallenge(ctx, c.Nonce)
+	if err != nil {
+		return noSession, err
+	}
+
+	if cred.Type != "public-key" {
+		return noSession, ErrBadRequest
+	}
+
+	rawID, err := decodeBase64URL(cred.RawID)
+	if err != nil {
+		return noSession, ErrBadRequest
+	}
+
+	cdj, err := decodeBase64URL(cred.Response.ClientDataJSON)
+	if err != nil {
+		return noSession, ErrBadRequest
+	}
+
+	rawAuthData, err := decodeBase64URL(cred.Response.AuthenticatorData)
+	if err != nil {
+		return noSession, ErrBadRequest
+	}
+
+	sig, err := decodeBase64URL(cred.Response.Signature)
+	if err != nil {
+		return noSession, ErrBadRequest
+	}
+
+	id := c.ID
+	if cred.Response.UserHandle != "" {
+		buf, err := decodeBase64URL(cred.Response.UserHandle)
+		if err != nil || len(buf) != 8 {
+			return noSession, ErrPasskeyNotMatched
+		}
+
+		v := int64(binary.BigEndian.Uint64(buf))
+		if id != 0 && id != v {
+			return noSession, ErrPasskeyNotMatched
+		}
+		id = v
+	}
+
+	if id == 0 {
+		return noSession, ErrPasskeyNotFound
+	}
+
+	uid := shardid.Parse(id)
+	pk, err := a.getPasskey(ctx, uid, generateHash(a.hash(), encodeBase64URL(rawID), ""))
+	if err != nil {
+		return noSession, err
+	}
+
+	if err = a.checkLockout(ctx, uid, LoginMethodPasswordless, ci.UserIP, ci.UserAgent); err != nil {
+		return noSession, err
+	}
+
+	ad, err := a.verifyAssertion(pk, cdj, rawAuthData, sig, c.Nonce)
+	if err != nil {
+		a.logger.Warn("auth: FinishPasskeyLogin",
+			slog.String("tag", "webauthn"),
+			slog.Int64("user_id", id),
+			slog.Any("err", err))
+		if err = a.failLogin(ctx, uid, LoginMethodPasswordless, ci.UserIP, ci.UserAgent); err != nil {
+			return noSession, err
+		}
+		return noSession, ErrPasskeyNotMatched
+	}
+
+	// authenticators that don't support counters always report 0
+	if ad.SignCount != 0 || pk.SignCount != 0 {
+		if int64(ad.SignCount) <= pk.SignCount {
+			a.logger.Warn("auth: FinishPasskeyLogin",
+				slog.String("tag", "webauthn"),
+				slog.Int64("user_id", id),
+				slog.String("cred_id", pk.CredID),
+				slog.Int64("stored_count", pk.SignCount),
+				slog.Int64("sign_count", int64(ad.SignCount)),
+				slog.Any("err", "sign counter doesn't increase, credential may be cloned"))
+			if err = a.failLogin(ctx, uid, LoginMethodPasswordless, ci.UserIP, ci.UserAgent); err != nil {
+				return noSession, err
+			}
+			return noSession, ErrPasskeyCloned
+		}
+	}
+
+	err = a.updatePasskeyUsage(ctx, uid, pk.Hash, int64(ad.SignCount), time.Now())
+	if err != nil {
+		return noSession, err
+	}
+
+	u, err := a.getUserByID(ctx, uid)
+	if err != nil {
+		return noSession, err
+	}
+
+	return a.createSessionOrChallenge(ctx, u, LoginMethodPasswordless, true, LoginOption{UserIP: ci.UserIP, UserAgent: ci.UserAgent, TrustedDevice: ci.TrustedDevice})
+}
+
+// verifyAttestation verifies clientDataJSON and attestation object of a registration ceremony
+func (a *Auth) verifyAttestation(cdj, att []byte, challenge string) (authData, error) {
+	var ad authData
+
+	err := parseClientData(cdj, webauthnCreate, challenge, a.rpOrigins)
+	if err != nil {
+		return ad, err
+	}
+
+	ao, err := parseAttestation(att)
+	if err != nil {
+		return ad, err
+	}
+
+	ad, err = parseAuthData(ao.AuthData, a.rpID, false)
+	if err != nil {
+		return ad, err
+	}
+
+	if ad.Flags&flagAttested == 0 {
+		return ad, errBadAttestation
+	}
+
+	_, _, err = parsePublicKey(ad.PublicKey)
+	if err != nil {
+		return ad, err
+	}
+
+	return ad, nil
+}
+
+// verifyAssertion verifies clientDataJSON, authenticator data and signature of an authentication ceremony
+func (a *Auth) verifyAssertion(pk Passkey, cdj, rawAuthData, sig []byte, challenge string) (authData, error) {
+	var ad authData
+
+	err := parseClientData(cdj, webauthnGet, challenge, a.rpOrigins)
+	if err != nil {
+		return ad, err
+	}
+
+	ad, err = parseAuthData(rawAuthData, a.rpID, true)
+	if err != nil {
+		return ad, err
+	}
+
+	pub, err := decodeBase64URL(pk.PublicKey)
+	if err != nil {
+		return ad, errBadPublicKey
+	}
+
+	err = verifySignature(pub, rawAuthData, cdj, sig)
+	if err != nil {
+		return ad, err
+	}
+
+	return ad, nil
+}
+
+func (a *Auth) getPasskeys(ctx context.Context, uid shardid.ID) ([]Passkey, error) {
+	var items []Passkey
+	rows, err := a.db.On(uid).
+		QueryBuilder(ctx, a.createBuilder().
+			Select("<prefix>user_passkey").
+			Where("user_id = {user_id}").
+			Param("user_id", uid.Int64))
+
+	if err != nil {
+		a.logger.Error("auth: getPasskeys",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid.Int64),
+			slog.Any("err", err))
+		return nil, ErrBadDatabase
+	}
+
+	err = rows.Bind(&items)
+	if err != nil {
+		a.logger.Error("auth: getPasskeys:Bind",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid.Int64),
+			slog.Any("err", err))
+		return nil, ErrBadDatabase
+	}
+
+	return items, nil
+}
+
+func (a *Auth) getPasskey(ctx context.Context, uid shardid.ID, hash string) (Passkey, error) {
+	var pk Passkey
+	err := a.db.On(uid).
+		QueryRowBuilder(ctx, a.createBuilder().
+			Select("<prefix>user_passkey").
+			Where("user_id = {user_id} AND hash = {hash}").
+			Param("user_id", uid.Int64).
+			Param("hash", hash)).
+		Bind(&pk)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return pk, ErrPasskeyNotFound
+		}
+		a.logger.Error("auth: getPasskey",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid.Int64),
+			slog.Any("err", err))
+		return pk, ErrBadDatabase
+	}
+
+	return pk, nil
+}
+
+func (a *Auth) createPasskey(ctx context.Context, pk Passkey) error {
+	_, err := a.db.On(pk.UserID).
+		ExecBuilder(ctx, a.createBuilder().
+			Insert("<prefix>user_passkey").
+			Set("user_id", pk.UserID.Int64).
+			Set("hash", pk.Hash).
+			Set("cred_id", pk.CredID).
+			Set("name", pk.Name).
+			Set("public_key", pk.PublicKey).
+			Set("sign_count", pk.SignCount).
+			Set("aaguid", pk.AAGUID).
+			Set("transports", pk.Transports).
+			Set("created_at", pk.CreatedAt).
+			End())
+
+	if err != nil {
+		a.logger.Error("auth: createPasskey",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", pk.UserID.Int64),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	return nil
+}
+
+func (a *Auth) updatePasskeyUsage(ctx context.Context, uid shardid.ID, hash string, signCount int64, now time.Time) error {
+	_, err := a.db.On(uid).
+		ExecBuilder(ctx, a.createBuilder().
+			Update("<prefix>user_passkey").
+			Set("sign_count", signCount).
+			Set("last_used_at", now).
+			Where("user_id = {user_id} AND hash = {hash}").
+			Param("user_id", uid.Int64).
+			Param("hash", hash))
+
+	if err != nil {
+		a.logger.Error("auth: updatePasskeyUsage",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid.Int64),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	return nil
+}
+
+// createPasskeyChallenge creates a random challenge for a ceremony, and saves it until the ceremony times out.
+// The discoverable login has no user yet, so challenges are saved in the main database.
+func (a *Auth) createPasskeyChallenge(ctx context.Context) (string, error) {
+	challenge := encodeBase64URL(randBytes(32))
+	now := time.Now()
+
+	// challenges of abandoned ceremonies are removed by next ceremonies
+	_, err := a.db.ExecBuilder(ctx, a.createBuilder().
+		Delete("<prefix>passkey_challenge").
+		Where("expires_at < {now}").
+		Param("now", now))
+
+	if err == nil {
+		_, err = a.db.ExecBuilder(ctx, a.createBuilder().
+			Insert("<prefix>passkey_challenge").
+			Set("hash", hashToken(challenge)).
+			Set("expires_at", now.Add(a.passkeyTimeout)).
+			End())
+	}
+
+	if err != nil {
+		a.logger.Error("auth: createPasskeyChallenge",
+			slog.String("tag", "db"),
+			slog.Any("err", err))
+		return "", ErrBadDatabase
+	}
+
+	return challenge, nil
+}
+
+// consumePasskeyChallenge deletes the challenge on its first use, so an assertion or attestation can't be replayed even if the authenticator has no sign counter
+func (a *Auth) consumePasskeyChallenge(ctx context.Context, challenge string) error {
+	r, err := a.db.ExecBuilder(ctx, a.createBuilder().
+		Delete("<prefix>passkey_challenge").
+		Where("hash = {hash} AND expires_at >= {now}").
+		Param("hash", hashToken(challenge)).
+		Param("now", time.Now()))
+
+	if err != nil {
+		a.logger.Error("auth: consumePasskeyChallenge",
+			slog.String("tag", "db"),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	if n, _ := r.RowsAffected(); n == 0 {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+// userHandle returns the WebAuthn user handle of user id
+func userHandle(uid int64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(uid))
+	return buf
+}
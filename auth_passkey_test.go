@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/yaitoo/sqle/shardid"
+)
+
+// softAuthenticator a software WebAuthn authenticator with a P-256 key
+type softAuthenticator struct {
+	rpID      string
+	origin    string
+	credID    []byte
+	key       *ecdsa.PrivateKey
+	signCount uint32
+	userID    []byte
+}
+
+func newSoftAuthenticator(rpID, origin string) *softAuthenticator {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	return &softAuthenticator{
+		rpID:   rpID,
+		origin: origin,
+		credID: randBytes(16),
+		key:    key,
+	}
+}
+
+func (sa *softAuthenticator) clientData(typ, challenge string) []byte {
+	buf, _ := json.Marshal(clientData{Type: typ, Challenge: challenge, Origin: sa.origin})
+	return buf
+}
+
+func (sa *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	h := sha256.Sum256([]byte(sa.rpID))
+	buf := append([]byte{}, h[:]...)
+	buf = append(buf, flags)
+	buf = binary.BigEndian.AppendUint32(buf, sa.signCount)
+	return append(buf, attested...)
+}
+
+func (sa *softAuthenticator) create(opts PasskeyCreationOptions) PasskeyAttestation {
+	pub, _ := cbor.Marshal(coseKey{
+		Kty: coseKeyEC2,
+		Alg: coseES256,
+		Crv: 1,
+		X:   sa.key.X.FillBytes(make([]byte, 32)),
+		Y:   sa.key.Y.FillBytes(make([]byte, 32)),
+	})
+
+	attested := make([]byte, 16) // aaguid
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(sa.credID)))
+	attested = append(attested, sa.credID...)
+	attested = append(attested, pub...)
+
+	ao, _ := cbor.Marshal(attestationObject{
+		Fmt:      "none",
+		AttStmt:  cbor.RawMessage{0xa0}, // empty map
+		AuthData: sa.authData(flagUserPresent|flagUserVerified|flagAttested, attested),
+	})
+
+	sa.userID, _ = decodeBase64URL(opts.User.ID)
+
+	var cred PasskeyAttestation
+	cred.ID = encodeBase64URL(sa.credID)
+	cred.RawID = cred.ID
+	cred.Type = "public-key"
+	cred.Response.ClientDataJSON = encodeBase64URL(sa.clientData(webauthnCreate, opts.Challenge))
+	cred.Response.AttestationObject = encodeBase64URL(ao)
+	cred.Response.Transports = []string{"internal"}
+
+	return cred
+}
+
+func (sa *softAuthenticator) get(opts PasskeyRequestOptions) PasskeyAssertion {
+	sa.signCount++
+
+	ad := sa.authData(flagUserPresent|flagUserVerified, nil)
+	cdj := sa.clientData(webauthnGet, opts.Challenge)
+	cdh := sha256.Sum256(cdj)
+	h := sha256.Sum256(append(append([]byte{}, ad...), cdh[:]...))
+	sig, _ := ecdsa.SignASN1(rand.Reader, sa.key, h[:])
+
+	var cred PasskeyAssertion
+	cred.ID = encodeBase64URL(sa.credID)
+	cred.RawID = cred.ID
+	cred.Type = "public-key"
+	cred.Response.ClientDataJSON = encodeBase64URL(cdj)
+	cred.Response.AuthenticatorData = encodeBase64URL(ad)
+	cred.Response.Signature = encodeBase64URL(sig)
+	cred.Response.UserHandle = encodeBase64URL(sa.userID)
+
+	return cred
+}
+
+func TestPasskey(t *testing.T) {
+	au := createAuthTest("./tests_passkey.db")
+	ctx := context.Background()
+
+	u, err := au.CreateUser(ctx, UserStatusActivated, "passkey@mail.com", "", "abc123", "first", "last")
+	require.NoError(t, err)
+
+	sa := newSoftAuthenticator(au.rpID, au.rpOrigins[0])
+	var pk Passkey
+
+	t.Run("register", func(t *testing.T) {
+		reg, err := au.BeginPasskeyRegistration(ctx, u.ID.Int64)
+		require.NoError(t, err)
+		require.Equal(t, "passkey@mail.com", reg.PublicKey.User.Name)
+		require.Equal(t, "first last", reg.PublicKey.User.DisplayName)
+
+		// token is bound to the user
+		_, err = au.FinishPasskeyRegistration(ctx, u.ID.Int64+1, reg.Token, "laptop", sa.create(reg.PublicKey))
+		require.ErrorIs(t, err, ErrInvalidToken)
+
+		pk, err = au.FinishPasskeyRegistration(ctx, u.ID.Int64, reg.Token, "laptop", sa.create(reg.PublicKey))
+		require.NoError(t, err)
+		require.Equal(t, "laptop", pk.Name)
+		require.Equal(t, "internal", pk.Transports)
+
+		// the challenge is consumed
+		_, err = au.FinishPasskeyRegistration(ctx, u.ID.Int64, reg.Token, "laptop", sa.create(reg.PublicKey))
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("challenge_should_match", func(t *testing.T) {
+		reg, err := au.BeginPasskeyRegistration(ctx, u.ID.Int64)
+		require.NoError(t, err)
+
+		bad := reg.PublicKey
+		bad.Challenge = encodeBase64URL(randBytes(32))
+		_, err = au.FinishPasskeyRegistration(ctx, u.ID.Int64, reg.Token, "phone", newSoftAuthenticator(au.rpID, au.rpOrigins[0]).create(bad))
+		require.ErrorIs(t, err, ErrPasskeyNotMatched)
+	})
+
+	t.Run("exists", func(t *testing.T) {
+		reg, err := au.BeginPasskeyRegistration(ctx, u.ID.Int64)
+		require.NoError(t, err)
+
+		// registered passkeys are excluded
+		require.Len(t, reg.PublicKey.ExcludeCredentials, 1)
+		require.Equal(t, pk.CredID, reg.PublicKey.ExcludeCredentials[0].ID)
+
+		_, err = au.FinishPasskeyRegistration(ctx, u.ID.Int64, reg.Token, "laptop", sa.create(reg.PublicKey))
+		require.ErrorIs(t, err, ErrPasskeyExists)
+	})
+
+	t.Run("login_with_email", func(t *testing.T) {
+		l, err := au.BeginPasskeyLogin(ctx, "passkey@mail.com")
+		require.NoError(t, err)
+		require.Len(t, l.PublicKey.AllowCredentials, 1)
+
+		s, err := au.FinishPasskeyLogin(ctx, l.Token, sa.get(l.PublicKey), ClientInfo{UserIP: "127.0.0.1", UserAgent: "test"})
+		require.NoError(t, err)
+		require.Equal(t, u.ID.Int64, s.UserID)
+		require.NoError(t, au.checkRefreshToken(ctx, shardid.Parse(s.UserID), s.RefreshToken))
+	})
+
+	t.Run("discoverable", func(t *testing.T) {
+		l, err := au.BeginPasskeyLogin(ctx, "")
+		require.NoError(t, err)
+		require.Empty(t, l.PublicKey.AllowCredentials)
+
+		s, err := au.FinishPasskeyLogin(ctx, l.Token, sa.get(l.PublicKey), ClientInfo{})
+		require.NoError(t, err)
+		require.Equal(t, u.ID.Int64, s.UserID)
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		// authenticators without counters always report 0, so only the challenge can stop a replayed assertion
+		nc := newSoftAuthenticator(au.rpID, au.rpOrigins[0])
+		reg, err := au.BeginPasskeyRegistration(ctx, u.ID.Int64)
+		require.NoError(t, err)
+		_, err = au.FinishPasskeyRegistration(ctx, u.ID.Int64, reg.Token, "key", nc.create(reg.PublicKey))
+		require.NoError(t, err)
+
+		l, err := au.BeginPasskeyLogin(ctx, "")
+		require.NoError(t, err)
+		nc.signCount = math.MaxUint32 // it wraps to 0
+		cred := nc.get(l.PublicKey)
+
+		_, err = au.FinishPasskeyLogin(ctx, l.Token, cred, ClientInfo{})
+		require.NoError(t, err)
+
+		_, err = au.FinishPasskeyLogin(ctx, l.Token, cred, ClientInfo{})
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("signature_should_match", func(t *testing.T) {
+		l, err := au.BeginPasskeyLogin(ctx, "")
+		require.NoError(t, err)
+		cred := sa.get(l.PublicKey)
+		cred.Response.Signature = encodeBase64URL(randBytes(70))
+		_, err = au.FinishPasskeyLogin(ctx, l.Token, cred, ClientInfo{})
+		require.ErrorIs(t, err, ErrPasskeyNotMatched)
+	})
+
+	t.Run("sign_counter_should_increase", func(t *testing.T) {
+		l, err := au.BeginPasskeyLogin(ctx, "")
+		require.NoError(t, err)
+		sa.signCount = 1
+		_, err = au.FinishPasskeyLogin(ctx, l.Token, sa.get(l.PublicKey), ClientInfo{})
+		require.ErrorIs(t, err, ErrPasskeyCloned)
+	})
+
+	t.Run("unknown_passkey", func(t *testing.T) {
+		l, err := au.BeginPasskeyLogin(ctx, "")
+		require.NoError(t, err)
+		other := newSoftAuthenticator(au.rpID, au.rpOrigins[0])
+		other.userID = sa.userID
+		_, err = au.FinishPasskeyLogin(ctx, l.Token, other.get(l.PublicKey), ClientInfo{})
+		require.ErrorIs(t, err, ErrPasskeyNotFound)
+	})
+
+	t.Run("other_ceremony", func(t *testing.T) {
+		reg, err := au.BeginPasskeyRegistration(ctx, u.ID.Int64)
+		require.NoError(t, err)
+		l, err := au.BeginPasskeyLogin(ctx, "")
+		require.NoError(t, err)
+
+		_, err = au.FinishPasskeyLogin(ctx, reg.Token, sa.get(l.PublicKey), ClientInfo{})
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+}
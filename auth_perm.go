@@ -56,7 +56,7 @@ func (a *Auth) RegisterPerm(ctx context.Context, code, tag string) error {
 
 // GrantPerms grant permissions to the role
 func (a *Auth) GrantPerms(ctx context.Context, rid int, codes ...string) error {
-	return a.db.Transaction(ctx, &sql.TxOptions{}, func(ctx context.Context, tx *sqle.Tx) error {
+	err := a.db.Transaction(ctx, &sql.TxOptions{}, func(ctx context.Context, tx *sqle.Tx) error {
 		var (
 			err error
 			n   int
@@ -116,11 +116,20 @@ func (a *Auth) GrantPerms(ctx context.Context, rid int, codes ...string) error {
 
 		return nil
 	})
+
+	if err != nil {
+		return err
+	}
+
+	a.audit(ctx, AuditPermGrant, AuditTagPerm, map[string]any{"role_id": rid, "codes": codes})
+	a.emitRoleChanged(ctx, rid, "grant", nil, codes)
+
+	return nil
 }
 
 // RevokePerms revoke permissions from the role
 func (a *Auth) RevokePerms(ctx context.Context, rid int, codes ...string) error {
-	return a.db.Transaction(ctx, &sql.TxOptions{}, func(ctx context.Context, tx *sqle.Tx) error {
+	err := a.db.Transaction(ctx, &sql.TxOptions{}, func(ctx context.Context, tx *sqle.Tx) error {
 		var err error
 
 		for _, code := range codes {
@@ -164,6 +173,15 @@ func (a *Auth) RevokePerms(ctx context.Context, rid int, codes ...string) error
 
 		return nil
 	})
+
+	if err != nil {
+		return err
+	}
+
+	a.audit(ctx, AuditPermRevoke, AuditTagPerm, map[string]any{"role_id": rid, "codes": codes})
+	a.emitRoleChanged(ctx, rid, "revoke", nil, codes)
+
+	return nil
 }
 
 // GetRolePerms get role's permissions
@@ -17,11 +17,16 @@ import (
 // UpdateProfile updates the profile of a user identified by the given ID.
 // It updates the email and mobile fields of the user's profile.
 // If the email or mobile value is empty, it will delete the corresponding field.
-// If the email or mobile value is different from the current value in the profile, it will update the field.
+// If the email or mobile value is different from the current value in the profile, it will update the field and clear its verified flag.
 // The function uses a transaction to ensure atomicity of the database operations.
 // It returns an error if any of the database operations fail.
+// The change is applied straight away, use ChangeEmail/ChangeMobile for the changes that are requested by users.
 func (a *Auth) UpdateProfile(ctx context.Context, id int64, email, mobile string) error {
+	return a.updateProfile(ctx, id, email, mobile, nil)
+}
 
+// updateProfile swaps the email and mobile of user in a DTC, and fn updates the other fields of profile data in the same DTC if it is not nil
+func (a *Auth) updateProfile(ctx context.Context, id int64, email, mobile string, fn func(pd *ProfileData)) error {
 	uid := shardid.Parse(id)
 	dbUser := a.db.On(uid)
 
@@ -30,7 +35,7 @@ func (a *Auth) UpdateProfile(ctx context.Context, id int64, email, mobile string
 		return err
 	}
 
-	if pd.Email == email && pd.Mobile == mobile {
+	if pd.Email == email && pd.Mobile == mobile && fn == nil {
 		return nil
 	}
 
@@ -150,8 +155,16 @@ func (a *Auth) UpdateProfile(ctx context.Context, id int64, email, mobile string
 
 	pd.Email = email
 	pd.Mobile = mobile
+	if fn != nil {
+		fn(&pd)
+	}
 	dtc.Prepare(dbUser, func(ctx context.Context, conn sqle.Connector) error {
-		return a.UpdateProfileData(ctx, conn, id, pd, now)
+		err := a.UpdateProfileData(ctx, conn, id, pd, now)
+		if err != nil {
+			return err
+		}
+
+		return a.resetVerified(ctx, conn, id, oldEmail != email, oldMobile != mobile)
 	}, nil)
 
 	err = dtc.Commit()
@@ -170,11 +183,17 @@ func (a *Auth) UpdateProfile(ctx context.Context, id int64, email, mobile string
 		return ErrBadDatabase
 	}
 
+	a.audit(ctx, AuditProfileUpdate, AuditTagUser, map[string]any{
+		"user_id": id,
+		"email":   masker.Email(email),
+		"mobile":  masker.Mobile(mobile),
+	})
+
 	return nil
 }
 
 // createProfile creates a new profile for the given user with the provided email, mobile, and current timestamp.
-// It generates a TOTP key and encrypts the profile data using the AES key if available.
+// It generates a TOTP key and encrypts the profile data using the data key of user if the AES key is available.
 // The profile is then inserted into the "user_profile" table using the provided database connection.
 // Returns the created profile and any error encountered during the process.
 func (a *Auth) createProfile(ctx context.Context, conn sqle.Connector, userID shardid.ID, email, mobile string, now time.Time) (Profile, error) {
@@ -203,19 +222,9 @@ func (a *Auth) createProfile(ctx context.Context, conn sqle.Connector, userID sh
 		TKey:   key.Secret(),
 	})
 
-	if a.aesKey == nil {
-		p.Data = string(buf)
-	} else {
-		ct, err := encryptText(buf, a.aesKey)
-		if err != nil {
-			a.logger.Error("auth: encryptText",
-				slog.String("tag", "crypto"),
-				slog.String("text", string(buf)),
-				slog.Any("err", err))
-			return p, ErrUnknown
-		}
-
-		p.Data = ct
+	p.Data, err = a.encryptUserData(ctx, conn, userID, string(buf))
+	if err != nil {
+		return p, err
 	}
 
 	_, err = conn.ExecBuilder(ctx, a.createBuilder().
@@ -270,7 +279,7 @@ func (a *Auth) GetProfileData(ctx context.Context, id int64) (ProfileData, error
 // It returns the profile data as a ProfileData struct and an error if any.
 // If the profile data is not found, it returns a default profile data and ErrProfileNotFound error.
 // If there is an error accessing the database, it returns a default profile data and ErrBadDatabase error.
-// If there is an error decrypting the profile data, it returns a default profile data and ErrUnknown error.
+// If there is an error decrypting the profile data, it returns a default profile data and ErrUnknown error, or ErrUserDataShredded if the data key of user is destroyed.
 // If there is an error unmarshaling the profile data, it returns a default profile data and ErrUnknown error.
 func (a *Auth) getProfileData(ctx context.Context, conn sqle.Connector, id int64) (ProfileData, error) {
 	var data string
@@ -298,17 +307,9 @@ func (a *Auth) getProfileData(ctx context.Context, conn sqle.Connector, id int64
 
 	var pd ProfileData
 
-	if a.aesKey != nil {
-		data, err = decryptText(data, a.aesKey)
-		if err != nil {
-			a.logger.Error("auth: getProfileData",
-				slog.String("step", "decryptText"),
-				slog.String("tag", "crypto"),
-				slog.String("text", data),
-				slog.Any("err", err))
-
-			return noProfileData, ErrUnknown
-		}
+	data, err = a.decryptUserData(ctx, conn, shardid.Parse(id), data)
+	if err != nil {
+		return noProfileData, err
 	}
 
 	err = json.Unmarshal([]byte(data), &pd)
@@ -333,10 +334,19 @@ func (a *Auth) getProfileData(ctx context.Context, conn sqle.Connector, id int64
 // It returns an error if there was a problem updating the profile data.
 func (a *Auth) UpdateProfileData(ctx context.Context, conn sqle.Connector, id int64, pd ProfileData, now time.Time) error {
 
-	_, err := conn.ExecBuilder(ctx, a.createBuilder().
+	uid := shardid.Parse(id)
+	fields, err := a.encryptUserFields(ctx, conn, uid, map[UserField]string{
+		UserEmail:  masker.Email(pd.Email),
+		UserMobile: masker.Mobile(pd.Mobile),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.ExecBuilder(ctx, a.createBuilder().
 		Update("<prefix>user").
-		Set("email", masker.Email(pd.Email)).
-		Set("mobile", masker.Mobile(pd.Mobile)).
+		Set("email", fields[UserEmail]).
+		Set("mobile", fields[UserMobile]).
 		Set("updated_at", now).
 		Where("id = {id}").
 		Param("id", id))
@@ -348,23 +358,19 @@ func (a *Auth) UpdateProfileData(ctx context.Context, conn sqle.Connector, id in
 		return ErrBadDatabase
 	}
 
-	buf, _ := json.Marshal(pd)
-
-	var data string
+	err = a.indexUserFields(ctx, conn, uid, map[UserField]string{
+		UserEmail:  pd.Email,
+		UserMobile: pd.Mobile,
+	})
+	if err != nil {
+		return err
+	}
 
-	if a.aesKey == nil {
-		data = string(buf)
-	} else {
-		ct, err := encryptText(buf, a.aesKey)
-		if err != nil {
-			a.logger.Error("auth: encryptText",
-				slog.String("tag", "crypto"),
-				slog.String("text", string(buf)),
-				slog.Any("err", err))
-			return ErrUnknown
-		}
+	buf, _ := json.Marshal(pd)
 
-		data = ct
+	data, err := a.encryptUserData(ctx, conn, uid, string(buf))
+	if err != nil {
+		return err
 	}
 
 	_, err = conn.ExecBuilder(ctx, a.createBuilder().
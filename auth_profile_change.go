@@ -0,0 +1,328 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/yaitoo/auth/masker"
+	"github.com/yaitoo/sqle/shardid"
+)
+
+const (
+	tokenChangeEmail   = "change:email"
+	tokenRevertProfile = "revert:profile"
+
+	profileChangeParam = "token"
+)
+
+// ChangeEmail stages the new email of user as pending, and sends a link to the new email to confirm it. The token is added to confirmURL as `token` parameter,
+// and confirmURL must be on one of magic link origins. The email is changed by ConfirmEmailChange.
+// It returns ErrRevertPending if the revert link of the last email change is not expired.
+func (a *Auth) ChangeEmail(ctx context.Context, id int64, email, confirmURL string, option LoginOption) (string, error) {
+	link, err := a.parseLink(confirmURL)
+	if err != nil {
+		return "", err
+	}
+
+	pd, err := a.GetProfileData(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	if err = checkRevertPending(pd.PrevEmail, pd.PrevEmailExpiresAt); err != nil {
+		return "", err
+	}
+
+	err = a.throttleSend(ctx, email, option.UserIP)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = a.getUserIDByEmail(ctx, email)
+	if err == nil {
+		return "", ErrEmailExists
+	}
+	if !errors.Is(err, ErrEmailNotFound) {
+		return "", err
+	}
+
+	err = a.setPendingProfile(ctx, id, func(pd *ProfileData) {
+		pd.PendingEmail = email
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// the token is bound to the pending email, so it is superseded by newer changes
+	token, err := a.signToken(tokenChangeEmail, TokenClaims{ID: id, Data: hashToken(email)}, a.verificationTTL)
+	if err != nil {
+		return "", err
+	}
+
+	q := link.Query()
+	q.Set(profileChangeParam, token)
+	link.RawQuery = q.Encode()
+
+	err = a.notify(ctx, ChannelEmail, MessageVerification, option.Locale, email, MessageData{Link: link.String(), ExpiresIn: a.verificationTTL})
+	if err != nil {
+		return "", err
+	}
+
+	return link.String(), nil
+}
+
+// ConfirmEmailChange changes the email of user to the pending email with the token sent by ChangeEmail, and sends a link to the old email to revert it.
+// The revert token is added to revertURL as `token` parameter, and the revert link is returned.
+func (a *Auth) ConfirmEmailChange(ctx context.Context, token, revertURL string, option LoginOption) (string, error) {
+	link, err := a.parseLink(revertURL)
+	if err != nil {
+		return "", err
+	}
+
+	c, err := a.parseToken(tokenChangeEmail, token)
+	if err != nil {
+		return "", err
+	}
+
+	pd, err := a.GetProfileData(ctx, c.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if pd.PendingEmail == "" || hashToken(pd.PendingEmail) != c.Data {
+		return "", ErrInvalidToken
+	}
+
+	oldEmail := pd.Email
+	err = a.updateProfile(ctx, c.ID, pd.PendingEmail, pd.Mobile, func(pd *ProfileData) {
+		pd.PendingEmail = ""
+		pd.PrevEmail = oldEmail
+		pd.PrevEmailExpiresAt = revertExpiresAt(oldEmail, a.revertTTL)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// the new email is verified by the link
+	err = a.setVerified(ctx, shardid.Parse(c.ID), "email")
+	if err != nil {
+		return "", err
+	}
+
+	if oldEmail == "" {
+		return "", nil
+	}
+
+	return a.sendRevertLink(ctx, c.ID, ChannelEmail, oldEmail, link, option)
+}
+
+// ChangeMobile stages the new mobile of user as pending, and sends a code to the new mobile to confirm it. The mobile is changed by ConfirmMobileChange.
+// It returns ErrRevertPending if the revert link of the last mobile change is not expired.
+func (a *Auth) ChangeMobile(ctx context.Context, id int64, mobile string, option LoginOption) (string, error) {
+	pd, err := a.GetProfileData(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	if err = checkRevertPending(pd.PrevMobile, pd.PrevMobileExpiresAt); err != nil {
+		return "", err
+	}
+
+	err = a.throttleSend(ctx, mobile, option.UserIP)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = a.getUserIDByMobile(ctx, mobile)
+	if err == nil {
+		return "", ErrMobileExists
+	}
+	if !errors.Is(err, ErrMobileNotFound) {
+		return "", err
+	}
+
+	err = a.setPendingProfile(ctx, id, func(pd *ProfileData) {
+		pd.PendingMobile = mobile
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// the code can only confirm the pending mobile, it can't be used to sign in
+	code := randStr(a.loginCodeSize, dicNumber)
+	err = a.saveLoginCode(ctx, shardid.Parse(id), codeChangeMobile, code, option.UserIP, a.verificationTTL)
+	if err != nil {
+		return "", err
+	}
+
+	err = a.notify(ctx, ChannelSMS, MessageVerification, option.Locale, mobile, MessageData{Code: code, ExpiresIn: a.verificationTTL})
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// ConfirmMobileChange changes the mobile of user to the pending mobile with the code sent by ChangeMobile, and sends a link to the old mobile to revert it.
+// The revert token is added to revertURL as `token` parameter, and the revert link is returned.
+func (a *Auth) ConfirmMobileChange(ctx context.Context, id int64, code, revertURL string, option LoginOption) (string, error) {
+	link, err := a.parseLink(revertURL)
+	if err != nil {
+		return "", err
+	}
+
+	pd, err := a.GetProfileData(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	if pd.PendingMobile == "" {
+		return "", ErrCodeNotMatched
+	}
+
+	uid := shardid.Parse(id)
+	_, err = a.consumeLoginCode(ctx, uid, codeChangeMobile, code)
+	if err != nil {
+		return "", err
+	}
+
+	oldMobile := pd.Mobile
+	err = a.updateProfile(ctx, id, pd.Email, pd.PendingMobile, func(pd *ProfileData) {
+		pd.PendingMobile = ""
+		pd.PrevMobile = oldMobile
+		pd.PrevMobileExpiresAt = revertExpiresAt(oldMobile, a.revertTTL)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// the new mobile is verified by the code
+	err = a.setVerified(ctx, uid, "mobile")
+	if err != nil {
+		return "", err
+	}
+
+	if oldMobile == "" {
+		return "", nil
+	}
+
+	return a.sendRevertLink(ctx, id, ChannelSMS, oldMobile, link, option)
+}
+
+// RevertProfileChange restores the email or mobile that is replaced with the token of a revert link, and revokes all sessions of user.
+func (a *Auth) RevertProfileChange(ctx context.Context, token string) error {
+	c, err := a.parseToken(tokenRevertProfile, token)
+	if err != nil {
+		return err
+	}
+
+	pd, err := a.GetProfileData(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+
+	email, mobile := pd.Email, pd.Mobile
+	var field string
+	switch {
+	case pd.PrevEmail != "" && c.Data == revertData(ChannelEmail, pd.PrevEmail):
+		email, field = pd.PrevEmail, "email"
+	case pd.PrevMobile != "" && c.Data == revertData(ChannelSMS, pd.PrevMobile):
+		mobile, field = pd.PrevMobile, "mobile"
+	default:
+		return ErrInvalidToken
+	}
+
+	err = a.updateProfile(ctx, c.ID, email, mobile, func(pd *ProfileData) {
+		if field == "email" {
+			pd.PendingEmail = ""
+			pd.PrevEmail = ""
+			pd.PrevEmailExpiresAt = 0
+		} else {
+			pd.PendingMobile = ""
+			pd.PrevMobile = ""
+			pd.PrevMobileExpiresAt = 0
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	uid := shardid.Parse(c.ID)
+
+	// the address is verified by the link
+	err = a.setVerified(ctx, uid, field)
+	if err != nil {
+		return err
+	}
+
+	err = a.deleteUserToken(ctx, uid, "")
+	if err != nil {
+		return err
+	}
+
+	a.audit(ctx, AuditProfileRevert, AuditTagUser, map[string]any{
+		"user_id": c.ID,
+		"email":   masker.Email(email),
+		"mobile":  masker.Mobile(mobile),
+	})
+
+	return nil
+}
+
+// setPendingProfile updates the pending fields of profile data
+func (a *Auth) setPendingProfile(ctx context.Context, id int64, fn func(pd *ProfileData)) error {
+	dbUser := a.db.On(shardid.Parse(id))
+
+	pd, err := a.getProfileData(ctx, dbUser, id)
+	if err != nil {
+		return err
+	}
+
+	fn(&pd)
+
+	return a.UpdateProfileData(ctx, dbUser, id, pd, time.Now())
+}
+
+// sendRevertLink sends a link to the replaced address that restores it
+func (a *Auth) sendRevertLink(ctx context.Context, id int64, ch Channel, to string, link *url.URL, option LoginOption) (string, error) {
+	// the token is bound to the replaced address, so it is invalid once the address is reverted or replaced again
+	token, err := a.signToken(tokenRevertProfile, TokenClaims{ID: id, Data: revertData(ch, to)}, a.revertTTL)
+	if err != nil {
+		return "", err
+	}
+
+	q := link.Query()
+	q.Set(profileChangeParam, token)
+	link.RawQuery = q.Encode()
+
+	err = a.notify(ctx, ch, MessageProfileChanged, option.Locale, to, MessageData{Link: link.String(), ExpiresIn: a.revertTTL})
+	if err != nil {
+		return "", err
+	}
+
+	return link.String(), nil
+}
+
+// checkRevertPending refuses to change the address again while the revert link of the last change is open,
+// otherwise a chained change would replace the address that the link restores.
+func checkRevertPending(prev string, expiresAt int64) error {
+	if prev != "" && time.Now().Unix() < expiresAt {
+		return ErrRevertPending
+	}
+	return nil
+}
+
+// revertExpiresAt returns the time that the revert link of the replaced address expires, nothing can be reverted if there is no replaced address
+func revertExpiresAt(prev string, ttl time.Duration) int64 {
+	if prev == "" {
+		return 0
+	}
+	return time.Now().Add(ttl).Unix()
+}
+
+// revertData binds a revert token to the replaced address
+func revertData(ch Channel, to string) string {
+	return string(ch) + ":" + hashToken(to)
+}
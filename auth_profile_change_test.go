@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileChange(t *testing.T) {
+	au := createAuthTest("./tests_profile_change.db")
+	au.magicLinkOrigins = []string{"https://app.test"}
+	ctx := context.Background()
+
+	tokenOf := func(link string) string {
+		l, err := url.Parse(link)
+		require.NoError(t, err)
+		return l.Query().Get("token")
+	}
+
+	u, err := au.CreateUser(ctx, UserStatusActivated, "old@mail.com", "+6588880002", "abc123", "", "")
+	require.NoError(t, err)
+
+	_, err = au.CreateUser(ctx, UserStatusActivated, "taken@mail.com", "", "abc123", "", "")
+	require.NoError(t, err)
+
+	t.Run("email", func(t *testing.T) {
+		_, err := au.ChangeEmail(ctx, u.ID.Int64, "taken@mail.com", "https://app.test/confirm", LoginOption{})
+		require.ErrorIs(t, err, ErrEmailExists)
+
+		link, err := au.ChangeEmail(ctx, u.ID.Int64, "new@mail.com", "https://app.test/confirm", LoginOption{})
+		require.NoError(t, err)
+
+		// pending until it is confirmed
+		_, err = au.GetUserByEmail(ctx, "new@mail.com")
+		require.ErrorIs(t, err, ErrEmailNotFound)
+		_, err = au.Login(ctx, "old@mail.com", "abc123", LoginOption{})
+		require.NoError(t, err)
+
+		revert, err := au.ConfirmEmailChange(ctx, tokenOf(link), "https://app.test/revert", LoginOption{})
+		require.NoError(t, err)
+		require.NotEmpty(t, revert)
+
+		_, err = au.ConfirmEmailChange(ctx, tokenOf(link), "https://app.test/revert", LoginOption{})
+		require.ErrorIs(t, err, ErrInvalidToken)
+
+		s, err := au.Login(ctx, "new@mail.com", "abc123", LoginOption{})
+		require.NoError(t, err)
+		_, err = au.GetUserByEmail(ctx, "old@mail.com")
+		require.ErrorIs(t, err, ErrEmailNotFound)
+
+		nu, err := au.getUserByID(ctx, u.ID)
+		require.NoError(t, err)
+		require.True(t, bool(nu.EmailVerified))
+
+		// this wasn't me
+		require.NoError(t, au.RevertProfileChange(ctx, tokenOf(revert)))
+		require.ErrorIs(t, au.RevertProfileChange(ctx, tokenOf(revert)), ErrInvalidToken)
+
+		_, err = au.Login(ctx, "old@mail.com", "abc123", LoginOption{})
+		require.NoError(t, err)
+		_, err = au.GetUserByEmail(ctx, "new@mail.com")
+		require.ErrorIs(t, err, ErrEmailNotFound)
+
+		// sessions are revoked
+		_, err = au.RefreshSession(ctx, s.RefreshToken, ClientInfo{})
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("superseded", func(t *testing.T) {
+		first, err := au.ChangeEmail(ctx, u.ID.Int64, "first@mail.com", "https://app.test/confirm", LoginOption{})
+		require.NoError(t, err)
+
+		_, err = au.ChangeEmail(ctx, u.ID.Int64, "second@mail.com", "https://app.test/confirm", LoginOption{})
+		require.NoError(t, err)
+
+		_, err = au.ConfirmEmailChange(ctx, tokenOf(first), "https://app.test/revert", LoginOption{})
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("mobile", func(t *testing.T) {
+		code, err := au.ChangeMobile(ctx, u.ID.Int64, "+6588880003", LoginOption{})
+		require.NoError(t, err)
+
+		_, err = au.ConfirmMobileChange(ctx, u.ID.Int64, "wrong", "https://app.test/revert", LoginOption{})
+		require.ErrorIs(t, err, ErrCodeNotMatched)
+
+		revert, err := au.ConfirmMobileChange(ctx, u.ID.Int64, code, "https://app.test/revert", LoginOption{})
+		require.NoError(t, err)
+
+		pd, err := au.GetProfileData(ctx, u.ID.Int64)
+		require.NoError(t, err)
+		require.Equal(t, "+6588880003", pd.Mobile)
+		require.Empty(t, pd.PendingMobile)
+
+		require.NoError(t, au.RevertProfileChange(ctx, tokenOf(revert)))
+
+		pd, err = au.GetProfileData(ctx, u.ID.Int64)
+		require.NoError(t, err)
+		require.Equal(t, "+6588880002", pd.Mobile)
+
+		_, err = au.GetUserByMobile(ctx, "+6588880003")
+		require.ErrorIs(t, err, ErrMobileNotFound)
+	})
+
+	t.Run("chained", func(t *testing.T) {
+		link, err := au.ChangeEmail(ctx, u.ID.Int64, "chained1@mail.com", "https://app.test/confirm", LoginOption{})
+		require.NoError(t, err)
+		revert, err := au.ConfirmEmailChange(ctx, tokenOf(link), "https://app.test/revert", LoginOption{})
+		require.NoError(t, err)
+
+		// the address that the revert link restores can't be replaced again
+		_, err = au.ChangeEmail(ctx, u.ID.Int64, "chained2@mail.com", "https://app.test/confirm", LoginOption{})
+		require.ErrorIs(t, err, ErrRevertPending)
+
+		code, err := au.ChangeMobile(ctx, u.ID.Int64, "+6588880004", LoginOption{})
+		require.NoError(t, err)
+		mobileRevert, err := au.ConfirmMobileChange(ctx, u.ID.Int64, code, "https://app.test/revert", LoginOption{})
+		require.NoError(t, err)
+
+		_, err = au.ChangeMobile(ctx, u.ID.Int64, "+6588880005", LoginOption{})
+		require.ErrorIs(t, err, ErrRevertPending)
+
+		require.NoError(t, au.RevertProfileChange(ctx, tokenOf(revert)))
+		require.NoError(t, au.RevertProfileChange(ctx, tokenOf(mobileRevert)))
+
+		pd, err := au.GetProfileData(ctx, u.ID.Int64)
+		require.NoError(t, err)
+		require.Equal(t, "old@mail.com", pd.Email)
+		require.Equal(t, "+6588880002", pd.Mobile)
+
+		// it can be changed once the address is restored
+		_, err = au.ChangeEmail(ctx, u.ID.Int64, "chained2@mail.com", "https://app.test/confirm", LoginOption{})
+		require.NoError(t, err)
+	})
+}
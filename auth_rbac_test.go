@@ -75,9 +75,9 @@ func TestRBAC(t *testing.T) {
 				perms, err := au.QueryPerms(context.Background(), nil)
 				r.NoError(err)
 
-				slices.ContainsFunc(perms, func(it Perm) bool {
+				r.True(slices.ContainsFunc(perms, func(it Perm) bool {
 					return it.Code == "reg_perm_code" && it.Tag == "test"
-				})
+				}))
 
 			},
 		},
@@ -87,9 +87,9 @@ func TestRBAC(t *testing.T) {
 				items, err := au.QueryRoles(context.Background(), nil)
 				r.NoError(err)
 
-				slices.ContainsFunc(items, func(it Role) bool {
+				r.True(slices.ContainsFunc(items, func(it Role) bool {
 					return it.Name == "create_role"
-				})
+				}))
 
 			},
 		},
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// assessRisk assesses the login attempt by risk engine, and challenges it if the source is challenged for credential stuffing.
+func (a *Auth) assessRisk(ctx context.Context, u User, method LoginMethod, userIP, userAgent string) (Risk, error) {
+	risk, err := a.assessRiskSignals(ctx, u, method, userIP, userAgent)
+	if err != nil {
+		return noRisk, err
+	}
+
+	if risk.Decision == RiskAllow {
+		if d, _ := a.checkSource(ctx, userIP); d == RiskChallenge {
+			risk.Decision = RiskChallenge
+			risk.Reasons = append(risk.Reasons, RiskCredentialStuffing)
+		}
+	}
+
+	return risk, nil
+}
+
+// assessRiskSignals collects signals of the login attempt, and assesses them by risk engine.
+// The attempt is allowed if risk engine is not set or it fails.
+func (a *Auth) assessRiskSignals(ctx context.Context, u User, method LoginMethod, userIP, userAgent string) (Risk, error) {
+	if a.riskEngine == nil {
+		return Risk{Decision: RiskAllow}, nil
+	}
+
+	s := RiskSignals{
+		UserID: u.ID.Int64,
+		Method: method,
+		IP:     userIP,
+		UA:     userAgent,
+	}
+
+	fails, _, err := a.getLoginFails(ctx, u.ID)
+	if err != nil {
+		return noRisk, err
+	}
+	s.RecentFails = fails
+
+	var loginTimes int
+	err = a.db.On(u.ID).
+		QueryRowBuilder(ctx, a.createBuilder().
+			Select("<prefix>user_device", "login_times").
+			Where("user_id = {user_id} AND ua = {ua}").
+			Param("user_id", u.ID.Int64).
+			Param("ua", deviceUA(userAgent))).
+		Scan(&loginTimes)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		a.logger.Error("auth: assessRisk:device",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", u.ID.Int64),
+			slog.Any("err", err))
+		return noRisk, ErrBadDatabase
+	}
+	s.NewDevice = loginTimes == 0
+
+	// the latest ip that user signed in from
+	var prevIP string
+	var prevAt time.Time
+	err = a.db.On(u.ID).
+		QueryRowBuilder(ctx, a.createBuilder().
+			Select("<prefix>user_geo", "ip", "updated_at").
+			Where("user_id = {user_id}").
+			Param("user_id", u.ID.Int64).
+			SQL(" ORDER BY updated_at DESC LIMIT 1")).
+		Scan(&prevIP, &prevAt)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		a.logger.Error("auth: assessRisk:geo",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", u.ID.Int64),
+			slog.Any("err", err))
+		return noRisk, ErrBadDatabase
+	}
+
+	if userIP != "" {
+		s.Geo = a.resolveGeo(ctx, userIP)
+	}
+
+	if prevIP != "" && prevIP != userIP {
+		s.PrevGeo = a.resolveGeo(ctx, prevIP)
+		s.PrevAt = prevAt
+	}
+
+	risk, err := a.riskEngine.Assess(ctx, s)
+	if err != nil {
+		a.logger.Warn("auth: assessRisk",
+			slog.String("tag", "risk"),
+			slog.Int64("user_id", u.ID.Int64),
+			slog.Any("err", err))
+		return Risk{Decision: RiskAllow}, nil
+	}
+
+	return risk, nil
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginRisk(t *testing.T) {
+	au := createAuthTest("./tests_login_risk.db")
+	ctx := context.Background()
+
+	bad, err := NewIPList("6.6.6.0/24")
+	require.NoError(t, err)
+
+	r := DefaultRiskRules
+	r.BadIPs = bad
+	au.riskEngine = r
+
+	_, err = au.CreateUser(ctx, UserStatusActivated, "risk@mail.com", "", "abc123", "", "")
+	require.NoError(t, err)
+	mfa, err := au.CreateUser(ctx, UserStatusActivated, "riskmfa@mail.com", "", "abc123", "", "")
+	require.NoError(t, err)
+
+	setup, err := au.BeginMFADevice(ctx, mfa.ID.Int64, MFATOTP, "phone", "")
+	require.NoError(t, err)
+	code, err := totp.GenerateCode(setup.Secret, time.Now())
+	require.NoError(t, err)
+	phone, err := au.FinishMFADevice(ctx, mfa.ID.Int64, setup.Token, code)
+	require.NoError(t, err)
+
+	t.Run("allow", func(t *testing.T) {
+		_, err := au.Login(ctx, "risk@mail.com", "abc123", LoginOption{UserIP: "1.1.1.1", UserAgent: "laptop"})
+		require.NoError(t, err)
+	})
+
+	t.Run("challenge_without_mfa", func(t *testing.T) {
+		u, err := au.GetUserByEmail(ctx, "risk@mail.com")
+		require.NoError(t, err)
+
+		// the code is sent to the profile email if user has no second-factor device
+		s, err := au.Login(ctx, "risk@mail.com", "abc123", LoginOption{UserIP: "6.6.6.6", UserAgent: "laptop"})
+		require.ErrorIs(t, err, ErrMFARequired)
+		require.Len(t, s.MFA.Methods, 1)
+		require.Equal(t, mfaFallbackEmail, s.MFA.Methods[0].ID)
+		require.Equal(t, MFAEmail, s.MFA.Methods[0].Kind)
+
+		code, err := au.SendMFACode(ctx, s.MFA.Token, mfaFallbackEmail)
+		require.NoError(t, err)
+
+		_, err = au.VerifyMFA(ctx, s.MFA.Token, mfaFallbackMobile, code, false, ClientInfo{UserIP: "6.6.6.6", UserAgent: "laptop"})
+		require.ErrorIs(t, err, ErrMFADeviceNotFound)
+
+		s, err = au.VerifyMFA(ctx, s.MFA.Token, mfaFallbackEmail, code, false, ClientInfo{UserIP: "6.6.6.6", UserAgent: "laptop"})
+		require.NoError(t, err)
+		require.NotEmpty(t, s.AccessToken)
+
+		items, _, err := au.QueryLoginLogs(ctx, u.ID.Int64, time.Now().Add(-time.Minute), time.Now().Add(time.Minute), 0)
+		require.NoError(t, err)
+		require.True(t, bool(items[0].IsOK))
+		require.Equal(t, RiskChallenge, items[0].RiskDecision)
+		require.Equal(t, RiskBadIP, items[0].RiskReasons)
+	})
+
+	t.Run("challenge_code_on_login_ip", func(t *testing.T) {
+		// the code is requested from a good ip, but the login is from a bad ip
+		code, err := au.CreateLoginCode(ctx, "risk@mail.com", LoginOption{UserIP: "1.1.1.1"})
+		require.NoError(t, err)
+
+		s, err := au.LoginWithCode(ctx, "risk@mail.com", code, LoginOption{UserIP: "6.6.6.6", UserAgent: "laptop"})
+		require.ErrorIs(t, err, ErrMFARequired)
+		require.NotNil(t, s.MFA)
+	})
+
+	t.Run("challenge_otp", func(t *testing.T) {
+		u, err := au.GetUserByEmail(ctx, "risk@mail.com")
+		require.NoError(t, err)
+		pd, err := au.GetProfileData(ctx, u.ID.Int64)
+		require.NoError(t, err)
+		otp, err := totp.GenerateCode(pd.TKey, time.Now())
+		require.NoError(t, err)
+
+		s, err := au.LoginWithOTP(ctx, "risk@mail.com", otp, LoginOption{UserIP: "6.6.6.6", UserAgent: "laptop"})
+		require.ErrorIs(t, err, ErrMFARequired)
+		require.NotNil(t, s.MFA)
+	})
+
+	t.Run("fallback_token_should_not_pass_mfa", func(t *testing.T) {
+		s, err := au.Login(ctx, "riskmfa@mail.com", "abc123", LoginOption{UserIP: "1.1.1.1", UserAgent: "laptop"})
+		require.ErrorIs(t, err, ErrMFARequired)
+
+		// the profile email can't replace the enrolled devices
+		_, err = au.SendMFACode(ctx, s.MFA.Token, mfaFallbackEmail)
+		require.ErrorIs(t, err, ErrMFADeviceNotFound)
+	})
+
+	t.Run("challenge_magic_link", func(t *testing.T) {
+		link, err := au.CreateMagicLink(ctx, "riskmfa@mail.com", au.magicLinkOrigins[0]+"/login", LoginOption{})
+		require.NoError(t, err)
+
+		s, err := au.LoginWithMagicLink(ctx, getMagicLinkToken(t, link), ClientInfo{UserIP: "1.1.1.1", UserAgent: "laptop"})
+		require.ErrorIs(t, err, ErrMFARequired)
+		require.Equal(t, phone.ID, s.MFA.Methods[0].ID)
+	})
+
+	t.Run("challenge_magic_link_handler", func(t *testing.T) {
+		link, err := au.CreateMagicLink(ctx, "riskmfa@mail.com", au.magicLinkOrigins[0]+"/login", LoginOption{})
+		require.NoError(t, err)
+
+		h := NewHandler(au)
+		r := httptest.NewRequest(http.MethodPost, "/magic-link/login", strings.NewReader(`{"token":"`+getMagicLinkToken(t, link)+`"}`))
+		w := httptest.NewRecorder()
+		h.LoginWithMagicLink(ctx, w, r)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+
+		// the challenge is returned, so the client can pass it
+		var result JsonResult[MFAChallenge]
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		require.Equal(t, ErrMFARequired.Error(), result.ErrorCode)
+		require.NotEmpty(t, result.Result.Token)
+		require.Equal(t, phone.ID, result.Result.Methods[0].ID)
+	})
+
+	t.Run("challenge_trusted_device", func(t *testing.T) {
+		s, err := au.Login(ctx, "riskmfa@mail.com", "abc123", LoginOption{UserIP: "1.1.1.1", UserAgent: "laptop"})
+		require.ErrorIs(t, err, ErrMFARequired)
+
+		code, err := totp.GenerateCode(setup.Secret, time.Now())
+		require.NoError(t, err)
+		s, err = au.VerifyMFA(ctx, s.MFA.Token, phone.ID, code, true, ClientInfo{UserIP: "1.1.1.1", UserAgent: "laptop"})
+		require.NoError(t, err)
+		trusted := s.TrustedDevice
+
+		_, err = au.Login(ctx, "riskmfa@mail.com", "abc123", LoginOption{UserIP: "1.1.1.1", UserAgent: "laptop", TrustedDevice: trusted})
+		require.NoError(t, err)
+
+		// MFA is required on trusted device if the login is risky
+		s, err = au.Login(ctx, "riskmfa@mail.com", "abc123", LoginOption{UserIP: "6.6.6.6", UserAgent: "laptop", TrustedDevice: trusted})
+		require.ErrorIs(t, err, ErrMFARequired)
+		require.NotNil(t, s.MFA)
+	})
+}
@@ -67,6 +67,8 @@ func (a *Auth) CreateRole(ctx context.Context, name string) (int, error) {
 		return 0, ErrBadDatabase
 	}
 
+	a.audit(ctx, AuditRoleCreate, AuditTagRole, map[string]any{"role_id": id, "name": name})
+
 	return int(id), nil
 }
 
@@ -151,7 +153,8 @@ WHERE role_id = {role_id}`).
 	}
 
 	for i, it := range items {
-		err := a.db.On(it.ID).
+		dbUser := a.db.On(it.ID)
+		err := dbUser.
 			QueryRowBuilder(ctx, a.createBuilder().
 				Select("<prefix>user").
 				Where("id = {id}").Param("id", it.ID.Int64)).
@@ -164,6 +167,11 @@ WHERE role_id = {role_id}`).
 				slog.Any("err", err))
 			return nil, ErrBadDatabase
 		}
+
+		err = a.decryptUser(ctx, dbUser, &items[i])
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return items, nil
@@ -171,7 +179,7 @@ WHERE role_id = {role_id}`).
 
 // AddRoleUsers add users into the role
 func (a *Auth) AddRoleUsers(ctx context.Context, rid int, uIDs ...int64) error {
-	return a.db.Transaction(ctx, &sql.TxOptions{}, func(ctx context.Context, tx *sqle.Tx) error {
+	err := a.db.Transaction(ctx, &sql.TxOptions{}, func(ctx context.Context, tx *sqle.Tx) error {
 		now := time.Now()
 		var (
 			err error
@@ -235,11 +243,19 @@ func (a *Auth) AddRoleUsers(ctx context.Context, rid int, uIDs ...int64) error {
 		return nil
 	})
 
+	if err != nil {
+		return err
+	}
+
+	a.audit(ctx, AuditRoleAddUsers, AuditTagRole, map[string]any{"role_id": rid, "user_ids": uIDs})
+	a.emitRoleChanged(ctx, rid, "add", uIDs, nil)
+
+	return nil
 }
 
 // DeleteRoleUsers delete users from the role
 func (a *Auth) DeleteRoleUsers(ctx context.Context, rid int, uIDs ...int64) error {
-	return a.db.Transaction(ctx, &sql.TxOptions{}, func(ctx context.Context, tx *sqle.Tx) error {
+	err := a.db.Transaction(ctx, &sql.TxOptions{}, func(ctx context.Context, tx *sqle.Tx) error {
 		var err error
 		for _, uid := range uIDs {
 			_, err = tx.ExecBuilder(ctx, a.createBuilder().
@@ -284,4 +300,13 @@ func (a *Auth) DeleteRoleUsers(ctx context.Context, rid int, uIDs ...int64) erro
 
 		return nil
 	})
+
+	if err != nil {
+		return err
+	}
+
+	a.audit(ctx, AuditRoleDeleteUsers, AuditTagRole, map[string]any{"role_id": rid, "user_ids": uIDs})
+	a.emitRoleChanged(ctx, rid, "delete", uIDs, nil)
+
+	return nil
 }
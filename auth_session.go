@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"errors"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/yaitoo/sqle/shardid"
@@ -54,6 +55,15 @@ func (a *Auth) RefreshSession(ctx context.Context, refreshToken string, clientIn
 
 	err = a.checkRefreshToken(ctx, uid, refreshToken)
 	if err != nil {
+		// a valid refresh token that is not found has been rotated or revoked
+		if errors.Is(err, ErrInvalidToken) {
+			a.emit(ctx, Event{
+				Type:   EventTokenReused,
+				UserID: uid.Int64,
+				IP:     clientInfo.UserIP,
+				UA:     clientInfo.UserAgent,
+			})
+		}
 		return noSession, err
 	}
 
@@ -64,5 +74,9 @@ func (a *Auth) RefreshSession(ctx context.Context, refreshToken string, clientIn
 		return noSession, err
 	}
 
+	if err = a.checkUserStatus(u); err != nil {
+		return noSession, err
+	}
+
 	return a.createSession(ctx, uid, u.FirstName, u.FirstName, clientInfo.UserIP, clientInfo.UserAgent)
 }
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// ListBlockedSources returns the sources that are blocked or challenged for credential stuffing now.
+func (a *Auth) ListBlockedSources(ctx context.Context) ([]BlockedSource, error) {
+	items, err := a.sources.ListBlocks(ctx)
+	if err != nil {
+		a.logger.Error("auth: ListBlockedSources",
+			slog.String("tag", "stuffing"),
+			slog.Any("err", err))
+		return nil, ErrUnknown
+	}
+
+	return items, nil
+}
+
+// UnblockSource removes the block of an ip or network, and resets its failed logins.
+func (a *Auth) UnblockSource(ctx context.Context, source string) error {
+	err := a.sources.Unblock(ctx, source)
+	if err != nil {
+		a.logger.Error("auth: UnblockSource",
+			slog.String("tag", "stuffing"),
+			slog.String("source", source),
+			slog.Any("err", err))
+		return ErrUnknown
+	}
+
+	return nil
+}
+
+// failSource counts a failed login from userIP across all accounts, and blocks the ip or its network if a threshold is crossed.
+// It doesn't fail the login, errors are logged only.
+func (a *Auth) failSource(ctx context.Context, userIP string) {
+	if userIP == "" {
+		return
+	}
+
+	hit := func(source string, threshold int64) {
+		if source == "" || threshold <= 0 {
+			return
+		}
+
+		n, err := a.sources.Hit(ctx, source, a.stuffingGuard.Window)
+		if err == nil && n >= threshold {
+			err = a.sources.Block(ctx, BlockedSource{
+				Source: source,
+				Action: a.stuffingGuard.Action,
+				Fails:  n,
+				Until:  time.Now().Add(a.stuffingGuard.Cooldown),
+			})
+		}
+
+		if err != nil {
+			a.logger.Error("auth: failSource",
+				slog.String("tag", "stuffing"),
+				slog.String("source", source),
+				slog.Any("err", err))
+		}
+	}
+
+	hit(userIP, a.stuffingGuard.IPThreshold)
+	hit(sourceNetwork(userIP), a.stuffingGuard.NetworkThreshold)
+}
+
+// checkSource returns RetryAfterError if userIP or its network is blocked, or RiskChallenge if it should be challenged.
+// The source is allowed if source store fails.
+func (a *Auth) checkSource(ctx context.Context, userIP string) (RiskDecision, error) {
+	if userIP == "" || (a.stuffingGuard.IPThreshold <= 0 && a.stuffingGuard.NetworkThreshold <= 0) {
+		return RiskAllow, nil
+	}
+
+	items, err := a.sources.GetBlocks(ctx, userIP, sourceNetwork(userIP))
+	if err != nil {
+		a.logger.Error("auth: checkSource",
+			slog.String("tag", "stuffing"),
+			slog.String("ip", userIP),
+			slog.Any("err", err))
+		return RiskAllow, nil
+	}
+
+	decision := RiskAllow
+	var until time.Time
+	for _, it := range items {
+		if it.Action == RiskBlock {
+			if it.Until.After(until) {
+				until = it.Until
+			}
+			continue
+		}
+		decision = RiskChallenge
+	}
+
+	if !until.IsZero() {
+		return RiskBlock, &RetryAfterError{RetryAfter: time.Until(until)}
+	}
+
+	return decision, nil
+}
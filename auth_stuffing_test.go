@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceNetwork(t *testing.T) {
+	require.Equal(t, "1.2.3.0/24", sourceNetwork("1.2.3.4"))
+	require.Equal(t, "2001:db8:1:2::/64", sourceNetwork("2001:db8:1:2:3:4:5:6"))
+	require.Empty(t, sourceNetwork("bad"))
+}
+
+func TestMemorySourceStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemorySourceStore()
+
+	for i := int64(1); i <= 3; i++ {
+		n, err := s.Hit(ctx, "1.1.1.1", time.Hour)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, n, i)
+	}
+
+	// the previous window is weighted by its overlap with the sliding window
+	c := s.counters["1.1.1.1"]
+	c.prev, c.cur = 10, 0
+	c.start = time.Now().Truncate(time.Hour)
+	n, err := s.Hit(ctx, "1.1.1.1", time.Hour)
+	require.NoError(t, err)
+	require.LessOrEqual(t, n, int64(11))
+
+	require.NoError(t, s.Block(ctx, BlockedSource{Source: "1.1.1.1", Action: RiskBlock, Until: time.Now().Add(time.Minute)}))
+	require.NoError(t, s.Block(ctx, BlockedSource{Source: "2.2.2.2", Action: RiskBlock, Until: time.Now().Add(-time.Minute)}))
+
+	items, err := s.ListBlocks(ctx)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+
+	items, err = s.GetBlocks(ctx, "1.1.1.1", "2.2.2.2", "3.3.3.3")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	require.Equal(t, "1.1.1.1", items[0].Source)
+
+	require.NoError(t, s.Unblock(ctx, "1.1.1.1"))
+	items, err = s.ListBlocks(ctx)
+	require.NoError(t, err)
+	require.Empty(t, items)
+}
+
+func TestCredentialStuffing(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("block_ip", func(t *testing.T) {
+		au := createAuthTest("./tests_stuffing_block.db")
+		au.stuffingGuard = StuffingGuard{Window: time.Minute, IPThreshold: 3, Cooldown: time.Minute, Action: RiskBlock}
+
+		_, err := au.CreateUser(ctx, UserStatusActivated, "stuffing@mail.com", "", "abc123", "", "")
+		require.NoError(t, err)
+
+		bot := LoginOption{UserIP: "6.6.6.6"}
+
+		// different accounts from one ip
+		_, err = au.Login(ctx, "victim1@mail.com", "123456", bot)
+		require.ErrorIs(t, err, ErrEmailNotFound)
+		_, err = au.Login(ctx, "victim2@mail.com", "123456", bot)
+		require.ErrorIs(t, err, ErrEmailNotFound)
+		_, err = au.Login(ctx, "stuffing@mail.com", "123456", bot)
+		require.ErrorIs(t, err, ErrPasswdNotMatched)
+
+		_, err = au.Login(ctx, "stuffing@mail.com", "abc123", bot)
+		require.ErrorIs(t, err, ErrTooManyRequests)
+		_, err = au.Login(ctx, "victim3@mail.com", "123456", bot)
+		require.ErrorIs(t, err, ErrTooManyRequests)
+
+		// other ips are not blocked
+		_, err = au.Login(ctx, "stuffing@mail.com", "abc123", LoginOption{UserIP: "6.6.6.7"})
+		require.NoError(t, err)
+
+		items, err := au.ListBlockedSources(ctx)
+		require.NoError(t, err)
+		require.Len(t, items, 1)
+		require.Equal(t, "6.6.6.6", items[0].Source)
+		require.EqualValues(t, 3, items[0].Fails)
+
+		require.NoError(t, au.UnblockSource(ctx, "6.6.6.6"))
+		_, err = au.Login(ctx, "stuffing@mail.com", "abc123", bot)
+		require.NoError(t, err)
+	})
+
+	t.Run("challenge_network", func(t *testing.T) {
+		au := createAuthTest("./tests_stuffing_challenge.db")
+		au.stuffingGuard = StuffingGuard{Window: time.Minute, NetworkThreshold: 3, Cooldown: time.Minute, Action: RiskChallenge}
+
+		u, err := au.CreateUser(ctx, UserStatusActivated, "stuffing@mail.com", "", "abc123", "", "")
+		require.NoError(t, err)
+
+		setup, err := au.BeginMFADevice(ctx, u.ID.Int64, MFATOTP, "phone", "")
+		require.NoError(t, err)
+		code, err := totp.GenerateCode(setup.Secret, time.Now())
+		require.NoError(t, err)
+		_, err = au.FinishMFADevice(ctx, u.ID.Int64, setup.Token, code)
+		require.NoError(t, err)
+
+		for _, ip := range []string{"7.7.7.1", "7.7.7.2", "7.7.7.3"} {
+			_, err = au.Login(ctx, "victim@mail.com", "123456", LoginOption{UserIP: ip})
+			require.ErrorIs(t, err, ErrEmailNotFound)
+		}
+
+		items, err := au.ListBlockedSources(ctx)
+		require.NoError(t, err)
+		require.Len(t, items, 1)
+		require.Equal(t, "7.7.7.0/24", items[0].Source)
+		require.Equal(t, RiskChallenge, items[0].Action)
+
+		// a challenged source can still sign in with MFA
+		s, err := au.Login(ctx, "stuffing@mail.com", "abc123", LoginOption{UserIP: "7.7.7.9"})
+		require.ErrorIs(t, err, ErrMFARequired)
+		require.NotNil(t, s.MFA)
+	})
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// throttleSend counts a send to identity from userIP, and returns RetryAfterError if any limit of SendThrottle is hit
+func (a *Auth) throttleSend(ctx context.Context, identity, userIP string) error {
+	identity = strings.ToLower(strings.TrimSpace(identity))
+
+	var retryAfter time.Duration
+
+	check := func(key string, limits []Limit) error {
+		for _, l := range limits {
+			// limits of the same window are counted apart, eg 3/1h and 10/1h
+			n, resetAt, err := a.counters.Incr(ctx, "send:"+key+":"+strconv.FormatInt(l.Count, 10)+"/"+strconv.FormatInt(int64(l.Window.Seconds()), 10), l.Window)
+			if err != nil {
+				a.logger.Error("auth: throttleSend",
+					slog.String("tag", "throttle"),
+					slog.String("key", key),
+					slog.Any("err", err))
+				return ErrUnknown
+			}
+
+			if n > l.Count {
+				if d := time.Until(resetAt); d > retryAfter {
+					retryAfter = d
+				}
+			}
+		}
+
+		return nil
+	}
+
+	if err := check("id:"+identity, a.sendThrottle.Identity); err != nil {
+		return err
+	}
+
+	if userIP != "" {
+		if err := check("ip:"+userIP, a.sendThrottle.IP); err != nil {
+			return err
+		}
+	}
+
+	if err := check("global", a.sendThrottle.Global); err != nil {
+		return err
+	}
+
+	if retryAfter > 0 {
+		return &RetryAfterError{RetryAfter: retryAfter}
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryCounterStore(t *testing.T) {
+	s := NewMemoryCounterStore()
+	ctx := context.Background()
+
+	n, resetAt, err := s.Incr(ctx, "k", 50*time.Millisecond)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, n)
+
+	n, resetAt2, err := s.Incr(ctx, "k", 50*time.Millisecond)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, n)
+	require.Equal(t, resetAt, resetAt2)
+
+	time.Sleep(60 * time.Millisecond)
+
+	n, _, err = s.Incr(ctx, "k", 50*time.Millisecond)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, n)
+}
+
+func TestSendThrottle(t *testing.T) {
+	au := createAuthTest("./tests_throttle.db")
+	ctx := context.Background()
+
+	t.Run("default", func(t *testing.T) {
+		require.Equal(t, defaultSendThrottle.Identity, au.sendThrottle.Identity)
+		require.Equal(t, defaultSendThrottle.IP, au.sendThrottle.IP)
+
+		for i := 0; i < 5; i++ {
+			require.NoError(t, au.throttleSend(ctx, "default@mail.com", ""))
+		}
+		require.ErrorIs(t, au.throttleSend(ctx, "default@mail.com", ""), ErrTooManyRequests)
+
+		// the limits can be overridden
+		a := New(au.db, WithSendThrottle(SendThrottle{Identity: []Limit{{Count: 1, Window: time.Minute}}, IP: []Limit{}}))
+		require.Equal(t, []Limit{{Count: 1, Window: time.Minute}}, a.sendThrottle.Identity)
+		require.Empty(t, a.sendThrottle.IP)
+	})
+
+	t.Run("same_window", func(t *testing.T) {
+		au.sendThrottle = SendThrottle{Identity: []Limit{{Count: 3, Window: time.Hour}, {Count: 10, Window: time.Hour}}}
+		au.counters = NewMemoryCounterStore()
+
+		// each limit has its own counter, a send isn't counted twice
+		for i := 0; i < 3; i++ {
+			require.NoError(t, au.throttleSend(ctx, "window@mail.com", ""))
+		}
+		require.ErrorIs(t, au.throttleSend(ctx, "window@mail.com", ""), ErrTooManyRequests)
+	})
+
+	au.sendThrottle = SendThrottle{
+		Identity: []Limit{{Count: 1, Window: time.Minute}, {Count: 5, Window: time.Hour}},
+		IP:       []Limit{{Count: 2, Window: time.Minute}},
+		Global:   []Limit{{Count: 4, Window: time.Minute}},
+	}
+	au.counters = NewMemoryCounterStore()
+
+	t.Run("identity", func(t *testing.T) {
+		_, err := au.CreateLoginCode(ctx, "throttle@mail.com", LoginOption{CreateIfNotExists: true, UserIP: "1.1.1.1"})
+		require.NoError(t, err)
+
+		_, err = au.CreateLoginCode(ctx, "Throttle@mail.com", LoginOption{UserIP: "2.2.2.2"})
+		require.ErrorIs(t, err, ErrTooManyRequests)
+
+		var ra *RetryAfterError
+		require.True(t, errors.As(err, &ra))
+		require.True(t, ra.RetryAfter > 0 && ra.RetryAfter <= time.Minute)
+	})
+
+	t.Run("ip", func(t *testing.T) {
+		_, err := au.CreateLoginMobileCode(ctx, "1+222333444", LoginOption{CreateIfNotExists: true, UserIP: "1.1.1.1"})
+		require.NoError(t, err)
+		_, err = au.CreateLoginMobileCode(ctx, "1+333444555", LoginOption{CreateIfNotExists: true, UserIP: "1.1.1.1"})
+		require.ErrorIs(t, err, ErrTooManyRequests)
+	})
+
+	t.Run("global", func(t *testing.T) {
+		_, err := au.CreateLoginMobileCode(ctx, "1+444555666", LoginOption{CreateIfNotExists: true, UserIP: "3.3.3.3"})
+		require.ErrorIs(t, err, ErrTooManyRequests)
+
+		w := httptest.NewRecorder()
+		WriteClientError(w, err)
+		require.Equal(t, 429, w.Code)
+		require.NotEmpty(t, w.Header().Get("Retry-After"))
+	})
+}
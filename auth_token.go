@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signToken signs claims for purpose, it expires after ttl
+func (a *Auth) signToken(purpose string, c TokenClaims, ttl time.Duration) (string, error) {
+	now := time.Now()
+	c.Purpose = purpose
+	c.IssuedAt = now.Unix()
+	c.ExpirationTime = now.Add(ttl).Unix()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).
+		SignedString(deriveKey(a.jwtSignKey, purpose))
+	if err != nil {
+		a.logger.Error("auth: signToken",
+			slog.String("tag", "token"),
+			slog.String("purpose", purpose),
+			slog.Any("err", err))
+		return "", ErrUnknown
+	}
+
+	return token, nil
+}
+
+// parseToken parses and validates a token signed by signToken for purpose
+func (a *Auth) parseToken(purpose, token string) (TokenClaims, error) {
+	var c TokenClaims
+	t, err := jwt.ParseWithClaims(token, &c, func(token *jwt.Token) (interface{}, error) {
+		return deriveKey(a.jwtSignKey, purpose), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+
+	if err != nil || !t.Valid || c.Purpose != purpose {
+		return c, ErrInvalidToken
+	}
+
+	return c, nil
+}
@@ -7,11 +7,13 @@ import (
 	"log/slog"
 	"time"
 
+	"github.com/yaitoo/auth/masker"
 	"github.com/yaitoo/sqle"
 	"github.com/yaitoo/sqle/shardid"
 )
 
 // QueryUsers queries the users from the database based on the provided conditions and returns a limited result.
+// Use WhereUserField to match the fields that may be encrypted.
 // It takes a context, a where clause builder, and a limit as input parameters.
 // The function returns a LimitResult of User objects and an error if any.
 func (a *Auth) QueryUsers(ctx context.Context, where *sqle.WhereBuilder, limit int) ([]User, error) {
@@ -28,6 +30,15 @@ func (a *Auth) QueryUsers(ctx context.Context, where *sqle.WhereBuilder, limit i
 		return nil, ErrBadDatabase
 	}
 
+	// the data key of each user is read once, and it is used by all fields of user
+	ctx = withUserKeyCache(ctx)
+	for i, it := range items {
+		err = a.decryptUser(ctx, a.db.On(it.ID), &items[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return items, nil
 }
 
@@ -79,7 +90,8 @@ func (a *Auth) GetUserByEmail(ctx context.Context, email string) (User, error) {
 		return u, ErrBadDatabase
 	}
 
-	err = a.db.On(userID).
+	dbUser := a.db.On(userID)
+	err = dbUser.
 		QueryRowBuilder(ctx, a.createBuilder().
 			Select("<prefix>user").
 			Where("id = {id}").Param("id", userID)).
@@ -104,7 +116,7 @@ func (a *Auth) GetUserByEmail(ctx context.Context, email string) (User, error) {
 		return u, ErrBadDatabase
 	}
 
-	return u, nil
+	return u, a.decryptUser(ctx, dbUser, &u)
 }
 
 func (a *Auth) GetUserByMobile(ctx context.Context, mobile string) (User, error) {
@@ -137,7 +149,8 @@ func (a *Auth) GetUserByMobile(ctx context.Context, mobile string) (User, error)
 		return u, ErrBadDatabase
 	}
 
-	err = a.db.On(userID).
+	dbUser := a.db.On(userID)
+	err = dbUser.
 		QueryRowBuilder(ctx, a.createBuilder().
 			Select("<prefix>user").
 			Where("id = {id}").Param("id", userID)).
@@ -163,7 +176,7 @@ func (a *Auth) GetUserByMobile(ctx context.Context, mobile string) (User, error)
 		return u, ErrBadDatabase
 	}
 
-	return u, nil
+	return u, a.decryptUser(ctx, dbUser, &u)
 }
 
 // GetUsersByRole get users by role id
@@ -348,6 +361,13 @@ func (a *Auth) CreateUser(ctx context.Context, status UserStatus, email, mobile,
 		return u, ErrBadDatabase
 	}
 
+	a.audit(ctx, AuditUserCreate, AuditTagUser, map[string]any{
+		"user_id": id.Int64,
+		"status":  status,
+		"email":   masker.Email(email),
+		"mobile":  masker.Mobile(mobile),
+	})
+
 	return u, nil
 }
 
@@ -356,122 +376,170 @@ func (a *Auth) CreateUser(ctx context.Context, status UserStatus, email, mobile,
 // If an error occurs during the update, it logs the error and returns ErrBadDatabase.
 func (a *Auth) UpdateUser(ctx context.Context, id int64, status UserStatus, firstName, lastName string) error {
 	uid := shardid.Parse(id)
-	_, err := a.db.On(uid).ExecBuilder(ctx, a.createBuilder().
-		Update("<prefix>user").
-		Set("first_name", firstName).
-		Set("last_name", lastName).
-		Set("status", status).
-		Where("id = {id}").
-		Param("id", id))
+	err := a.db.On(uid).Transaction(ctx, &sql.TxOptions{}, func(ctx context.Context, tx *sqle.Tx) error {
+		names := map[UserField]string{
+			UserFirstName: firstName,
+			UserLastName:  lastName,
+		}
+
+		fields, err := a.encryptUserFields(ctx, tx, uid, names)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecBuilder(ctx, a.createBuilder().
+			Update("<prefix>user").
+			Set("first_name", fields[UserFirstName]).
+			Set("last_name", fields[UserLastName]).
+			Set("status", status).
+			Where("id = {id}").
+			Param("id", id))
+		if err != nil {
+			a.logger.Error("auth: UpdateUser",
+				slog.String("tag", "db"),
+				slog.Int64("id", id),
+				slog.Any("err", err))
+			return ErrBadDatabase
+		}
+
+		return a.indexUserFields(ctx, tx, uid, names)
+	})
 	if err != nil {
-		a.logger.Error("auth: UpdateUser",
-			slog.String("tag", "db"),
-			slog.Int64("id", id),
-			slog.Any("err", err))
-		return ErrBadDatabase
+		return err
+	}
+
+	md := map[string]any{
+		"user_id": id,
+		"status":  status,
+	}
+
+	// the encrypted names are not leaked by audit logs
+	if !a.isEncryptedField(UserFirstName) {
+		md["first_name"] = firstName
+	}
+	if !a.isEncryptedField(UserLastName) {
+		md["last_name"] = lastName
 	}
 
+	a.userChanged(id)
+	a.audit(ctx, AuditUserUpdate, AuditTagUser, md)
+
 	return nil
 }
 
-// DeleteUser deletes a user from the authentication system.
-// It takes a context and the ID of the user to be deleted as parameters.
-// It returns an error if any error occurs during the deletion process.
+// DeleteUser marks the user as pending deletion and revokes its sessions, nothing is freed until it is purged after the grace period.
+// The user can't sign in, and it can be restored by RestoreUser in the grace period.
 func (a *Auth) DeleteUser(ctx context.Context, id int64) error {
-	var (
-		hashEmail  string
-		hashMobile string
-	)
-
 	uid := shardid.Parse(id)
-	dbUser := a.db.On(uid)
-	pd, err := a.getProfileData(ctx, dbUser, id)
+
+	u, err := a.getUserByID(ctx, uid)
 	if err != nil {
 		return err
 	}
 
-	dtc := sqle.NewDTC(ctx, nil)
-	now := time.Now()
-
-	if pd.Email != "" {
-		hashEmail = generateHash(a.hash(), pd.Email, "")
-		dbEmail, err := a.db.OnDHT(hashEmail, a.dhtEmail)
-		if err != nil {
-			return err
-		}
-
-		dtc.Prepare(dbEmail, func(ctx context.Context, conn sqle.Connector) error {
-			return a.deleteEmail(ctx, conn, uid, hashEmail)
-		}, func(ctx context.Context, conn sqle.Connector) error {
-			err = a.createEmail(ctx, conn, uid, pd.Email, hashEmail, now)
-			if err != nil {
-				a.logger.Error("auth: DeleteUser:Email:Revert",
-					slog.String("tag", "db"),
-					slog.Int64("user_id", id),
-					slog.String("email", pd.Email),
-					slog.Any("err", err))
+	if u.DeletedAt.Valid {
+		return nil
+	}
 
-				return ErrBadDatabase
-			}
+	now := time.Now()
+	_, err = a.db.On(uid).
+		ExecBuilder(ctx, a.createBuilder().
+			Update("<prefix>user").
+			Set("deleted_at", now).
+			Set("updated_at", now).
+			Where("id = {id}").
+			Param("id", id))
 
-			return nil
+	if err != nil {
+		a.logger.Error("auth: DeleteUser",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", id),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
 
-		})
+	a.userChanged(id)
 
+	err = a.deleteUserToken(ctx, uid, "")
+	if err != nil {
+		return err
 	}
 
-	if pd.Mobile != "" {
-		hashMobile = generateHash(a.hash(), pd.Mobile, "")
-		dbMobile, err := a.db.OnDHT(hashMobile, a.dhtMobile)
-		if err != nil {
-			return err
-		}
+	a.audit(ctx, AuditUserDelete, AuditTagUser, map[string]any{
+		"user_id":  id,
+		"purge_at": now.Add(a.deleteGrace),
+	})
 
-		dtc.Prepare(dbMobile, func(ctx context.Context, conn sqle.Connector) error {
-			return a.deleteMobile(ctx, conn, uid, hashMobile)
-		}, func(ctx context.Context, conn sqle.Connector) error {
-			err := a.createMobile(ctx, conn, uid, pd.Mobile, hashMobile, now)
-			if err != nil {
-				a.logger.Error("auth: DeleteUser:Mobile:Revert",
-					slog.String("tag", "db"),
-					slog.Int64("user_id", id),
-					slog.String("mobile", pd.Mobile),
-					slog.Any("err", err))
+	return nil
+}
 
-				return ErrBadDatabase
-			}
+// RestoreUser cancels the deletion of user in the grace period. It returns ErrRestoreExpired if the grace period is over.
+func (a *Auth) RestoreUser(ctx context.Context, id int64) error {
+	uid := shardid.Parse(id)
 
-			return nil
-		})
+	u, err := a.getUserByID(ctx, uid)
+	if err != nil {
+		return err
 	}
 
-	dtc.Prepare(dbUser, func(ctx context.Context, conn sqle.Connector) error {
-		err := a.deleteUser(ctx, conn, uid)
-		if err != nil {
-			return err
-		}
+	if !u.DeletedAt.Valid {
+		return nil
+	}
 
-		return a.deleteProfile(ctx, conn, uid)
+	if time.Since(u.DeletedAt.Time()) > a.deleteGrace {
+		return ErrRestoreExpired
+	}
 
-	}, nil)
+	_, err = a.db.On(uid).
+		ExecBuilder(ctx, a.createBuilder().
+			Update("<prefix>user").
+			Set("deleted_at", nil).
+			Set("updated_at", time.Now()).
+			Where("id = {id}").
+			Param("id", id))
 
-	err = dtc.Commit()
 	if err != nil {
-		a.logger.Error("auth: DeleteUser:Commit",
+		a.logger.Error("auth: RestoreUser",
 			slog.String("tag", "db"),
 			slog.Int64("user_id", id),
 			slog.Any("err", err))
-
-		errs := dtc.Rollback()
-		if len(errs) > 0 {
-			a.logger.Error("auth: DeleteUser:Rollback",
-				slog.String("tag", "db"),
-				slog.Int64("user_id", id),
-				slog.Any("err", errs))
-		}
-
 		return ErrBadDatabase
 	}
 
+	a.userChanged(id)
+	a.audit(ctx, AuditUserRestore, AuditTagUser, map[string]any{"user_id": id})
+
 	return nil
 }
+
+// onUserChanged registers fn to be called after the status or deletion of user is changed, eg. to evict the cached user.
+// It returns a func that unregisters fn.
+func (a *Auth) onUserChanged(fn func(id int64)) func() {
+	a.userHooksMu.Lock()
+	defer a.userHooksMu.Unlock()
+
+	if a.userHooks == nil {
+		a.userHooks = make(map[int]func(id int64))
+	}
+
+	a.userHookID++
+	id := a.userHookID
+	a.userHooks[id] = fn
+
+	return func() {
+		a.userHooksMu.Lock()
+		defer a.userHooksMu.Unlock()
+
+		delete(a.userHooks, id)
+	}
+}
+
+// userChanged notifies the hooks that user is changed
+func (a *Auth) userChanged(id int64) {
+	a.userHooksMu.RLock()
+	defer a.userHooksMu.RUnlock()
+
+	for _, fn := range a.userHooks {
+		fn(id)
+	}
+}
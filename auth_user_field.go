@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+
+	"github.com/yaitoo/sqle"
+	"github.com/yaitoo/sqle/shardid"
+)
+
+// WhereUserField adds a condition to where for QueryUsers/QueryUsersCount that matches users whose field equals value, or starts with value if prefix is true.
+// An encrypted field is matched by its blind index or prefix tokens case-insensitively, and ErrFieldNotSearchable is returned if it isn't indexed for the match,
+// or the prefix is shorter than 3 characters.
+func (a *Auth) WhereUserField(where *sqle.WhereBuilder, field UserField, value string, prefix bool) error {
+	if !field.valid() {
+		return ErrBadRequest
+	}
+
+	name := "search_" + string(field)
+
+	if !a.isEncryptedField(field) {
+		if prefix {
+			where.And(string(field)+" LIKE {"+name+"}").Param(name, value+"%")
+		} else {
+			where.And(string(field)+" = {"+name+"}").Param(name, value)
+		}
+		return nil
+	}
+
+	index := a.encryptedFields[field]
+	if index == SearchNone || (prefix && index != SearchPrefix) {
+		return ErrFieldNotSearchable
+	}
+
+	// shorter prefixes are not indexed
+	if prefix && len([]rune(normalizeSearch(value))) < searchPrefixMin {
+		return ErrFieldNotSearchable
+	}
+
+	token := a.searchToken(field, value, prefix)
+	// longer prefixes are not indexed, so nothing is matched
+	if prefix && len([]rune(normalizeSearch(value))) > searchPrefixMax {
+		token = ""
+	}
+
+	where.And("id IN (SELECT user_id FROM <prefix>user_search WHERE field = {"+name+"_field} AND token = {"+name+"})").
+		Param(name+"_field", string(field)).
+		Param(name, token)
+
+	return nil
+}
+
+// isEncryptedField returns true if field is encrypted at rest
+func (a *Auth) isEncryptedField(field UserField) bool {
+	if a.aesKey == nil {
+		return false
+	}
+
+	_, ok := a.encryptedFields[field]
+	return ok
+}
+
+// encryptUserField encrypts value of field with the data key of user if field is encrypted
+func (a *Auth) encryptUserField(ctx context.Context, conn sqle.Connector, uid shardid.ID, field UserField, value string) (string, error) {
+	if value == "" || !a.isEncryptedField(field) {
+		return value, nil
+	}
+
+	return a.encryptUserData(ctx, conn, uid, value)
+}
+
+// encryptUserFields encrypts the values of fields that are encrypted, and returns them by field
+func (a *Auth) encryptUserFields(ctx context.Context, conn sqle.Connector, uid shardid.ID, values map[UserField]string) (map[UserField]string, error) {
+	fields := make(map[UserField]string, len(values))
+	for f, v := range values {
+		ct, err := a.encryptUserField(ctx, conn, uid, f, v)
+		if err != nil {
+			return nil, err
+		}
+		fields[f] = ct
+	}
+
+	return fields, nil
+}
+
+// decryptUser decrypts the encrypted fields of u. The fields that are written before they are encrypted are kept as they are.
+func (a *Auth) decryptUser(ctx context.Context, conn sqle.Connector, u *User) error {
+	if a.aesKey == nil {
+		return nil
+	}
+
+	for _, v := range []*string{&u.FirstName, &u.LastName, &u.Email, &u.Mobile} {
+		if !strings.HasPrefix(*v, userDataPrefix) {
+			continue
+		}
+
+		pt, err := a.decryptUserData(ctx, conn, u.ID, *v)
+		if err != nil {
+			return err
+		}
+		*v = pt
+	}
+
+	return nil
+}
+
+// indexUserField replaces the search tokens of field with the tokens of value if it is searchable
+func (a *Auth) indexUserField(ctx context.Context, conn sqle.Connector, uid shardid.ID, field UserField, value string) error {
+	if !a.isEncryptedField(field) || a.encryptedFields[field] == SearchNone {
+		return nil
+	}
+
+	_, err := conn.ExecBuilder(ctx, a.createBuilder().
+		Delete("<prefix>user_search").
+		Where("user_id = {user_id}").
+		And("field = {field}").
+		End().
+		Param("user_id", uid.Int64).
+		Param("field", string(field)))
+
+	if err != nil {
+		a.logger.Error("auth: indexUserField:Delete",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid.Int64),
+			slog.String("field", string(field)),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	for _, token := range a.searchTokens(field, value) {
+		_, err = conn.ExecBuilder(ctx, a.createBuilder().
+			Insert("<prefix>user_search").
+			Set("user_id", uid.Int64).
+			Set("field", string(field)).
+			Set("token", token).
+			End())
+
+		if err != nil {
+			a.logger.Error("auth: indexUserField",
+				slog.String("tag", "db"),
+				slog.Int64("user_id", uid.Int64),
+				slog.String("field", string(field)),
+				slog.Any("err", err))
+			return ErrBadDatabase
+		}
+	}
+
+	return nil
+}
+
+// indexUserFields replaces the search tokens of fields that are searchable
+func (a *Auth) indexUserFields(ctx context.Context, conn sqle.Connector, uid shardid.ID, values map[UserField]string) error {
+	for f, v := range values {
+		err := a.indexUserField(ctx, conn, uid, f, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// searchTokens returns the blind index of value, and its prefix tokens if field is searchable by prefix
+func (a *Auth) searchTokens(field UserField, value string) []string {
+	v := normalizeSearch(value)
+	if v == "" {
+		return nil
+	}
+
+	tokens := []string{a.searchToken(field, v, false)}
+
+	if a.encryptedFields[field] == SearchPrefix {
+		runes := []rune(v)
+		for i := searchPrefixMin; i <= len(runes) && i <= searchPrefixMax; i++ {
+			tokens = append(tokens, a.searchToken(field, string(runes[:i]), true))
+		}
+	}
+
+	return tokens
+}
+
+// searchToken returns a keyed hash of value, so the value can be matched without being stored in plaintext
+func (a *Auth) searchToken(field UserField, value string, prefix bool) string {
+	kind := "="
+	if prefix {
+		kind = "^"
+	}
+
+	m := hmac.New(sha256.New, deriveKey(a.aesKey, "user:search"))
+	m.Write([]byte(string(field) + ":" + kind + ":" + normalizeSearch(value))) // nolint: errcheck
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func normalizeSearch(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
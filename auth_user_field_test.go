@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/yaitoo/auth/masker"
+	"github.com/yaitoo/sqle"
+)
+
+func TestEncryptedFields(t *testing.T) {
+	au := createAuthTest("./tests_user_field.db")
+	ctx := context.Background()
+
+	// written before the fields are encrypted
+	legacy, err := au.CreateUser(ctx, UserStatusActivated, "legacy@mail.com", "", "abc123", "Lee", "Legacy")
+	require.NoError(t, err)
+
+	au.encryptedFields = map[UserField]SearchIndex{
+		UserFirstName: SearchPrefix,
+		UserLastName:  SearchExact,
+		UserEmail:     SearchExact,
+		UserMobile:    SearchNone,
+	}
+
+	u, err := au.CreateUser(ctx, UserStatusActivated, "alice@mail.com", "+6588880008", "abc123", "Alice", "Smith")
+	require.NoError(t, err)
+
+	_, err = au.CreateUser(ctx, UserStatusActivated, "bob@mail.com", "", "abc123", "Bob", "Smith")
+	require.NoError(t, err)
+
+	raw := func(id int64) User {
+		var it User
+		err := au.db.QueryRowContext(ctx, "SELECT first_name, last_name, email, mobile FROM test_user WHERE id = ?", id).
+			Scan(&it.FirstName, &it.LastName, &it.Email, &it.Mobile)
+		require.NoError(t, err)
+		return it
+	}
+
+	query := func(field UserField, value string, prefix bool) []string {
+		where := sqle.NewWhere()
+		require.NoError(t, au.WhereUserField(where, field, value, prefix))
+
+		items, err := au.QueryUsers(ctx, where, 10)
+		require.NoError(t, err)
+
+		var names []string
+		for _, it := range items {
+			names = append(names, it.FirstName)
+		}
+		return names
+	}
+
+	t.Run("encrypted_at_rest", func(t *testing.T) {
+		it := raw(u.ID.Int64)
+		for _, v := range []string{it.FirstName, it.LastName, it.Email, it.Mobile} {
+			require.True(t, strings.HasPrefix(v, userDataPrefix))
+		}
+		require.NotContains(t, it.FirstName, "Alice")
+
+		nu, err := au.GetUserByEmail(ctx, "alice@mail.com")
+		require.NoError(t, err)
+		require.Equal(t, "Alice", nu.FirstName)
+		require.Equal(t, "Smith", nu.LastName)
+		require.Equal(t, masker.Email("alice@mail.com"), nu.Email)
+		require.Equal(t, masker.Mobile("+6588880008"), nu.Mobile)
+
+		// the rows written before are still readable
+		it = raw(legacy.ID.Int64)
+		require.Equal(t, "Lee", it.FirstName)
+
+		nu, err = au.getUserByID(ctx, legacy.ID)
+		require.NoError(t, err)
+		require.Equal(t, "Lee", nu.FirstName)
+	})
+
+	t.Run("search", func(t *testing.T) {
+		require.Equal(t, []string{"Alice"}, query(UserFirstName, "alice", false))
+		require.Equal(t, []string{"Alice"}, query(UserFirstName, "Ali", true))
+		require.Empty(t, query(UserFirstName, "Ali", false))
+		require.ElementsMatch(t, []string{"Alice", "Bob"}, query(UserLastName, "SMITH", false))
+		require.Equal(t, []string{"Bob"}, query(UserEmail, "bob@mail.com", false))
+
+		where := sqle.NewWhere()
+		require.ErrorIs(t, au.WhereUserField(where, UserLastName, "Sm", true), ErrFieldNotSearchable)
+		// short prefixes are shared by too many names
+		require.ErrorIs(t, au.WhereUserField(where, UserFirstName, "Al", true), ErrFieldNotSearchable)
+		require.ErrorIs(t, au.WhereUserField(where, UserMobile, "+6588880008", false), ErrFieldNotSearchable)
+		require.ErrorIs(t, au.WhereUserField(where, UserField("passwd"), "x", false), ErrBadRequest)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		require.NoError(t, au.UpdateUser(ctx, u.ID.Int64, UserStatusActivated, "Alicia", "Jones"))
+		require.NoError(t, au.UpdateProfile(ctx, u.ID.Int64, "alicia@mail.com", "+6588880008"))
+
+		require.Empty(t, query(UserFirstName, "alice", false))
+		require.Equal(t, []string{"Alicia"}, query(UserFirstName, "alic", true))
+		require.Equal(t, []string{"Alicia"}, query(UserLastName, "jones", false))
+		require.Equal(t, []string{"Alicia"}, query(UserEmail, "alicia@mail.com", false))
+		require.Empty(t, query(UserEmail, "alice@mail.com", false))
+
+		require.True(t, strings.HasPrefix(raw(u.ID.Int64).FirstName, userDataPrefix))
+	})
+
+	t.Run("key_cache", func(t *testing.T) {
+		ks := &countingKeyStore{KeyStore: NewDBKeyStore(au.db, au.prefix)}
+		au.keyStore = ks
+		defer func() {
+			au.keyStore = nil
+		}()
+
+		items, err := au.QueryUsers(ctx, sqle.NewWhere(), 10)
+		require.NoError(t, err)
+		require.Len(t, items, 3)
+
+		// the data key of each encrypted user is read once for all fields
+		require.Equal(t, 2, ks.gets)
+	})
+
+	t.Run("plaintext", func(t *testing.T) {
+		au.encryptedFields = nil
+
+		require.Equal(t, []string{"Lee"}, query(UserFirstName, "Le", true))
+		require.Equal(t, []string{"Lee"}, query(UserLastName, "Legacy", false))
+	})
+
+	t.Run("reseal_legacy", func(t *testing.T) {
+		au.encryptedFields = map[UserField]SearchIndex{
+			UserFirstName: SearchPrefix,
+			UserLastName:  SearchExact,
+			UserEmail:     SearchExact,
+			UserMobile:    SearchNone,
+		}
+
+		// the rows written before aren't indexed
+		require.Empty(t, query(UserFirstName, "Lee", false))
+
+		n, err := au.ResealUserData(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+
+		it := raw(legacy.ID.Int64)
+		require.True(t, strings.HasPrefix(it.FirstName, userDataPrefix))
+		require.True(t, strings.HasPrefix(it.Email, userDataPrefix))
+
+		require.Equal(t, []string{"Lee"}, query(UserFirstName, "Lee", false))
+		require.Equal(t, []string{"Lee"}, query(UserFirstName, "lee", true))
+		require.Equal(t, []string{"Lee"}, query(UserLastName, "legacy", false))
+		require.Equal(t, []string{"Lee"}, query(UserEmail, "legacy@mail.com", false))
+
+		n, err = au.ResealUserData(ctx)
+		require.NoError(t, err)
+		require.Zero(t, n)
+	})
+}
+
+// countingKeyStore counts the data keys that are read
+type countingKeyStore struct {
+	KeyStore
+	gets int
+}
+
+func (s *countingKeyStore) Get(ctx context.Context, userID int64) (string, error) {
+	s.gets++
+	return s.KeyStore.Get(ctx, userID)
+}
@@ -0,0 +1,260 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/yaitoo/sqle"
+	"github.com/yaitoo/sqle/shardid"
+)
+
+const purgeBatchSize = 100
+
+// userTables the per-user tables on user's shard that are purged with user. The data key in user_key or the key store is destroyed too.
+// Without a KeyStore the data keys are in user_key on the same shard as the encrypted data, so a backup holds both and stays readable.
+// The encrypted data in backups is only shredded if the keys are kept in a KeyStore that isn't backed up with the database.
+var userTables = []string{
+	"user_key",
+	"user_token",
+	"login_code",
+	"login_log",
+	"login_openid",
+	"user_device",
+	"user_geo",
+	"user_last",
+	"user_passkey",
+	"user_mfa",
+	"user_search",
+}
+
+// PurgeDeletedUsers hard deletes the users whose grace period of deletion is over, and returns how many users are purged.
+// It should be run periodically, see RunPurgeJob. Audit logs are kept, so the audit chain is not broken.
+func (a *Auth) PurgeDeletedUsers(ctx context.Context) (int, error) {
+	n := 0
+	for {
+		b := a.createBuilder().
+			Select("<prefix>user", "id").
+			Where("deleted_at IS NOT NULL").
+			And("deleted_at <= {before}").
+			End().
+			SQL(" ORDER BY id").
+			Param("before", time.Now().Add(-a.deleteGrace))
+
+		items, err := sqle.NewQuery[User](a.db).QueryLimit(ctx, b, func(i, j User) bool {
+			return i.ID.Int64 < j.ID.Int64
+		}, purgeBatchSize)
+
+		if err != nil {
+			a.logger.Error("auth: PurgeDeletedUsers",
+				slog.String("tag", "db"),
+				slog.Any("err", err))
+			return n, ErrBadDatabase
+		}
+
+		for _, it := range items {
+			err = a.purgeUser(ctx, it.ID.Int64)
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+
+		if len(items) < purgeBatchSize {
+			return n, nil
+		}
+	}
+}
+
+// RunPurgeJob runs PurgeDeletedUsers every interval until ctx is done, errors are logged only.
+func (a *Auth) RunPurgeJob(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			a.PurgeDeletedUsers(ctx) // nolint: errcheck
+		}
+	}
+}
+
+// purgeUser removes user from roles, and deletes the user, profile, email, mobile and per-user tables across shards
+func (a *Auth) purgeUser(ctx context.Context, id int64) error {
+	var (
+		hashEmail  string
+		hashMobile string
+	)
+
+	uid := shardid.Parse(id)
+	dbUser := a.db.On(uid)
+	pd, err := a.getProfileData(ctx, dbUser, id)
+	if errors.Is(err, ErrUserDataShredded) {
+		// the key was destroyed by an earlier purge, the email/mobile can't be resolved anymore, so only the rows of user are deleted
+		a.logger.Warn("auth: purgeUser",
+			slog.String("tag", "crypto"),
+			slog.Int64("user_id", id),
+			slog.Any("err", err))
+	} else if err != nil && !errors.Is(err, ErrProfileNotFound) {
+		return err
+	}
+
+	err = a.purgeRoleUser(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	dtc := sqle.NewDTC(ctx, nil)
+	now := time.Now()
+
+	if pd.Email != "" {
+		hashEmail = generateHash(a.hash(), pd.Email, "")
+		dbEmail, err := a.db.OnDHT(hashEmail, a.dhtEmail)
+		if err != nil {
+			return err
+		}
+
+		dtc.Prepare(dbEmail, func(ctx context.Context, conn sqle.Connector) error {
+			return a.deleteEmail(ctx, conn, uid, hashEmail)
+		}, func(ctx context.Context, conn sqle.Connector) error {
+			err = a.createEmail(ctx, conn, uid, pd.Email, hashEmail, now)
+			if err != nil {
+				a.logger.Error("auth: purgeUser:Email:Revert",
+					slog.String("tag", "db"),
+					slog.Int64("user_id", id),
+					slog.String("email", pd.Email),
+					slog.Any("err", err))
+
+				return ErrBadDatabase
+			}
+
+			return nil
+
+		})
+
+	}
+
+	if pd.Mobile != "" {
+		hashMobile = generateHash(a.hash(), pd.Mobile, "")
+		dbMobile, err := a.db.OnDHT(hashMobile, a.dhtMobile)
+		if err != nil {
+			return err
+		}
+
+		dtc.Prepare(dbMobile, func(ctx context.Context, conn sqle.Connector) error {
+			return a.deleteMobile(ctx, conn, uid, hashMobile)
+		}, func(ctx context.Context, conn sqle.Connector) error {
+			err := a.createMobile(ctx, conn, uid, pd.Mobile, hashMobile, now)
+			if err != nil {
+				a.logger.Error("auth: purgeUser:Mobile:Revert",
+					slog.String("tag", "db"),
+					slog.Int64("user_id", id),
+					slog.String("mobile", pd.Mobile),
+					slog.Any("err", err))
+
+				return ErrBadDatabase
+			}
+
+			return nil
+		})
+	}
+
+	dtc.Prepare(dbUser, func(ctx context.Context, conn sqle.Connector) error {
+		for _, t := range userTables {
+			_, err := conn.ExecBuilder(ctx, a.createBuilder().
+				Delete("<prefix>"+t).
+				Where("user_id = {user_id}").
+				Param("user_id", id))
+			if err != nil {
+				a.logger.Error("auth: purgeUser",
+					slog.String("tag", "db"),
+					slog.String("table", t),
+					slog.Int64("user_id", id),
+					slog.Any("err", err))
+				return ErrBadDatabase
+			}
+		}
+
+		err := a.deleteUser(ctx, conn, uid)
+		if err != nil {
+			return err
+		}
+
+		return a.deleteProfile(ctx, conn, uid)
+
+	}, nil)
+
+	err = dtc.Commit()
+	if err != nil {
+		a.logger.Error("auth: purgeUser:Commit",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", id),
+			slog.Any("err", err))
+
+		errs := dtc.Rollback()
+		if len(errs) > 0 {
+			a.logger.Error("auth: purgeUser:Rollback",
+				slog.String("tag", "db"),
+				slog.Int64("user_id", id),
+				slog.Any("err", errs))
+		}
+
+		return ErrBadDatabase
+	}
+
+	// the data key is destroyed after the rows are deleted, so a failed purge can still resolve the email/mobile when it's retried
+	if a.keyStore != nil {
+		err = a.keyStore.Delete(ctx, id)
+		if err != nil {
+			a.logger.Error("auth: purgeUser:KeyStore",
+				slog.String("tag", "keystore"),
+				slog.Int64("user_id", id),
+				slog.Any("err", err))
+			return ErrUnknown
+		}
+	}
+
+	a.userChanged(id)
+	a.audit(ctx, AuditUserPurge, AuditTagUser, map[string]any{"user_id": id})
+
+	return nil
+}
+
+// purgeRoleUser removes user from all roles, and updates their user counts
+func (a *Auth) purgeRoleUser(ctx context.Context, id int64) error {
+	var items []Role
+	rows, err := a.db.
+		QueryBuilder(ctx, a.createBuilder().
+			Select("<prefix>role_user", "role_id as id").
+			Where("user_id = {user_id}").
+			Param("user_id", id))
+
+	if err != nil {
+		a.logger.Error("auth: purgeRoleUser",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", id),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	err = rows.Bind(&items)
+	if err != nil {
+		a.logger.Error("auth: purgeRoleUser:Bind",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", id),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	for _, it := range items {
+		err = a.DeleteRoleUsers(ctx, it.ID, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
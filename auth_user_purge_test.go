@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteUser(t *testing.T) {
+	au := createAuthTest("./tests_delete_user.db")
+	ctx := context.Background()
+
+	u, err := au.CreateUser(ctx, UserStatusActivated, "delete@mail.com", "+6588880004", "abc123", "", "")
+	require.NoError(t, err)
+
+	rid, err := au.CreateRole(ctx, "deleted")
+	require.NoError(t, err)
+	require.NoError(t, au.AddRoleUsers(ctx, rid, u.ID.Int64))
+
+	s, err := au.Login(ctx, "delete@mail.com", "abc123", LoginOption{UserIP: "1.1.1.1", UserAgent: "laptop"})
+	require.NoError(t, err)
+
+	count := func(table string) int {
+		var n int
+		err := au.db.QueryRowContext(ctx, "SELECT count(*) FROM test_"+table+" WHERE user_id = ?", u.ID.Int64).Scan(&n)
+		require.NoError(t, err)
+		return n
+	}
+
+	t.Run("soft", func(t *testing.T) {
+		require.NoError(t, au.DeleteUser(ctx, u.ID.Int64))
+
+		_, err := au.Login(ctx, "delete@mail.com", "abc123", LoginOption{})
+		require.ErrorIs(t, err, ErrUserDeleted)
+
+		_, err = au.RefreshSession(ctx, s.RefreshToken, ClientInfo{})
+		require.ErrorIs(t, err, ErrInvalidToken)
+
+		// nothing is freed
+		_, err = au.CreateUser(ctx, UserStatusActivated, "delete@mail.com", "", "abc123", "", "")
+		require.Error(t, err)
+		require.Equal(t, 1, count("role_user"))
+
+		// nothing is purged in the grace period
+		n, err := au.PurgeDeletedUsers(ctx)
+		require.NoError(t, err)
+		require.Zero(t, n)
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		require.NoError(t, au.RestoreUser(ctx, u.ID.Int64))
+
+		_, err := au.Login(ctx, "delete@mail.com", "abc123", LoginOption{})
+		require.NoError(t, err)
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		require.NoError(t, au.DeleteUser(ctx, u.ID.Int64))
+
+		_, err := au.db.ExecContext(ctx, "UPDATE test_user SET deleted_at = ? WHERE id = ?", time.Now().Add(-au.deleteGrace-time.Minute), u.ID.Int64)
+		require.NoError(t, err)
+
+		require.ErrorIs(t, au.RestoreUser(ctx, u.ID.Int64), ErrRestoreExpired)
+
+		require.NotZero(t, count("login_log"))
+		require.NotZero(t, count("user_device"))
+
+		n, err := au.PurgeDeletedUsers(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+
+		for _, table := range append(userTables, "role_user", "user_profile") {
+			require.Zero(t, count(table), table)
+		}
+
+		_, err = au.GetUserByEmail(ctx, "delete@mail.com")
+		require.ErrorIs(t, err, ErrEmailNotFound)
+		_, err = au.GetUserByMobile(ctx, "+6588880004")
+		require.ErrorIs(t, err, ErrMobileNotFound)
+
+		roles, err := au.QueryRoles(ctx, nil)
+		require.NoError(t, err)
+		require.Zero(t, roles[0].UserCount)
+
+		require.ErrorIs(t, au.RestoreUser(ctx, u.ID.Int64), ErrUserNotFound)
+
+		// the email is free
+		_, err = au.CreateUser(ctx, UserStatusActivated, "delete@mail.com", "", "abc123", "", "")
+		require.NoError(t, err)
+	})
+}
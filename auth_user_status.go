@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"time"
+)
+
+// checkUserStatus returns ErrUserDeleted if user is pending deletion, ErrUserDeactivated if user can do nothing,
+// or ErrUserExpired if user is still waiting after the waiting period. Suspended users pass, they can sign in and view only.
+func (a *Auth) checkUserStatus(u User) error {
+	if u.DeletedAt.Valid {
+		return ErrUserDeleted
+	}
+
+	switch u.Status {
+	case UserStatusDeactivated:
+		return ErrUserDeactivated
+	case UserStatusWaiting:
+		if a.waitingPeriod > 0 && time.Since(u.CreatedAt) > a.waitingPeriod {
+			return ErrUserExpired
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserStatus(t *testing.T) {
+	au := createAuthTest("./tests_user_status.db")
+	ctx := context.Background()
+
+	t.Run("deactivated", func(t *testing.T) {
+		u, err := au.CreateUser(ctx, UserStatusActivated, "deactivated@mail.com", "", "abc123", "", "")
+		require.NoError(t, err)
+
+		s, err := au.Login(ctx, "deactivated@mail.com", "abc123", LoginOption{})
+		require.NoError(t, err)
+
+		err = au.UpdateUser(ctx, u.ID.Int64, UserStatusDeactivated, "", "")
+		require.NoError(t, err)
+
+		_, err = au.Login(ctx, "deactivated@mail.com", "abc123", LoginOption{})
+		require.ErrorIs(t, err, ErrUserDeactivated)
+
+		_, err = au.RefreshSession(ctx, s.RefreshToken, ClientInfo{})
+		require.ErrorIs(t, err, ErrUserDeactivated)
+
+		logs, _, err := au.QueryLoginLogs(ctx, u.ID.Int64, time.Time{}, time.Now().Add(time.Second), 0)
+		require.NoError(t, err)
+		require.False(t, bool(logs[0].IsOK))
+	})
+
+	t.Run("suspended", func(t *testing.T) {
+		u, err := au.CreateUser(ctx, UserStatusSuspended, "suspended@mail.com", "", "abc123", "", "")
+		require.NoError(t, err)
+
+		s, err := au.Login(ctx, "suspended@mail.com", "abc123", LoginOption{})
+		require.NoError(t, err)
+
+		_, err = au.RefreshSession(ctx, s.RefreshToken, ClientInfo{})
+		require.NoError(t, err)
+
+		rid, err := au.CreateRole(ctx, "suspended")
+		require.NoError(t, err)
+		require.NoError(t, au.RegisterPerm(ctx, "order:read", "order"))
+		require.NoError(t, au.RegisterPerm(ctx, "order:write", "order"))
+		require.NoError(t, au.GrantPerms(ctx, rid, "order:read", "order:write"))
+		require.NoError(t, au.AddRoleUsers(ctx, rid, u.ID.Int64))
+
+		h := NewHandler(au)
+		ok := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			WriteEmpty(w)
+		}
+
+		call := func(fn func(http.ResponseWriter, *http.Request), method string) int {
+			r := httptest.NewRequest(method, "/orders", nil)
+			r.Header.Set("X-Access-Token", s.AccessToken)
+			w := httptest.NewRecorder()
+			fn(w, r)
+			return w.Code
+		}
+
+		require.Equal(t, http.StatusOK, call(h.WithAuthn(ctx, ok), http.MethodGet))
+		require.Equal(t, http.StatusForbidden, call(h.WithAuthn(ctx, ok), http.MethodPost))
+
+		require.Equal(t, http.StatusOK, call(h.WithAuthz(ctx, "order", "order:write", ok), http.MethodGet))
+		require.Equal(t, http.StatusForbidden, call(h.WithAuthz(ctx, "order", "order:write", ok), http.MethodPost))
+		// read perms
+		require.Equal(t, http.StatusOK, call(h.WithAuthz(ctx, "order", "order:read", ok), http.MethodPost))
+
+		// activated, the cached user is evicted
+		require.NoError(t, au.UpdateUser(ctx, u.ID.Int64, UserStatusActivated, "", ""))
+		require.Equal(t, http.StatusOK, call(h.WithAuthz(ctx, "order", "order:write", ok), http.MethodPost))
+
+		// deactivated
+		require.NoError(t, au.UpdateUser(ctx, u.ID.Int64, UserStatusDeactivated, "", ""))
+		r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		r.Header.Set("X-Access-Token", s.AccessToken)
+		w := httptest.NewRecorder()
+		h.WithAuthn(ctx, ok)(w, r)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+
+		var jr JsonResult[any]
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&jr))
+		require.Equal(t, ErrUserDeactivated.Error(), jr.ErrorCode)
+
+		// closed handlers aren't notified anymore
+		hooks := func() int {
+			au.userHooksMu.RLock()
+			defer au.userHooksMu.RUnlock()
+			return len(au.userHooks)
+		}
+		n := hooks()
+		h.Close()
+		require.Equal(t, n-1, hooks())
+	})
+
+	t.Run("waiting", func(t *testing.T) {
+		_, err := au.CreateUser(ctx, UserStatusWaiting, "waiting@mail.com", "", "abc123", "", "")
+		require.NoError(t, err)
+
+		s, err := au.Login(ctx, "waiting@mail.com", "abc123", LoginOption{})
+		require.NoError(t, err)
+
+		au.waitingPeriod = time.Millisecond
+		defer func() {
+			au.waitingPeriod = 0
+		}()
+		time.Sleep(10 * time.Millisecond)
+
+		_, err = au.Login(ctx, "waiting@mail.com", "abc123", LoginOption{})
+		require.ErrorIs(t, err, ErrUserExpired)
+
+		_, err = au.RefreshSession(ctx, s.RefreshToken, ClientInfo{})
+		require.ErrorIs(t, err, ErrUserExpired)
+
+		h := NewHandler(au)
+		r := httptest.NewRequest(http.MethodGet, "/me", nil)
+		r.Header.Set("X-Access-Token", s.AccessToken)
+		w := httptest.NewRecorder()
+		h.WithAuthn(ctx, func(ctx context.Context, w http.ResponseWriter, r *http.Request) {})(w, r)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
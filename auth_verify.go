@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/yaitoo/sqle"
+	"github.com/yaitoo/sqle/shardid"
+)
+
+const (
+	tokenVerifyEmail = "verify:email"
+
+	verifyEmailParam = "token"
+)
+
+// SendEmailVerification create a signed and expiring link to verify user's email. The token is added to redirectURL as `token` parameter,
+// and redirectURL must be on one of magic link origins. The link is sent by email if notifier is set.
+func (a *Auth) SendEmailVerification(ctx context.Context, email, redirectURL string, option LoginOption) (string, error) {
+	link, err := a.parseLink(redirectURL)
+	if err != nil {
+		return "", err
+	}
+
+	err = a.throttleSend(ctx, email, option.UserIP)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := a.getUserIDByEmail(ctx, email)
+	if err != nil {
+		return "", err
+	}
+
+	// the token is bound to the email, so it can't verify another email after the email is changed
+	token, err := a.signToken(tokenVerifyEmail, TokenClaims{ID: id.Int64, Data: hashToken(email)}, a.verificationTTL)
+	if err != nil {
+		return "", err
+	}
+
+	q := link.Query()
+	q.Set(verifyEmailParam, token)
+	link.RawQuery = q.Encode()
+
+	err = a.notify(ctx, ChannelEmail, MessageVerification, option.Locale, email, MessageData{Link: link.String(), ExpiresIn: a.verificationTTL})
+	if err != nil {
+		return "", err
+	}
+
+	return link.String(), nil
+}
+
+// VerifyEmail marks user's email as verified with the token of a verification link.
+func (a *Auth) VerifyEmail(ctx context.Context, token string) error {
+	c, err := a.parseToken(tokenVerifyEmail, token)
+	if err != nil {
+		return err
+	}
+
+	uid := shardid.Parse(c.ID)
+
+	pd, err := a.GetProfileData(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+
+	if pd.Email == "" || hashToken(pd.Email) != c.Data {
+		return ErrInvalidToken
+	}
+
+	return a.setVerified(ctx, uid, "email")
+}
+
+// SendMobileVerification create a code to verify user's mobile. The code is sent by SMS if notifier is set.
+func (a *Auth) SendMobileVerification(ctx context.Context, mobile string, option LoginOption) (string, error) {
+	err := a.throttleSend(ctx, mobile, option.UserIP)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := a.getUserIDByMobile(ctx, mobile)
+	if err != nil {
+		return "", err
+	}
+
+	// the code can only verify the mobile, and a newer verification code supersedes it
+	code := randStr(a.loginCodeSize, dicNumber)
+	err = a.saveLoginCode(ctx, id, codeVerifyMobile, code, option.UserIP, a.verificationTTL)
+	if err != nil {
+		return "", err
+	}
+
+	err = a.notify(ctx, ChannelSMS, MessageVerification, option.Locale, mobile, MessageData{Code: code, ExpiresIn: a.verificationTTL})
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// VerifyMobile marks user's mobile as verified with the code sent by SendMobileVerification.
+func (a *Auth) VerifyMobile(ctx context.Context, mobile, code string) error {
+	id, err := a.getUserIDByMobile(ctx, mobile)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.consumeLoginCode(ctx, id, codeVerifyMobile, code)
+	if err != nil {
+		return err
+	}
+
+	return a.setVerified(ctx, id, "mobile")
+}
+
+// setVerified marks the email or mobile of user as verified, and activates the waiting user if it is enabled
+func (a *Auth) setVerified(ctx context.Context, uid shardid.ID, field string) error {
+	u, err := a.getUserByID(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	activate := a.verifyActivate && u.Status == UserStatusWaiting
+
+	_, err = a.db.On(uid).
+		ExecBuilder(ctx, a.createBuilder().
+			Update("<prefix>user").
+			Set(field+"_verified", true).
+			Set(field+"_verified_at", now).
+			If(activate).Set("status", UserStatusActivated).
+			Set("updated_at", now).
+			Where("id = {id}").
+			Param("id", uid.Int64))
+
+	if err != nil {
+		a.logger.Error("auth: setVerified",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", uid.Int64),
+			slog.String("field", field),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	md := map[string]any{
+		"user_id": uid.Int64,
+		"field":   field,
+	}
+	if activate {
+		md["status"] = UserStatusActivated
+	}
+
+	a.userChanged(uid.Int64)
+
+	a.audit(ctx, AuditUserVerify, AuditTagUser, md)
+
+	return nil
+}
+
+// resetVerified clears the verified flags of the email/mobile that is changed, so the new address has to be verified again
+func (a *Auth) resetVerified(ctx context.Context, conn sqle.Connector, id int64, email, mobile bool) error {
+	if !email && !mobile {
+		return nil
+	}
+
+	_, err := conn.ExecBuilder(ctx, a.createBuilder().
+		Update("<prefix>user").
+		If(email).Set("email_verified", false).
+		If(email).Set("email_verified_at", nil).
+		If(mobile).Set("mobile_verified", false).
+		If(mobile).Set("mobile_verified_at", nil).
+		Where("id = {id}").
+		Param("id", id))
+
+	if err != nil {
+		a.logger.Error("auth: resetVerified",
+			slog.String("tag", "db"),
+			slog.Int64("user_id", id),
+			slog.Any("err", err))
+		return ErrBadDatabase
+	}
+
+	return nil
+}
+
+// checkEmailVerified returns ErrEmailNotVerified if unverified emails can't be used for login
+func (a *Auth) checkEmailVerified(u User) error {
+	if a.requireVerified && !bool(u.EmailVerified) {
+		return ErrEmailNotVerified
+	}
+
+	return nil
+}
+
+// checkMobileVerified returns ErrMobileNotVerified if unverified mobiles can't be used for login
+func (a *Auth) checkMobileVerified(u User) error {
+	if a.requireVerified && !bool(u.MobileVerified) {
+		return ErrMobileNotVerified
+	}
+
+	return nil
+}
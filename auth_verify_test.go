@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerification(t *testing.T) {
+	au := createAuthTest("./tests_verify.db")
+	au.magicLinkOrigins = []string{"https://app.test"}
+	ctx := context.Background()
+
+	t.Run("email", func(t *testing.T) {
+		u, err := au.CreateUser(ctx, UserStatusWaiting, "verify@mail.com", "", "abc123", "", "")
+		require.NoError(t, err)
+
+		_, err = au.SendEmailVerification(ctx, "verify@mail.com", "https://evil.test/verify", LoginOption{})
+		require.ErrorIs(t, err, ErrBadRequest)
+
+		link, err := au.SendEmailVerification(ctx, "verify@mail.com", "https://app.test/verify", LoginOption{})
+		require.NoError(t, err)
+
+		l, err := url.Parse(link)
+		require.NoError(t, err)
+		token := l.Query().Get("token")
+
+		require.ErrorIs(t, au.VerifyEmail(ctx, token+"x"), ErrInvalidToken)
+		require.NoError(t, au.VerifyEmail(ctx, token))
+
+		u, err = au.getUserByID(ctx, u.ID)
+		require.NoError(t, err)
+		require.True(t, bool(u.EmailVerified))
+		require.True(t, u.EmailVerifiedAt.Valid)
+		// waiting users are not activated by default
+		require.Equal(t, UserStatusWaiting, u.Status)
+	})
+
+	t.Run("mobile", func(t *testing.T) {
+		au.verifyActivate = true
+		defer func() {
+			au.verifyActivate = false
+		}()
+
+		u, err := au.CreateUser(ctx, UserStatusWaiting, "", "+6588880001", "abc123", "", "")
+		require.NoError(t, err)
+
+		code, err := au.SendMobileVerification(ctx, "+6588880001", LoginOption{})
+		require.NoError(t, err)
+
+		require.ErrorIs(t, au.VerifyMobile(ctx, "+6588880001", "wrong"), ErrCodeNotMatched)
+		require.NoError(t, au.VerifyMobile(ctx, "+6588880001", code))
+
+		// single-use
+		require.ErrorIs(t, au.VerifyMobile(ctx, "+6588880001", code), ErrCodeNotMatched)
+
+		u, err = au.getUserByID(ctx, u.ID)
+		require.NoError(t, err)
+		require.True(t, bool(u.MobileVerified))
+		require.Equal(t, UserStatusActivated, u.Status)
+	})
+
+	t.Run("require_verified", func(t *testing.T) {
+		au.requireVerified = true
+		defer func() {
+			au.requireVerified = false
+		}()
+
+		_, err := au.CreateUser(ctx, UserStatusActivated, "unverified@mail.com", "", "abc123", "", "")
+		require.NoError(t, err)
+
+		_, err = au.Login(ctx, "unverified@mail.com", "abc123", LoginOption{})
+		require.ErrorIs(t, err, ErrEmailNotVerified)
+
+		// wrong password doesn't tell if the email is verified
+		_, err = au.Login(ctx, "unverified@mail.com", "wrong", LoginOption{})
+		require.ErrorIs(t, err, ErrPasswdNotMatched)
+
+		_, err = au.Login(ctx, "verify@mail.com", "abc123", LoginOption{})
+		require.NoError(t, err)
+
+		_, err = au.LoginMobile(ctx, "+6588880001", "abc123", LoginOption{})
+		require.NoError(t, err)
+
+		_, err = au.Login(ctx, "new@mail.com", "abc123", LoginOption{CreateIfNotExists: true})
+		require.ErrorIs(t, err, ErrEmailNotVerified)
+
+		_, err = au.GetUserByEmail(ctx, "new@mail.com")
+		require.NoError(t, err)
+	})
+
+	t.Run("reset_on_change", func(t *testing.T) {
+		u, err := au.GetUserByEmail(ctx, "verify@mail.com")
+		require.NoError(t, err)
+		require.True(t, bool(u.EmailVerified))
+
+		require.NoError(t, au.UpdateProfile(ctx, u.ID.Int64, "changed@mail.com", ""))
+
+		u, err = au.getUserByID(ctx, u.ID)
+		require.NoError(t, err)
+		require.False(t, bool(u.EmailVerified))
+		require.False(t, u.EmailVerifiedAt.Valid)
+
+		au.requireVerified = true
+		defer func() {
+			au.requireVerified = false
+		}()
+
+		_, err = au.Login(ctx, "changed@mail.com", "abc123", LoginOption{})
+		require.ErrorIs(t, err, ErrEmailNotVerified)
+
+		// the mobile is kept verified if it isn't changed
+		v, err := au.GetUserByMobile(ctx, "+6588880001")
+		require.NoError(t, err)
+		require.NoError(t, au.UpdateProfile(ctx, v.ID.Int64, "mobile@mail.com", "+6588880001"))
+
+		v, err = au.getUserByID(ctx, v.ID)
+		require.NoError(t, err)
+		require.True(t, bool(v.MobileVerified))
+	})
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"math/bits"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPowDifficulty = 18
+	defaultPowTTL        = 5 * time.Minute
+)
+
+// ChallengeVerifier verifies the response of a challenge(eg: CAPTCHA token, proof-of-work solution) that is solved by the client
+type ChallengeVerifier interface {
+	// Verify returns ErrChallengeFailed if the response isn't valid for userIP
+	Verify(ctx context.Context, response, userIP string) error
+}
+
+// ChallengeIssuer is implemented by verifiers that issue challenges themselves, eg: ProofOfWork.
+// A third-party CAPTCHA doesn't need it.
+type ChallengeIssuer interface {
+	// Issue returns a challenge for userIP that is sent to the client
+	Issue(ctx context.Context, userIP string) (any, error)
+}
+
+// PowChallenge a proof-of-work challenge. The client should find a nonce that sha256(challenge + ":" + nonce) starts with Difficulty zero bits,
+// and responds with challenge + ":" + nonce.
+type PowChallenge struct {
+	Challenge  string    `json:"challenge"`
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// ProofOfWork a self-hosted ChallengeVerifier. Challenges are signed by key and bound to user ip, so they can be verified without storage.
+// Solved challenges are counted in a CounterStore until they expire, so they can't be replayed. The store should be shared by all instances
+// in a cluster, eg: the CounterStore of Auth that is backed by redis.
+type ProofOfWork struct {
+	key        []byte
+	difficulty int
+	ttl        time.Duration
+
+	used CounterStore
+}
+
+// PowOption configures ProofOfWork
+type PowOption func(p *ProofOfWork)
+
+// WithPowCounterStore set the store that solved challenges are counted in, an in-memory store is used if it is not set
+func WithPowCounterStore(s CounterStore) PowOption {
+	return func(p *ProofOfWork) {
+		p.used = s
+	}
+}
+
+// NewProofOfWork create a proof-of-work verifier. It uses 18 zero bits and 5 minutes if difficulty or ttl is not positive.
+func NewProofOfWork(key string, difficulty int, ttl time.Duration, options ...PowOption) *ProofOfWork {
+	if difficulty <= 0 {
+		difficulty = defaultPowDifficulty
+	}
+
+	if ttl <= 0 {
+		ttl = defaultPowTTL
+	}
+
+	p := &ProofOfWork{
+		key:        deriveKey([]byte(key), "challenge:pow"),
+		difficulty: difficulty,
+		ttl:        ttl,
+	}
+
+	for _, o := range options {
+		o(p)
+	}
+
+	if p.used == nil {
+		p.used = NewMemoryCounterStore()
+	}
+
+	return p
+}
+
+// Issue returns a PowChallenge for userIP
+func (p *ProofOfWork) Issue(_ context.Context, userIP string) (any, error) {
+	expiresAt := time.Now().Add(p.ttl).Truncate(time.Second)
+
+	buf := make([]byte, 24, 24+sha256.Size)
+	binary.BigEndian.PutUint64(buf, uint64(expiresAt.Unix()))
+	if _, err := rand.Read(buf[8:]); err != nil {
+		return nil, err
+	}
+
+	buf = append(buf, p.sign(buf, userIP)...)
+
+	return PowChallenge{
+		Challenge:  base64.RawURLEncoding.EncodeToString(buf),
+		Difficulty: p.difficulty,
+		ExpiresAt:  expiresAt,
+	}, nil
+}
+
+// Verify checks the signature, expiry and work of the response, and marks it as used
+func (p *ProofOfWork) Verify(ctx context.Context, response, userIP string) error {
+	challenge, nonce, ok := strings.Cut(response, ":")
+	if !ok || nonce == "" {
+		return ErrChallengeFailed
+	}
+
+	buf, err := base64.RawURLEncoding.DecodeString(challenge)
+	if err != nil || len(buf) != 24+sha256.Size {
+		return ErrChallengeFailed
+	}
+
+	if subtle.ConstantTimeCompare(buf[24:], p.sign(buf[:24], userIP)) != 1 {
+		return ErrChallengeFailed
+	}
+
+	expiresAt := int64(binary.BigEndian.Uint64(buf))
+	if time.Now().Unix() > expiresAt {
+		return ErrChallengeFailed
+	}
+
+	if leadingZeroBits(sha256.Sum256([]byte(response))) < p.difficulty {
+		return ErrChallengeFailed
+	}
+
+	// the challenge is counted until the second it expires, only the first solution passes
+	n, _, err := p.used.Incr(ctx, "pow:"+challenge, time.Until(time.Unix(expiresAt, 0))+time.Second)
+	if err != nil {
+		return err
+	}
+
+	if n > 1 {
+		return ErrChallengeFailed
+	}
+
+	return nil
+}
+
+func (p *ProofOfWork) sign(buf []byte, userIP string) []byte {
+	m := hmac.New(sha256.New, p.key)
+	m.Write(buf)            // nolint: errcheck
+	m.Write([]byte(userIP)) // nolint: errcheck
+	return m.Sum(nil)
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+
+	return n
+}
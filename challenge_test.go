@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// solvePow finds the nonce of a proof-of-work challenge like a client
+func solvePow(c PowChallenge) string {
+	for i := 0; ; i++ {
+		response := c.Challenge + ":" + strconv.Itoa(i)
+		if leadingZeroBits(sha256.Sum256([]byte(response))) >= c.Difficulty {
+			return response
+		}
+	}
+}
+
+func TestProofOfWork(t *testing.T) {
+	ctx := context.Background()
+	p := NewProofOfWork("pow", 8, time.Minute)
+
+	issue := func(ip string) PowChallenge {
+		c, err := p.Issue(ctx, ip)
+		require.NoError(t, err)
+		return c.(PowChallenge)
+	}
+
+	t.Run("solved", func(t *testing.T) {
+		c := issue("1.1.1.1")
+		require.Equal(t, 8, c.Difficulty)
+
+		response := solvePow(c)
+		require.NoError(t, p.Verify(ctx, response, "1.1.1.1"))
+
+		// replay
+		require.ErrorIs(t, p.Verify(ctx, response, "1.1.1.1"), ErrChallengeFailed)
+	})
+
+	t.Run("replay_on_other_instance", func(t *testing.T) {
+		store := NewMemoryCounterStore()
+		p1 := NewProofOfWork("pow", 8, time.Minute, WithPowCounterStore(store))
+		p2 := NewProofOfWork("pow", 8, time.Minute, WithPowCounterStore(store))
+
+		c, err := p1.Issue(ctx, "1.1.1.1")
+		require.NoError(t, err)
+		response := solvePow(c.(PowChallenge))
+
+		require.NoError(t, p1.Verify(ctx, response, "1.1.1.1"))
+		require.ErrorIs(t, p2.Verify(ctx, response, "1.1.1.1"), ErrChallengeFailed)
+	})
+
+	t.Run("other_ip", func(t *testing.T) {
+		response := solvePow(issue("1.1.1.1"))
+		require.ErrorIs(t, p.Verify(ctx, response, "2.2.2.2"), ErrChallengeFailed)
+	})
+
+	t.Run("unsolved", func(t *testing.T) {
+		c := issue("1.1.1.1")
+		for i := 0; ; i++ {
+			response := c.Challenge + ":" + strconv.Itoa(i)
+			if leadingZeroBits(sha256.Sum256([]byte(response))) < c.Difficulty {
+				require.ErrorIs(t, p.Verify(ctx, response, "1.1.1.1"), ErrChallengeFailed)
+				break
+			}
+		}
+
+		require.ErrorIs(t, p.Verify(ctx, c.Challenge, "1.1.1.1"), ErrChallengeFailed)
+		require.ErrorIs(t, p.Verify(ctx, "bad:1", "1.1.1.1"), ErrChallengeFailed)
+	})
+
+	t.Run("forged", func(t *testing.T) {
+		other := NewProofOfWork("other", 8, time.Minute)
+		c, err := other.Issue(ctx, "1.1.1.1")
+		require.NoError(t, err)
+
+		require.ErrorIs(t, p.Verify(ctx, solvePow(c.(PowChallenge)), "1.1.1.1"), ErrChallengeFailed)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		expired := NewProofOfWork("pow", 8, time.Nanosecond)
+		c, err := expired.Issue(ctx, "1.1.1.1")
+		require.NoError(t, err)
+
+		time.Sleep(1100 * time.Millisecond)
+		require.ErrorIs(t, expired.Verify(ctx, solvePow(c.(PowChallenge)), "1.1.1.1"), ErrChallengeFailed)
+	})
+}
+
+func TestChallenge(t *testing.T) {
+	au := createAuthTest("./tests_challenge.db")
+	ctx := context.Background()
+
+	_, err := au.CreateUser(ctx, UserStatusActivated, "challenge@mail.com", "", "abc123", "", "")
+	require.NoError(t, err)
+
+	pow := NewProofOfWork("pow", 8, time.Minute)
+
+	newChallenge := func(h *Handler, ip string) string {
+		r := httptest.NewRequest(http.MethodPost, "/challenge", nil)
+		r.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		h.CreateChallenge(ctx, w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var result JsonResult[PowChallenge]
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		return solvePow(result.Result)
+	}
+
+	login := func(h *Handler, ip, passwd, response string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"email":"challenge@mail.com","passwd":"`+passwd+`"}`))
+		r.RemoteAddr = ip + ":1234"
+		if response != "" {
+			r.Header.Set(ChallengeHeader, response)
+		}
+		w := httptest.NewRecorder()
+		h.Login(ctx, w, r)
+		return w
+	}
+
+	t.Run("always", func(t *testing.T) {
+		h := NewHandler(au, WithChallenge(ChallengePolicy{Verifier: pow}))
+
+		w := login(h, "1.1.1.1", "abc123", "")
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), ErrChallengeRequired.Error())
+
+		w = login(h, "1.1.1.1", "abc123", "bad:1")
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), ErrChallengeFailed.Error())
+
+		require.Equal(t, http.StatusOK, login(h, "1.1.1.1", "abc123", newChallenge(h, "1.1.1.1")).Code)
+
+		// registration endpoints
+		called := false
+		next := h.RequireChallenge(ctx, func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			called = true
+			WriteEmpty(w)
+		})
+
+		r := httptest.NewRequest(http.MethodPost, "/register", nil)
+		r.RemoteAddr = "1.1.1.1:1234"
+		w = httptest.NewRecorder()
+		next(w, r)
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.False(t, called)
+
+		r.Header.Set(ChallengeHeader, newChallenge(h, "1.1.1.1"))
+		w = httptest.NewRecorder()
+		next(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.True(t, called)
+	})
+
+	t.Run("after_fails", func(t *testing.T) {
+		h := NewHandler(au, WithChallenge(ChallengePolicy{Verifier: pow, AfterFails: 2}))
+
+		require.Equal(t, http.StatusOK, login(h, "2.2.2.2", "abc123", "").Code)
+		require.Equal(t, http.StatusBadRequest, login(h, "2.2.2.2", "wrong", "").Code)
+		require.Equal(t, http.StatusBadRequest, login(h, "2.2.2.2", "wrong", "").Code)
+
+		require.Equal(t, http.StatusForbidden, login(h, "2.2.2.2", "abc123", "").Code)
+		require.Equal(t, http.StatusOK, login(h, "2.2.2.2", "abc123", newChallenge(h, "2.2.2.2")).Code)
+
+		// other ip
+		require.Equal(t, http.StatusOK, login(h, "3.3.3.3", "abc123", "").Code)
+	})
+
+	t.Run("no_issuer", func(t *testing.T) {
+		h := NewHandler(au)
+
+		r := httptest.NewRequest(http.MethodPost, "/challenge", nil)
+		w := httptest.NewRecorder()
+		h.CreateChallenge(ctx, w, r)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
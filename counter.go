@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CounterStore counts events in fixed windows, it is shared by throttles and can be backed by redis or db in a cluster
+type CounterStore interface {
+	// Incr increases the counter of key in current window, and returns the count and when the window resets
+	Incr(ctx context.Context, key string, window time.Duration) (int64, time.Time, error)
+}
+
+type counter struct {
+	count   int64
+	resetAt time.Time
+}
+
+// MemoryCounterStore an in-memory CounterStore for single instance
+type MemoryCounterStore struct {
+	mu       sync.Mutex
+	counters map[string]*counter
+	swept    time.Time
+}
+
+// NewMemoryCounterStore create an in-memory counter store
+func NewMemoryCounterStore() *MemoryCounterStore {
+	return &MemoryCounterStore{
+		counters: make(map[string]*counter),
+	}
+}
+
+// Incr increases the counter of key in current window
+func (s *MemoryCounterStore) Incr(_ context.Context, key string, window time.Duration) (int64, time.Time, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	c, ok := s.counters[key]
+	if !ok || !now.Before(c.resetAt) {
+		c = &counter{resetAt: now.Add(window)}
+		s.counters[key] = c
+	}
+
+	c.count++
+
+	return c.count, c.resetAt, nil
+}
+
+// sweep removes expired counters at most once a minute
+func (s *MemoryCounterStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < time.Minute {
+		return
+	}
+
+	s.swept = now
+	for k, c := range s.counters {
+		if !now.Before(c.resetAt) {
+			delete(s.counters, k)
+		}
+	}
+}
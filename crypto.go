@@ -3,6 +3,7 @@ package auth
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
@@ -26,6 +27,12 @@ func randStr(n int, dic string) string {
 	return string(bytes)
 }
 
+func randBytes(n int) []byte {
+	var bytes = make([]byte, n)
+	rand.Read(bytes) // nolint: errcheck
+	return bytes
+}
+
 func generateHash(h hash.Hash, source, salt string) string {
 	h.Write([]byte(source)) // nolint: errcheck
 	if salt != "" {
@@ -48,6 +55,13 @@ func getJWTKey(key string) []byte {
 	return sha256.New().Sum([]byte(key))
 }
 
+// deriveKey derives a sub key for purpose from key
+func deriveKey(key []byte, purpose string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(purpose)) // nolint: errcheck
+	return m.Sum(nil)
+}
+
 func getAESKey(key string) []byte {
 	return sha256.New().Sum([]byte(key))[0:32]
 }
@@ -0,0 +1,21 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/yaitoo/sqle"
+	"github.com/yaitoo/sqle/shardid"
+)
+
+// Device a device that user signed in from, it is identified by its user agent
+type Device struct {
+	UserID     shardid.ID `json:"userID,omitempty"`
+	UA         string     `json:"ua,omitempty"`
+	Name       string     `json:"name,omitempty"`
+	LoginTimes int        `json:"loginTimes,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt,omitempty"`
+	UpdatedAt  time.Time  `json:"updatedAt,omitempty"`
+
+	TrustHash    sqle.String `json:"-"`
+	TrustedUntil sqle.Time   `json:"trustedUntil,omitempty"`
+}
@@ -13,12 +13,56 @@ var (
 	ErrUserNotFound    = errors.New("auth: user_not_found")
 	ErrProfileNotFound = errors.New("auth: profile_not_found")
 	ErrPermNotFound    = errors.New("auth: perm_not_found")
+	ErrPasskeyNotFound = errors.New("auth: passkey_not_found")
+
+	ErrMFADeviceNotFound = errors.New("auth: mfa_device_not_found")
+	ErrDeviceNotFound    = errors.New("auth: device_not_found")
 
 	ErrPasswdNotMatched = errors.New("auth: passwd_not_matched")
+	ErrAccountLocked    = errors.New("auth: account_locked")
 
 	ErrOtpNotMatched  = errors.New("auth: otp_not_matched")
 	ErrCodeNotMatched = errors.New("auth: code_not_matched")
 
+	ErrCodeExpired          = errors.New("auth: code_expired")
+	ErrCodeAttemptsExceeded = errors.New("auth: code_attempts_exceeded")
+
+	ErrPasskeyNotMatched = errors.New("auth: passkey_not_matched")
+	ErrPasskeyExists     = errors.New("auth: passkey_exists")
+	ErrEmailExists       = errors.New("auth: email_exists")
+	ErrMobileExists      = errors.New("auth: mobile_exists")
+	ErrRevertPending     = errors.New("auth: revert_pending")
+	ErrPasskeyCloned     = errors.New("auth: passkey_cloned")
+
+	ErrMFARequired = errors.New("auth: mfa_required")
+
+	ErrTemplateNotFound = errors.New("auth: template_not_found")
+	ErrNotifyFailed     = errors.New("auth: notify_failed")
+
+	ErrTooManyRequests = errors.New("auth: too_many_requests")
+
+	ErrAuditChainBroken = errors.New("auth: audit_chain_broken")
+
+	ErrInvalidMMDB = errors.New("auth: invalid_mmdb")
+	ErrInvalidIP   = errors.New("auth: invalid_ip")
+
+	ErrLoginBlocked = errors.New("auth: login_blocked")
+
+	ErrUserDeactivated  = errors.New("auth: user_deactivated")
+	ErrUserSuspended    = errors.New("auth: user_suspended")
+	ErrUserExpired      = errors.New("auth: user_expired")
+	ErrUserDeleted      = errors.New("auth: user_deleted")
+	ErrRestoreExpired   = errors.New("auth: restore_expired")
+	ErrUserDataShredded = errors.New("auth: user_data_shredded")
+
+	ErrEmailNotVerified  = errors.New("auth: email_not_verified")
+	ErrMobileNotVerified = errors.New("auth: mobile_not_verified")
+
+	ErrChallengeRequired = errors.New("auth: challenge_required")
+	ErrChallengeFailed   = errors.New("auth: challenge_failed")
+
+	ErrFieldNotSearchable = errors.New("auth: field_not_searchable")
+
 	ErrInvalidToken = errors.New("auth: invalid_token")
 	ErrBadRequest   = errors.New("auth: bad_request")
 )
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+// EventType the type of a security event
+type EventType string
+
+const (
+	EventLoginSucceeded EventType = "login.succeeded"
+	EventLoginFailed    EventType = "login.failed"
+	EventAccountLocked  EventType = "account.locked"
+	EventRoleChanged    EventType = "role.changed"
+	EventTokenReused    EventType = "token.reused"
+	EventNewDevice      EventType = "device.new"
+)
+
+// severity returns the CEF severity(0-10) of the event type
+func (t EventType) severity() int {
+	switch t {
+	case EventLoginSucceeded:
+		return 3
+	case EventLoginFailed:
+		return 5
+	case EventNewDevice:
+		return 4
+	case EventRoleChanged:
+		return 6
+	case EventAccountLocked:
+		return 7
+	case EventTokenReused:
+		return 9
+	default:
+		return 5
+	}
+}
+
+// Event a security event for SIEM pipelines
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// UserID the user that the event is about, ActorID the user who made the change
+	UserID  int64       `json:"userID,omitempty"`
+	ActorID int64       `json:"actorID,omitempty"`
+	Method  LoginMethod `json:"method,omitempty"`
+	IP      string      `json:"ip,omitempty"`
+	UA      string      `json:"ua,omitempty"`
+
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// EventSink receives security events. Emit is called on Login's path, so a slow sink should be wrapped by EventDispatcher.
+type EventSink interface {
+	Emit(ctx context.Context, e Event) error
+}
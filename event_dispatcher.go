@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// EventDispatcher buffers events and emits them to sink in background, so a slow sink never blocks the caller.
+// Events are dropped if the buffer is full.
+type EventDispatcher struct {
+	sink   EventSink
+	logger *slog.Logger
+
+	mu      sync.RWMutex
+	closed  bool
+	events  chan Event
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+// NewEventDispatcher create a dispatcher with a buffer of size events
+func NewEventDispatcher(sink EventSink, size int) *EventDispatcher {
+	d := &EventDispatcher{
+		sink:   sink,
+		logger: slog.Default(),
+		events: make(chan Event, size),
+		done:   make(chan struct{}),
+	}
+
+	go d.run()
+
+	return d
+}
+
+// Emit queues the event without blocking
+func (d *EventDispatcher) Emit(_ context.Context, e Event) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		d.dropped.Add(1)
+		return nil
+	}
+
+	select {
+	case d.events <- e:
+	default:
+		d.dropped.Add(1)
+	}
+
+	return nil
+}
+
+// Dropped returns how many events are dropped
+func (d *EventDispatcher) Dropped() int64 {
+	return d.dropped.Load()
+}
+
+// Close flushes queued events, and closes the sink if it is an io.Closer
+func (d *EventDispatcher) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	close(d.events)
+	d.mu.Unlock()
+
+	<-d.done
+
+	if c, ok := d.sink.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+func (d *EventDispatcher) run() {
+	defer close(d.done)
+
+	for e := range d.events {
+		if err := d.sink.Emit(context.Background(), e); err != nil {
+			d.logger.Error("auth: EventDispatcher",
+				slog.String("tag", "event"),
+				slog.String("type", string(e.Type)),
+				slog.Any("err", err))
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"os"
+)
+
+// FileSink appends events to a file
+type FileSink struct {
+	*WriterSink
+	f *os.File
+}
+
+// NewFileSink create a sink that appends events formatted by format to file
+func NewFileSink(file string, format EventFormatter) (*FileSink, error) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{WriterSink: NewWriterSink(f, format), f: f}, nil
+}
+
+// NewJSONFileSink create a sink that appends events to file as json lines
+func NewJSONFileSink(file string) (*FileSink, error) {
+	return NewFileSink(file, FormatJSON)
+}
+
+// NewCEFFileSink create a sink that appends events to file in CEF
+func NewCEFFileSink(file, vendor, product, version string) (*FileSink, error) {
+	return NewFileSink(file, CEFFormatter(vendor, product, version))
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.f.Close()
+}
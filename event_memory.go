@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryEventSink keeps events in memory, it is designed for tests
+type MemoryEventSink struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// NewMemoryEventSink create an in-memory event sink
+func NewMemoryEventSink() *MemoryEventSink {
+	return &MemoryEventSink{}
+}
+
+// Emit keeps the event
+func (s *MemoryEventSink) Emit(_ context.Context, e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, e)
+	return nil
+}
+
+// Events returns all emitted events
+func (s *MemoryEventSink) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Event(nil), s.events...)
+}
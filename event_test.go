@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type slowEventSink struct {
+	release chan struct{}
+	*MemoryEventSink
+}
+
+func (s *slowEventSink) Emit(ctx context.Context, e Event) error {
+	<-s.release
+	return s.MemoryEventSink.Emit(ctx, e)
+}
+
+func TestEventFormatter(t *testing.T) {
+	e := Event{
+		Type:     EventLoginFailed,
+		Time:     time.UnixMilli(1700000000000),
+		UserID:   1,
+		Method:   LoginMethodPasswd,
+		IP:       "1.1.1.1",
+		UA:       "a=b\nc",
+		Metadata: map[string]any{"fails": 3},
+	}
+
+	t.Run("json", func(t *testing.T) {
+		buf, err := FormatJSON(e)
+		require.NoError(t, err)
+
+		var v Event
+		require.NoError(t, json.Unmarshal(buf, &v))
+		require.Equal(t, e.Type, v.Type)
+		require.Equal(t, e.UA, v.UA)
+	})
+
+	t.Run("cef", func(t *testing.T) {
+		buf, err := CEFFormatter("Yai|too", "Auth", "1.0")(e)
+		require.NoError(t, err)
+		require.Equal(t, `CEF:0|Yai\|too|Auth|1.0|login.failed|login.failed|5|rt=1700000000000 suid=1 duid=1 src=1.1.1.1 requestClientApplication=a\=b\nc cs1Label=method cs1=E msg={"fails":3}`, string(buf))
+
+		// the actor is the source, user is the destination
+		a := e
+		a.ActorID = 2
+		buf, err = CEFFormatter("Yai|too", "Auth", "1.0")(a)
+		require.NoError(t, err)
+		require.Contains(t, string(buf), "suid=2 duid=1 ")
+	})
+
+	t.Run("writer", func(t *testing.T) {
+		var b bytes.Buffer
+		s := NewWriterSink(&b, FormatJSON)
+		require.NoError(t, s.Emit(context.Background(), e))
+		require.NoError(t, s.Emit(context.Background(), e))
+		require.Equal(t, 2, strings.Count(b.String(), "\n"))
+	})
+
+	t.Run("file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "events.cef")
+		s, err := NewCEFFileSink(file, "Yaitoo", "Auth", "1.0")
+		require.NoError(t, err)
+		require.NoError(t, s.Emit(context.Background(), e))
+		require.NoError(t, s.Close())
+
+		buf, err := os.ReadFile(file)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(buf), "CEF:0|Yaitoo|Auth|1.0|login.failed"))
+	})
+}
+
+func TestEventDispatcher(t *testing.T) {
+	sink := &slowEventSink{release: make(chan struct{}), MemoryEventSink: NewMemoryEventSink()}
+	d := NewEventDispatcher(sink, 2)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			d.Emit(context.Background(), Event{Type: EventLoginSucceeded}) // nolint: errcheck
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.Fail(t, "Emit is blocked by slow sink")
+	}
+
+	// 1 event is being emitted, 2 are buffered, others are dropped
+	require.GreaterOrEqual(t, d.Dropped(), int64(7))
+
+	close(sink.release)
+	require.NoError(t, d.Close())
+	require.Len(t, sink.Events(), 10-int(d.Dropped()))
+}
+
+func TestAuthEvents(t *testing.T) {
+	au := createAuthTest("./tests_auth_events.db")
+	au.lockout = Lockout{Threshold: 2, Duration: time.Minute}
+	sink := NewMemoryEventSink()
+	au.eventSink = sink
+	ctx := context.Background()
+
+	u, err := au.CreateUser(ctx, UserStatusActivated, "event@mail.com", "", "abc123", "", "")
+	require.NoError(t, err)
+
+	s, err := au.Login(ctx, "event@mail.com", "abc123", LoginOption{UserIP: "1.1.1.1"})
+	require.NoError(t, err)
+
+	_, err = au.RefreshSession(ctx, s.RefreshToken, ClientInfo{})
+	require.NoError(t, err)
+	_, err = au.RefreshSession(ctx, s.RefreshToken, ClientInfo{UserIP: "6.6.6.6"})
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	for i := 0; i < 2; i++ {
+		_, err = au.Login(ctx, "event@mail.com", "wrong", LoginOption{})
+		require.ErrorIs(t, err, ErrPasswdNotMatched)
+	}
+
+	rid, err := au.CreateRole(ctx, "event")
+	require.NoError(t, err)
+	require.NoError(t, au.AddRoleUsers(WithActor(ctx, 1), rid, u.ID.Int64))
+
+	var types []EventType
+	for _, e := range sink.Events() {
+		types = append(types, e.Type)
+	}
+
+	require.Equal(t, []EventType{
+		EventLoginSucceeded,
+		EventNewDevice,
+		EventTokenReused,
+		EventLoginFailed,
+		EventLoginFailed,
+		EventAccountLocked,
+		EventRoleChanged,
+	}, types)
+
+	events := sink.Events()
+	require.Equal(t, "6.6.6.6", events[2].IP)
+	require.Equal(t, u.ID.Int64, events[6].UserID)
+	require.EqualValues(t, 1, events[6].ActorID)
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// EventFormatter formats an event as a line
+type EventFormatter func(e Event) ([]byte, error)
+
+// FormatJSON formats event as a json line
+func FormatJSON(e Event) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+var (
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefValueEscaper  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+// CEFFormatter returns a formatter of ArcSight Common Event Format
+func CEFFormatter(vendor, product, version string) EventFormatter {
+	return func(e Event) ([]byte, error) {
+		var sb strings.Builder
+
+		sb.WriteString("CEF:0|")
+		for _, it := range []string{vendor, product, version, string(e.Type), string(e.Type)} {
+			sb.WriteString(cefHeaderEscaper.Replace(it))
+			sb.WriteString("|")
+		}
+		sb.WriteString(strconv.Itoa(e.Type.severity()))
+		sb.WriteString("|")
+
+		ext := []string{"rt=" + strconv.FormatInt(e.Time.UnixMilli(), 10)}
+		add := func(k, v string) {
+			if v != "" {
+				ext = append(ext, k+"="+cefValueEscaper.Replace(v))
+			}
+		}
+
+		// the actor is the source user, user acts on itself if there is no actor
+		actor := e.ActorID
+		if actor == 0 {
+			actor = e.UserID
+		}
+		if actor > 0 {
+			add("suid", strconv.FormatInt(actor, 10))
+		}
+		if e.UserID > 0 {
+			add("duid", strconv.FormatInt(e.UserID, 10))
+		}
+		add("src", e.IP)
+		add("requestClientApplication", e.UA)
+		if e.Method != "" {
+			add("cs1Label", "method")
+			add("cs1", string(e.Method))
+		}
+		if len(e.Metadata) > 0 {
+			buf, err := json.Marshal(e.Metadata)
+			if err != nil {
+				return nil, err
+			}
+			add("msg", string(buf))
+		}
+
+		sb.WriteString(strings.Join(ext, " "))
+
+		return []byte(sb.String()), nil
+	}
+}
+
+// WriterSink writes events line by line into an io.Writer
+type WriterSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format EventFormatter
+}
+
+// NewWriterSink create a sink that writes events formatted by format into w
+func NewWriterSink(w io.Writer, format EventFormatter) *WriterSink {
+	return &WriterSink{w: w, format: format}
+}
+
+// Emit writes the event as a line
+func (s *WriterSink) Emit(_ context.Context, e Event) error {
+	buf, err := s.format(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(append(buf, '\n'))
+	return err
+}
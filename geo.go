@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/yaitoo/sqle/shardid"
+)
+
+// Geo the location of an ip
+type Geo struct {
+	// Country ISO 3166-1 country code, eg: US
+	Country string `json:"country,omitempty"`
+	// Region ISO 3166-2 subdivision code, eg: CA
+	Region string `json:"region,omitempty"`
+
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+}
+
+// GeoResolver resolves the location of an ip. It returns an empty Geo if the ip is not found.
+type GeoResolver interface {
+	Resolve(ctx context.Context, ip string) (Geo, error)
+}
+
+// UserGeo an ip that user signed in from
+type UserGeo struct {
+	UserID     shardid.ID `json:"userID,omitempty"`
+	IP         string     `json:"ip,omitempty"`
+	Country    string     `json:"country,omitempty"`
+	Region     string     `json:"region,omitempty"`
+	LoginTimes int        `json:"loginTimes,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt,omitempty"`
+	UpdatedAt  time.Time  `json:"updatedAt,omitempty"`
+}
@@ -0,0 +1,343 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"net"
+	"os"
+)
+
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+const mmdbDataSeparator = 16
+
+// MMDBResolver resolves ip by a local MaxMind DB file, eg: GeoLite2-City.mmdb or GeoLite2-Country.mmdb
+type MMDBResolver struct {
+	buf []byte
+
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+
+	data []byte
+}
+
+// NewMMDBResolver loads the MaxMind DB file into memory
+func NewMMDBResolver(file string) (*MMDBResolver, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMMDBResolver(buf)
+}
+
+func newMMDBResolver(buf []byte) (*MMDBResolver, error) {
+	i := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, ErrInvalidMMDB
+	}
+
+	d := mmdbDecoder{buf: buf[i+len(mmdbMetadataMarker):]}
+	v, _, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+
+	md, ok := v.(map[string]any)
+	if !ok {
+		return nil, ErrInvalidMMDB
+	}
+
+	r := &MMDBResolver{
+		buf:        buf,
+		nodeCount:  mmdbUint(md["node_count"]),
+		recordSize: mmdbUint(md["record_size"]),
+		ipVersion:  mmdbUint(md["ip_version"]),
+	}
+
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, ErrInvalidMMDB
+	}
+
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+mmdbDataSeparator > uint(i) {
+		return nil, ErrInvalidMMDB
+	}
+
+	r.data = buf[treeSize+mmdbDataSeparator : i]
+
+	return r, nil
+}
+
+// Resolve looks up country, region(first subdivision) and location of ip
+func (r *MMDBResolver) Resolve(_ context.Context, ip string) (Geo, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return Geo{}, nil
+	}
+
+	key := addr.To4()
+	if key == nil {
+		if r.ipVersion == 4 {
+			return Geo{}, nil
+		}
+		key = addr.To16()
+	} else if r.ipVersion == 6 {
+		// ipv4 is stored in ::/96
+		key = append(make([]byte, 12), key...)
+	}
+
+	offset, err := r.lookup(key)
+	if err != nil || offset < 0 {
+		return Geo{}, err
+	}
+
+	d := mmdbDecoder{buf: r.data}
+	v, _, err := d.decode(uint(offset))
+	if err != nil {
+		return Geo{}, err
+	}
+
+	var g Geo
+	if m, ok := v.(map[string]any); ok {
+		if c, ok := m["country"].(map[string]any); ok {
+			g.Country, _ = c["iso_code"].(string)
+		}
+		if s, ok := m["subdivisions"].([]any); ok && len(s) > 0 {
+			if sd, ok := s[0].(map[string]any); ok {
+				g.Region, _ = sd["iso_code"].(string)
+			}
+		}
+		if l, ok := m["location"].(map[string]any); ok {
+			g.Latitude, _ = l["latitude"].(float64)
+			g.Longitude, _ = l["longitude"].(float64)
+		}
+	}
+
+	return g, nil
+}
+
+// lookup walks the search tree by bits of key, and returns the offset of its record in data section, or -1 if it is not found
+func (r *MMDBResolver) lookup(key []byte) (int, error) {
+	node := uint(0)
+	for i := 0; i < len(key)*8 && node < r.nodeCount; i++ {
+		bit := uint(key[i/8]>>(7-uint(i%8))) & 1
+		node = r.readRecord(node, bit)
+	}
+
+	switch {
+	case node == r.nodeCount:
+		return -1, nil
+	case node > r.nodeCount:
+		offset := node - r.nodeCount - mmdbDataSeparator
+		if offset >= uint(len(r.data)) {
+			return -1, ErrInvalidMMDB
+		}
+		return int(offset), nil
+	default:
+		return -1, ErrInvalidMMDB
+	}
+}
+
+func (r *MMDBResolver) readRecord(node, bit uint) uint {
+	b := r.buf[node*r.recordSize/4:]
+
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// mmdbDecoder decodes data section of MaxMind DB, see https://maxmind.github.io/MaxMind-DB/
+type mmdbDecoder struct {
+	buf []byte
+}
+
+const (
+	mmdbPointer   = 1
+	mmdbString    = 2
+	mmdbDouble    = 3
+	mmdbBytes     = 4
+	mmdbUint16    = 5
+	mmdbUint32    = 6
+	mmdbMap       = 7
+	mmdbInt32     = 8
+	mmdbUint64    = 9
+	mmdbUint128   = 10
+	mmdbArray     = 11
+	mmdbContainer = 12
+	mmdbEnd       = 13
+	mmdbBool      = 14
+	mmdbFloat     = 15
+)
+
+// decode returns the value at offset, and the offset after it
+func (d *mmdbDecoder) decode(offset uint) (any, uint, error) {
+	if offset >= uint(len(d.buf)) {
+		return nil, 0, ErrInvalidMMDB
+	}
+
+	ctrl := d.buf[offset]
+	offset++
+
+	typ := uint(ctrl >> 5)
+
+	if typ == mmdbPointer {
+		p, next, err := d.decodePointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := d.decode(p)
+		return v, next, err
+	}
+
+	if typ == 0 {
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, ErrInvalidMMDB
+		}
+		typ = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.buf)) {
+			return nil, 0, ErrInvalidMMDB
+		}
+		v := uint(0)
+		for _, b := range d.buf[offset : offset+n] {
+			v = v<<8 | uint(b)
+		}
+		offset += n
+
+		switch size {
+		case 29:
+			size = 29 + v
+		case 30:
+			size = 285 + v
+		default:
+			size = 65821 + v
+		}
+	}
+
+	switch typ {
+	case mmdbMap:
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			v, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			ks, ok := k.(string)
+			if !ok {
+				return nil, 0, ErrInvalidMMDB
+			}
+			m[ks] = v
+			offset = next
+		}
+		return m, offset, nil
+	case mmdbArray:
+		a := make([]any, 0, size)
+		for i := uint(0); i < size; i++ {
+			v, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			offset = next
+		}
+		return a, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	case mmdbContainer, mmdbEnd:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, ErrInvalidMMDB
+	}
+	b := d.buf[offset : offset+size]
+	offset += size
+
+	switch typ {
+	case mmdbString:
+		return string(b), offset, nil
+	case mmdbBytes:
+		return append([]byte(nil), b...), offset, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, ErrInvalidMMDB
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, ErrInvalidMMDB
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	case mmdbInt32:
+		var v int32
+		for _, c := range b {
+			v = v<<8 | int32(c)
+		}
+		return v, offset, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, offset, nil
+	case mmdbUint128:
+		return append([]byte(nil), b...), offset, nil
+	}
+
+	return nil, 0, ErrInvalidMMDB
+}
+
+// decodePointer returns the offset that pointer points to, and the offset after the pointer
+func (d *mmdbDecoder) decodePointer(ctrl byte, offset uint) (uint, uint, error) {
+	n := uint((ctrl>>3)&0x3) + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, ErrInvalidMMDB
+	}
+
+	b := d.buf[offset : offset+n]
+	v := uint(0)
+	if n < 4 {
+		v = uint(ctrl & 0x7)
+	}
+	for _, c := range b {
+		v = v<<8 | uint(c)
+	}
+
+	switch n {
+	case 2:
+		v += 2048
+	case 3:
+		v += 526336
+	}
+
+	return v, offset + n, nil
+}
+
+func mmdbUint(v any) uint {
+	if n, ok := v.(uint64); ok {
+		return uint(n)
+	}
+	return 0
+}
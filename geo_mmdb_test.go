@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// mmdbWriter writes a minimal MaxMind DB with 24-bit records for tests
+type mmdbWriter struct {
+	ipVersion int
+	nodes     [][2]int // -1: empty, >= 0: node, <-1: -(data index)-2
+	data      [][]byte
+}
+
+func newMMDBWriter(ipVersion int) *mmdbWriter {
+	return &mmdbWriter{ipVersion: ipVersion, nodes: [][2]int{{-1, -1}}}
+}
+
+func (w *mmdbWriter) insert(cidr string, v any) {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+
+	ip := n.IP.To4()
+	ones, _ := n.Mask.Size()
+	if w.ipVersion == 6 {
+		if ip == nil {
+			ip = n.IP.To16()
+		} else {
+			// ipv4 is stored in ::/96
+			ip = append(make([]byte, 12), ip...)
+			ones += 96
+		}
+	}
+
+	w.data = append(w.data, mmdbEncode(v))
+	ref := -(len(w.data) - 1) - 2
+
+	node := 0
+	for i := 0; i < ones; i++ {
+		bit := int(ip[i/8]>>(7-uint(i%8))) & 1
+		if i == ones-1 {
+			w.nodes[node][bit] = ref
+			break
+		}
+		if w.nodes[node][bit] < 0 {
+			w.nodes = append(w.nodes, [2]int{-1, -1})
+			w.nodes[node][bit] = len(w.nodes) - 1
+		}
+		node = w.nodes[node][bit]
+	}
+}
+
+func (w *mmdbWriter) bytes() []byte {
+	var data bytes.Buffer
+	offsets := make([]int, len(w.data))
+	for i, d := range w.data {
+		offsets[i] = data.Len()
+		data.Write(d)
+	}
+
+	n := len(w.nodes)
+	var buf bytes.Buffer
+	for _, node := range w.nodes {
+		for _, r := range node {
+			v := r
+			switch {
+			case r == -1:
+				v = n
+			case r < -1:
+				v = n + mmdbDataSeparator + offsets[-r-2]
+			}
+			buf.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+		}
+	}
+
+	buf.Write(make([]byte, mmdbDataSeparator))
+	buf.Write(data.Bytes())
+	buf.Write(mmdbMetadataMarker)
+	buf.Write(mmdbEncode(map[string]any{
+		"node_count":    uint32(n),
+		"record_size":   uint16(24),
+		"ip_version":    uint16(w.ipVersion),
+		"database_type": "Test",
+	}))
+
+	return buf.Bytes()
+}
+
+func mmdbEncode(v any) []byte {
+	var buf bytes.Buffer
+
+	ctrl := func(typ, size int) {
+		if typ > 7 {
+			buf.WriteByte(byte(size))
+			buf.WriteByte(byte(typ - 7))
+			return
+		}
+		buf.WriteByte(byte(typ<<5 | size))
+	}
+
+	switch t := v.(type) {
+	case string:
+		ctrl(mmdbString, len(t))
+		buf.WriteString(t)
+	case uint16:
+		ctrl(mmdbUint16, 2)
+		binary.Write(&buf, binary.BigEndian, t) // nolint: errcheck
+	case uint32:
+		ctrl(mmdbUint32, 4)
+		binary.Write(&buf, binary.BigEndian, t) // nolint: errcheck
+	case float64:
+		ctrl(mmdbDouble, 8)
+		binary.Write(&buf, binary.BigEndian, t) // nolint: errcheck
+	case []any:
+		ctrl(mmdbArray, len(t))
+		for _, it := range t {
+			buf.Write(mmdbEncode(it))
+		}
+	case map[string]any:
+		ctrl(mmdbMap, len(t))
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			buf.Write(mmdbEncode(k))
+			buf.Write(mmdbEncode(t[k]))
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func geoRecord(country, region string) map[string]any {
+	m := map[string]any{
+		"country": map[string]any{"iso_code": country, "names": map[string]any{"en": country}},
+	}
+	if region != "" {
+		m["subdivisions"] = []any{map[string]any{"iso_code": region}}
+	}
+	return m
+}
+
+func TestMMDBResolver(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("ipv4", func(t *testing.T) {
+		w := newMMDBWriter(4)
+		w.insert("1.1.1.0/24", geoRecord("AU", "NSW"))
+		w.insert("8.8.0.0/16", geoRecord("US", "CA"))
+		w.insert("9.0.0.0/8", geoRecord("FR", ""))
+		w.insert("5.5.5.0/24", map[string]any{
+			"country":  map[string]any{"iso_code": "GB"},
+			"location": map[string]any{"latitude": 51.51, "longitude": -0.13},
+		})
+
+		file := filepath.Join(t.TempDir(), "test.mmdb")
+		require.NoError(t, os.WriteFile(file, w.bytes(), 0o600))
+
+		r, err := NewMMDBResolver(file)
+		require.NoError(t, err)
+
+		g, err := r.Resolve(ctx, "1.1.1.1")
+		require.NoError(t, err)
+		require.Equal(t, Geo{Country: "AU", Region: "NSW"}, g)
+
+		g, err = r.Resolve(ctx, "8.8.4.4")
+		require.NoError(t, err)
+		require.Equal(t, Geo{Country: "US", Region: "CA"}, g)
+
+		g, err = r.Resolve(ctx, "9.9.9.9")
+		require.NoError(t, err)
+		require.Equal(t, Geo{Country: "FR"}, g)
+
+		g, err = r.Resolve(ctx, "5.5.5.5")
+		require.NoError(t, err)
+		require.Equal(t, Geo{Country: "GB", Latitude: 51.51, Longitude: -0.13}, g)
+
+		g, err = r.Resolve(ctx, "1.1.2.1")
+		require.NoError(t, err)
+		require.Equal(t, Geo{}, g)
+
+		g, err = r.Resolve(ctx, "2001:db8::1")
+		require.NoError(t, err)
+		require.Equal(t, Geo{}, g)
+	})
+
+	t.Run("ipv6", func(t *testing.T) {
+		w := newMMDBWriter(6)
+		w.insert("2001:db8::/32", geoRecord("DE", "BE"))
+		w.insert("1.1.1.0/24", geoRecord("AU", "NSW"))
+
+		r, err := newMMDBResolver(w.bytes())
+		require.NoError(t, err)
+
+		g, err := r.Resolve(ctx, "2001:db8::1")
+		require.NoError(t, err)
+		require.Equal(t, Geo{Country: "DE", Region: "BE"}, g)
+
+		g, err = r.Resolve(ctx, "1.1.1.1")
+		require.NoError(t, err)
+		require.Equal(t, Geo{Country: "AU", Region: "NSW"}, g)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := newMMDBResolver([]byte("not a mmdb"))
+		require.ErrorIs(t, err, ErrInvalidMMDB)
+	})
+}
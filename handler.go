@@ -3,10 +3,15 @@ package auth
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
+	"math"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/golang-lru/v2/expirable"
@@ -35,9 +40,22 @@ type Handler struct {
 	getUserIP      func(*http.Request) string
 	getAccessToken func(*http.Request) string
 
+	magicLinkSameBrowser bool
+
+	rateLimits map[string][]*rateLimiter
+
+	challenge      ChallengePolicy
+	challengeMu    sync.Mutex
+	challengeFails *expirable.LRU[string, int]
+
+	isReadPerm func(tag, code string) bool
+
+	cachedUsers         *expirable.LRU[int64, User]
 	cachedUserPerms     *expirable.LRU[int64, map[string]bool]
 	cachedUserPermsTTL  time.Duration
 	cachedUserPermsSize int
+
+	unregister func()
 }
 
 type HandlerOption func(h *Handler)
@@ -45,6 +63,8 @@ type HandlerOption func(h *Handler)
 type LoginForm struct {
 	Email  string `json:"email,omitempty"`
 	Passwd string `json:"passwd,omitempty"`
+
+	TrustedDevice string `json:"trustedDevice,omitempty"`
 }
 
 func NewHandler(db *Auth, options ...HandlerOption) *Handler {
@@ -66,17 +86,42 @@ func NewHandler(db *Auth, options ...HandlerOption) *Handler {
 		getAccessToken: func(r *http.Request) string {
 			return r.Header.Get("X-Access-Token")
 		},
+		isReadPerm: func(tag, code string) bool {
+			return tag == "read" || strings.HasSuffix(code, ":read")
+		},
 	}
 
 	for _, opt := range options {
 		opt(h)
 	}
 
+	h.cachedUsers = expirable.NewLRU[int64, User](h.cachedUserPermsSize, nil, h.cachedUserPermsTTL)
+	// the status of user takes effect on next request instead of after the cache is expired
+	h.unregister = db.onUserChanged(func(id int64) {
+		h.cachedUsers.Remove(id)
+	})
 	h.cachedUserPerms = expirable.NewLRU[int64, map[string]bool](h.cachedUserPermsSize, nil, h.cachedUserPermsTTL)
 
+	if h.challenge.Verifier != nil && h.challenge.AfterFails > 0 {
+		h.challengeFails = expirable.NewLRU[string, int](defaultChallengeFailsSize, nil, h.challenge.Window)
+	}
+
 	return h
 }
 
+// Close stops evicting the cached users of the handler on changes of Auth, so it can be garbage collected.
+// It should be called if the handler is discarded before Auth.
+func (h *Handler) Close() {
+	h.unregister()
+}
+
+// getLocale returns the preferred locale in Accept-Language header
+func getLocale(r *http.Request) string {
+	l, _, _ := strings.Cut(r.Header.Get("Accept-Language"), ",")
+	l, _, _ = strings.Cut(l, ";")
+	return strings.TrimSpace(l)
+}
+
 func WithGetUserIP(fn func(*http.Request) string) HandlerOption {
 	return func(h *Handler) {
 		h.getUserIP = fn
@@ -89,6 +134,14 @@ func WithGetAccessToken(fn func(*http.Request) string) HandlerOption {
 	}
 }
 
+// WithReadPerm set how to find the permissions that suspended users still have. They are perms that are tagged with "read" or coded with ":read" suffix by default.
+func WithReadPerm(fn func(tag, code string) bool) HandlerOption {
+	return func(h *Handler) {
+		h.isReadPerm = fn
+	}
+}
+
+// WithUserPermsCache set how long users' permissions and status are cached
 func WithUserPermsCache(ttl time.Duration, size int) HandlerOption {
 	return func(h *Handler) {
 		h.cachedUserPermsTTL = ttl
@@ -100,12 +153,20 @@ func WithUserPermsCache(ttl time.Duration, size int) HandlerOption {
 func (h *Handler) WithAuthn(ctx context.Context, handler func(context.Context, http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
 
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.allow(w, r) {
+			return
+		}
+
 		s, err := h.getCurrentUser(ctx, r)
 		if err != nil {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
+		if !h.checkUserStatus(ctx, w, r, s.UserID, "", "") {
+			return
+		}
+
 		handler(context.WithValue(ctx, currentUser, s), w, r)
 	}
 }
@@ -115,7 +176,11 @@ func (h *Handler) WithAuthz(ctx context.Context, tag, code string, handler func(
 	h.permissions = append(h.permissions, Perm{Tag: tag, Code: code})
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		s := &CurrentUser{}
+		if !h.allow(w, r) {
+			return
+		}
+
+		s := CurrentUser{}
 		s.UserAgent = r.UserAgent()
 		s.UserIP = h.getUserIP(r)
 		accessToken := h.getAccessToken(r)
@@ -127,6 +192,10 @@ func (h *Handler) WithAuthz(ctx context.Context, tag, code string, handler func(
 		}
 		s.UserID = id
 
+		if !h.checkUserStatus(ctx, w, r, id, tag, code) {
+			return
+		}
+
 		perms := h.getUserPerms(ctx, id.Int64)
 		ok := perms[code]
 
@@ -140,6 +209,10 @@ func (h *Handler) WithAuthz(ctx context.Context, tag, code string, handler func(
 }
 
 func (h *Handler) Login(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if !h.allow(w, r) || !h.checkChallenge(ctx, w, r) {
+		return
+	}
+
 	form, err := BindJSON[LoginForm](r)
 	if err != nil {
 		WriteClientError(w, err)
@@ -150,12 +223,23 @@ func (h *Handler) Login(ctx context.Context, w http.ResponseWriter, r *http.Requ
 		UserIP:            h.getUserIP(r),
 		UserAgent:         r.UserAgent(),
 		CreateIfNotExists: false,
+		TrustedDevice:     form.TrustedDevice,
 	})
 	if err != nil {
+		if errors.Is(err, ErrMFARequired) {
+			Write(w, http.StatusUnauthorized, session.MFA, err)
+			return
+		}
+		h.failChallenge(r)
 		WriteClientError(w, err)
 		return
 	}
 
+	h.writeSession(ctx, w, session)
+}
+
+// writeSession writes session with user's permissions, and caches the permissions
+func (h *Handler) writeSession(ctx context.Context, w http.ResponseWriter, session Session) {
 	perms, err := h.db.GetUserPerms(ctx, session.UserID)
 	if err == nil {
 		go h.cacheUserPerms(session.UserID, perms)
@@ -214,6 +298,41 @@ func (h *Handler) getUserPerms(ctx context.Context, uid int64) map[string]bool {
 	return perms
 }
 
+// checkUserStatus writes 401 if user can't call any api, or 403 if user is suspended and the request isn't read only
+func (h *Handler) checkUserStatus(ctx context.Context, w http.ResponseWriter, r *http.Request, uid shardid.ID, tag, code string) bool {
+	u, ok := h.cachedUsers.Get(uid.Int64)
+	if !ok {
+		var err error
+		u, err = h.db.getUserByID(ctx, uid)
+		if err != nil {
+			if errors.Is(err, ErrUserNotFound) {
+				WriteError(w, http.StatusUnauthorized, err)
+			} else {
+				WriteServerError(w, err)
+			}
+			return false
+		}
+		h.cachedUsers.Add(uid.Int64, u)
+	}
+
+	if err := h.db.checkUserStatus(u); err != nil {
+		WriteError(w, http.StatusUnauthorized, err)
+		return false
+	}
+
+	if u.Status == UserStatusSuspended && !isSafeMethod(r.Method) && (code == "" || !h.isReadPerm(tag, code)) {
+		WriteError(w, http.StatusForbidden, ErrUserSuspended)
+		return false
+	}
+
+	return true
+}
+
+// isSafeMethod returns true if the http method only views resources
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
 func (h *Handler) cacheUserPerms(uid int64, items []string) {
 	perms := make(map[string]bool)
 	for _, it := range items {
@@ -296,6 +415,13 @@ func WriteServerError(w http.ResponseWriter, err error) {
 }
 
 func WriteClientError(w http.ResponseWriter, err error) {
+	var ra *RetryAfterError
+	if errors.As(err, &ra) {
+		w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(ra.RetryAfter.Seconds())), 10))
+		Write[any](w, http.StatusTooManyRequests, nil, err)
+		return
+	}
+
 	Write[any](w, http.StatusBadRequest, nil, err)
 }
 
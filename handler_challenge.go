@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	// ChallengeHeader the request header that carries the response of a challenge
+	ChallengeHeader = "X-Challenge"
+
+	defaultChallengeWindow    = 15 * time.Minute
+	defaultChallengeFailsSize = 10240
+)
+
+// ChallengePolicy decides when Login, code requests(magic link, MFA code) and RequireChallenge endpoints need a challenge.
+type ChallengePolicy struct {
+	Verifier ChallengeVerifier
+	// AfterFails requires challenges only after AfterFails failed sign-ins from the same ip in Window, or from a source that is challenged
+	// by StuffingGuard. Challenges are always required if it is 0.
+	AfterFails int
+	Window     time.Duration
+}
+
+// WithChallenge requires the response of a challenge in ChallengeHeader on risky requests, eg:
+//
+//	WithChallenge(ChallengePolicy{Verifier: NewProofOfWork(key, 18, 5*time.Minute), AfterFails: 3})
+//
+// Requests without a response get ErrChallengeRequired, and ones with an invalid response get ErrChallengeFailed.
+func WithChallenge(p ChallengePolicy) HandlerOption {
+	return func(h *Handler) {
+		if p.Window <= 0 {
+			p.Window = defaultChallengeWindow
+		}
+		h.challenge = p
+	}
+}
+
+// CreateChallenge issues a challenge to the client if the verifier issues challenges itself, eg: ProofOfWork.
+func (h *Handler) CreateChallenge(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if !h.allow(w, r) {
+		return
+	}
+
+	issuer, ok := h.challenge.Verifier.(ChallengeIssuer)
+	if !ok {
+		WriteClientError(w, ErrBadRequest)
+		return
+	}
+
+	c, err := issuer.Issue(ctx, h.getUserIP(r))
+	if err != nil {
+		WriteServerError(w, ErrUnknown)
+		return
+	}
+
+	WriteJSON(w, c)
+}
+
+// RequireChallenge returns a middleware that checks the challenge by ChallengePolicy, eg: for registration endpoints.
+func (h *Handler) RequireChallenge(ctx context.Context, handler func(context.Context, http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.allow(w, r) || !h.checkChallenge(ctx, w, r) {
+			return
+		}
+
+		handler(ctx, w, r)
+	}
+}
+
+// checkChallenge verifies the response of challenge if it is required, and writes the error if it isn't passed
+func (h *Handler) checkChallenge(ctx context.Context, w http.ResponseWriter, r *http.Request) bool {
+	if h.challenge.Verifier == nil {
+		return true
+	}
+
+	userIP := h.getUserIP(r)
+	if !h.isChallengeRequired(ctx, userIP) {
+		return true
+	}
+
+	response := r.Header.Get(ChallengeHeader)
+	if response == "" {
+		Write[any](w, http.StatusForbidden, nil, ErrChallengeRequired)
+		return false
+	}
+
+	if err := h.challenge.Verifier.Verify(ctx, response, userIP); err != nil {
+		if !errors.Is(err, ErrChallengeFailed) {
+			h.db.logger.Error("auth: checkChallenge", slog.String("tag", "challenge"), slog.Any("err", err))
+			err = ErrChallengeFailed
+		}
+		Write[any](w, http.StatusForbidden, nil, err)
+		return false
+	}
+
+	return true
+}
+
+func (h *Handler) isChallengeRequired(ctx context.Context, userIP string) bool {
+	if h.challengeFails == nil {
+		return true
+	}
+
+	if n, ok := h.challengeFails.Get(userIP); ok && n >= h.challenge.AfterFails {
+		return true
+	}
+
+	decision, _ := h.db.checkSource(ctx, userIP)
+	return decision == RiskChallenge
+}
+
+// failChallenge counts a failed sign-in from the ip of request
+func (h *Handler) failChallenge(r *http.Request) {
+	if h.challengeFails == nil {
+		return
+	}
+
+	userIP := h.getUserIP(r)
+
+	h.challengeMu.Lock()
+	defer h.challengeMu.Unlock()
+
+	n, _ := h.challengeFails.Get(userIP)
+	h.challengeFails.Add(userIP, n+1)
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// magicLinkCookie the cookie that keeps the nonce of the browser that requests a magic link
+const magicLinkCookie = "auth_magic_link"
+
+type MagicLinkForm struct {
+	Email       string `json:"email,omitempty"`
+	RedirectURL string `json:"redirectURL,omitempty"`
+	Token       string `json:"token,omitempty"`
+
+	TrustedDevice string `json:"trustedDevice,omitempty"`
+}
+
+// WithMagicLinkSameBrowser requires magic links to be opened in the browser that requests them, the browser is identified by a random nonce in a cookie
+func WithMagicLinkSameBrowser() HandlerOption {
+	return func(h *Handler) {
+		h.magicLinkSameBrowser = true
+	}
+}
+
+// CreateMagicLink sends a magic link to the email. It needs a notifier on Auth.
+func (h *Handler) CreateMagicLink(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if !h.allow(w, r) || !h.checkChallenge(ctx, w, r) {
+		return
+	}
+
+	form, err := BindJSON[MagicLinkForm](r)
+	if err != nil {
+		WriteClientError(w, err)
+		return
+	}
+
+	var nonce string
+	if h.magicLinkSameBrowser {
+		nonce = randStr(magicLinkNonceLen, dicAlphaNumber)
+	}
+
+	_, err = h.db.CreateMagicLink(ctx, form.Email, form.RedirectURL, LoginOption{
+		UserIP:       h.getUserIP(r),
+		UserAgent:    r.UserAgent(),
+		Locale:       getLocale(r),
+		SameBrowser:  h.magicLinkSameBrowser,
+		BrowserNonce: nonce,
+	})
+	if err != nil {
+		WriteClientError(w, err)
+		return
+	}
+
+	if nonce != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     magicLinkCookie,
+			Value:    nonce,
+			Path:     "/",
+			MaxAge:   int(h.db.magicLinkTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	WriteEmpty(w)
+}
+
+// LoginWithMagicLink signs the user in with the token of a magic link.
+func (h *Handler) LoginWithMagicLink(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if !h.allow(w, r) {
+		return
+	}
+
+	form, err := BindJSON[MagicLinkForm](r)
+	if err != nil {
+		WriteClientError(w, err)
+		return
+	}
+
+	ci := ClientInfo{
+		UserIP:        h.getUserIP(r),
+		UserAgent:     r.UserAgent(),
+		TrustedDevice: form.TrustedDevice,
+	}
+
+	if c, err := r.Cookie(magicLinkCookie); err == nil {
+		ci.BrowserNonce = c.Value
+	}
+
+	session, err := h.db.LoginWithMagicLink(ctx, form.Token, ci)
+	if err != nil {
+		if errors.Is(err, ErrMFARequired) {
+			Write(w, http.StatusUnauthorized, session.MFA, err)
+			return
+		}
+		h.failChallenge(r)
+		WriteClientError(w, err)
+		return
+	}
+
+	h.writeSession(ctx, w, session)
+}
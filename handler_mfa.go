@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+)
+
+type MFAForm struct {
+	Token    string `json:"token,omitempty"`
+	DeviceID string `json:"deviceID,omitempty"`
+	Code     string `json:"code,omitempty"`
+
+	RememberDevice bool `json:"rememberDevice,omitempty"`
+}
+
+type DeviceForm struct {
+	UA string `json:"ua,omitempty"`
+}
+
+// VerifyMFA passes the MFA challenge returned by Login with a code of user's device, and signs the user in.
+func (h *Handler) VerifyMFA(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if !h.allow(w, r) {
+		return
+	}
+
+	form, err := BindJSON[MFAForm](r)
+	if err != nil {
+		WriteClientError(w, err)
+		return
+	}
+
+	session, err := h.db.VerifyMFA(ctx, form.Token, form.DeviceID, form.Code, form.RememberDevice, ClientInfo{
+		UserIP:    h.getUserIP(r),
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		h.failChallenge(r)
+		WriteClientError(w, err)
+		return
+	}
+
+	h.writeSession(ctx, w, session)
+}
+
+// SendMFACode sends a code to an email/SMS device of the MFA challenge returned by Login. It needs a notifier on Auth.
+func (h *Handler) SendMFACode(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if !h.allow(w, r) || !h.checkChallenge(ctx, w, r) {
+		return
+	}
+
+	form, err := BindJSON[MFAForm](r)
+	if err != nil {
+		WriteClientError(w, err)
+		return
+	}
+
+	_, err = h.db.SendMFACode(ctx, form.Token, form.DeviceID)
+	if err != nil {
+		WriteClientError(w, err)
+		return
+	}
+
+	WriteEmpty(w)
+}
+
+// ListTrustedDevices returns the remembered devices of current user. It should be wrapped by WithAuthn.
+func (h *Handler) ListTrustedDevices(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := GetCurrentUser(ctx)
+	if !ok {
+		WriteClientError(w, ErrBadRequest)
+		return
+	}
+
+	items, err := h.db.ListTrustedDevices(ctx, user.UserID.Int64)
+	if err != nil {
+		WriteClientError(w, err)
+		return
+	}
+
+	WriteJSON(w, items)
+}
+
+// RevokeTrustedDevice forgets a remembered device of current user. It should be wrapped by WithAuthn.
+func (h *Handler) RevokeTrustedDevice(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := GetCurrentUser(ctx)
+	if !ok {
+		WriteClientError(w, ErrBadRequest)
+		return
+	}
+
+	form, err := BindJSON[DeviceForm](r)
+	if err != nil {
+		WriteClientError(w, err)
+		return
+	}
+
+	err = h.db.RevokeTrustedDevice(ctx, user.UserID.Int64, form.UA)
+	if err != nil {
+		WriteClientError(w, err)
+		return
+	}
+
+	WriteEmpty(w)
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+type PasskeyRegistrationForm struct {
+	Token      string             `json:"token,omitempty"`
+	Name       string             `json:"name,omitempty"`
+	Credential PasskeyAttestation `json:"credential"`
+}
+
+type PasskeyLoginForm struct {
+	Email      string           `json:"email,omitempty"`
+	Token      string           `json:"token,omitempty"`
+	Credential PasskeyAssertion `json:"credential"`
+
+	TrustedDevice string `json:"trustedDevice,omitempty"`
+}
+
+// BeginPasskeyRegistration starts a registration ceremony for current user. It should be wrapped by WithAuthn.
+func (h *Handler) BeginPasskeyRegistration(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := GetCurrentUser(ctx)
+	if !ok {
+		WriteClientError(w, ErrBadRequest)
+		return
+	}
+
+	reg, err := h.db.BeginPasskeyRegistration(ctx, user.UserID.Int64)
+	if err != nil {
+		WriteClientError(w, err)
+		return
+	}
+
+	WriteJSON(w, reg)
+}
+
+// FinishPasskeyRegistration saves the passkey created by navigator.credentials.create(). It should be wrapped by WithAuthn.
+func (h *Handler) FinishPasskeyRegistration(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := GetCurrentUser(ctx)
+	if !ok {
+		WriteClientError(w, ErrBadRequest)
+		return
+	}
+
+	form, err := BindJSON[PasskeyRegistrationForm](r)
+	if err != nil {
+		WriteClientError(w, err)
+		return
+	}
+
+	pk, err := h.db.FinishPasskeyRegistration(ctx, user.UserID.Int64, form.Token, form.Name, form.Credential)
+	if err != nil {
+		WriteClientError(w, err)
+		return
+	}
+
+	WriteJSON(w, pk)
+}
+
+// BeginPasskeyLogin starts an authentication ceremony, email is optional for discoverable passkeys.
+func (h *Handler) BeginPasskeyLogin(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if !h.allow(w, r) {
+		return
+	}
+
+	form, err := BindJSON[PasskeyLoginForm](r)
+	if err != nil {
+		WriteClientError(w, err)
+		return
+	}
+
+	l, err := h.db.BeginPasskeyLogin(ctx, form.Email)
+	if err != nil {
+		WriteClientError(w, err)
+		return
+	}
+
+	WriteJSON(w, l)
+}
+
+// FinishPasskeyLogin signs the user in with the assertion returned by navigator.credentials.get().
+func (h *Handler) FinishPasskeyLogin(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if !h.allow(w, r) {
+		return
+	}
+
+	form, err := BindJSON[PasskeyLoginForm](r)
+	if err != nil {
+		WriteClientError(w, err)
+		return
+	}
+
+	session, err := h.db.FinishPasskeyLogin(ctx, form.Token, form.Credential, ClientInfo{
+		UserIP:        h.getUserIP(r),
+		UserAgent:     r.UserAgent(),
+		TrustedDevice: form.TrustedDevice,
+	})
+	if err != nil {
+		if errors.Is(err, ErrMFARequired) {
+			Write(w, http.StatusUnauthorized, session.MFA, err)
+			return
+		}
+		h.failChallenge(r)
+		WriteClientError(w, err)
+		return
+	}
+
+	h.writeSession(ctx, w, session)
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimitAnyRoute applies limits to all routes
+const RateLimitAnyRoute = "*"
+
+// WithRateLimit limits requests on route(path of request url) by token buckets, eg:
+//
+//	WithRateLimit("/login", RateLimit{Burst: 10, Every: time.Minute, Key: RateByIP}, RateLimit{Burst: 5, Every: time.Minute, Key: RateByIdentity})
+//
+// Limited requests get 429 with Retry-After. Limits are applied on login, MFA, magic link and passkey login endpoints, and WithAuthn/WithAuthz.
+func WithRateLimit(route string, limits ...RateLimit) HandlerOption {
+	return func(h *Handler) {
+		if h.rateLimits == nil {
+			h.rateLimits = make(map[string][]*rateLimiter)
+		}
+
+		for _, l := range limits {
+			if l.Burst < 1 || l.Every <= 0 || l.Key == nil {
+				continue
+			}
+			h.rateLimits[route] = append(h.rateLimits[route], newRateLimiter(l))
+		}
+	}
+}
+
+// allow takes tokens of the request from the limits of its route, and writes 429 if any bucket is empty
+func (h *Handler) allow(w http.ResponseWriter, r *http.Request) bool {
+	if len(h.rateLimits) == 0 {
+		return true
+	}
+
+	now := time.Now()
+	var wait time.Duration
+
+	for _, route := range []string{r.URL.Path, RateLimitAnyRoute} {
+		for i, l := range h.rateLimits[route] {
+			key := l.Key(h, r)
+			if key == "" {
+				continue
+			}
+
+			if d := l.take(route+"#"+strconv.Itoa(i)+"#"+key, now); d > wait {
+				wait = d
+			}
+		}
+	}
+
+	if wait > 0 {
+		WriteClientError(w, &RetryAfterError{RetryAfter: wait})
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	l := newRateLimiter(RateLimit{Burst: 2, Every: time.Second, Key: RateByIP, Size: 10})
+	now := time.Now()
+
+	require.Zero(t, l.take("k", now))
+	require.Zero(t, l.take("k", now))
+	require.Equal(t, time.Second, l.take("k", now))
+
+	// a token is refilled every second
+	require.Equal(t, 500*time.Millisecond, l.take("k", now.Add(500*time.Millisecond)))
+	require.Zero(t, l.take("k", now.Add(time.Second)))
+
+	require.Zero(t, l.take("other", now))
+
+	// buckets are evicted when more than Size keys are tracked
+	l = newRateLimiter(RateLimit{Burst: 1, Every: time.Second, Key: RateByIP, Size: 1})
+	require.Zero(t, l.take("k", now))
+	require.Zero(t, l.take("other", now))
+	require.Zero(t, l.take("k", now))
+}
+
+func TestRateByIdentity(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"email":" Rate@mail.com"}`))
+	require.Equal(t, "email:rate@mail.com", RateByIdentity(nil, r))
+
+	// a large body isn't read in full
+	body := `{"email":"rate@mail.com","name":"` + strings.Repeat("x", maxIdentityBodySize) + `"}`
+	r = httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body))
+	require.Empty(t, RateByIdentity(nil, r))
+
+	buf, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	require.Len(t, buf, maxIdentityBodySize)
+}
+
+func TestRateLimit(t *testing.T) {
+	au := createAuthTest("./tests_rate_limit.db")
+	ctx := context.Background()
+
+	_, err := au.CreateUser(ctx, UserStatusActivated, "rate@mail.com", "", "abc123", "", "")
+	require.NoError(t, err)
+
+	h := NewHandler(au,
+		WithRateLimit("/login", RateLimit{Burst: 3, Every: time.Minute, Key: RateByIP}),
+		WithRateLimit("/login", RateLimit{Burst: 2, Every: time.Minute, Key: RateByIdentity}),
+		WithRateLimit(RateLimitAnyRoute, RateLimit{Burst: 1, Every: time.Minute, Key: RateBy(func(r *http.Request) string {
+			return r.Header.Get("X-Tenant")
+		})}))
+
+	login := func(ip, email, tenant string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"email":"`+email+`","passwd":"abc123"}`))
+		r.RemoteAddr = ip + ":1234"
+		if tenant != "" {
+			r.Header.Set("X-Tenant", tenant)
+		}
+		w := httptest.NewRecorder()
+		h.Login(ctx, w, r)
+		return w
+	}
+
+	// the body is still readable after it is keyed by identity
+	require.Equal(t, http.StatusOK, login("1.1.1.1", "rate@mail.com", "").Code)
+	require.Equal(t, http.StatusOK, login("1.1.1.1", "rate@mail.com", "").Code)
+
+	// identity
+	w := login("2.2.2.2", "Rate@mail.com", "")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "60", w.Header().Get("Retry-After"))
+	require.Contains(t, w.Body.String(), ErrTooManyRequests.Error())
+
+	// ip
+	require.Equal(t, http.StatusBadRequest, login("1.1.1.1", "other@mail.com", "").Code)
+	require.Equal(t, http.StatusTooManyRequests, login("1.1.1.1", "other2@mail.com", "").Code)
+
+	// custom key on any route
+	require.Equal(t, http.StatusBadRequest, login("3.3.3.3", "other3@mail.com", "t1").Code)
+	require.Equal(t, http.StatusTooManyRequests, login("4.4.4.4", "other4@mail.com", "t1").Code)
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+)
+
+type SourceForm struct {
+	Source string `json:"source,omitempty"`
+}
+
+// ListBlockedSources returns the sources that are blocked or challenged for credential stuffing. It should be wrapped by WithAuthz for admins.
+func (h *Handler) ListBlockedSources(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	items, err := h.db.ListBlockedSources(ctx)
+	if err != nil {
+		WriteServerError(w, err)
+		return
+	}
+
+	WriteJSON(w, items)
+}
+
+// UnblockSource removes the block of an ip or network. It should be wrapped by WithAuthz for admins.
+func (h *Handler) UnblockSource(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	form, err := BindJSON[SourceForm](r)
+	if err != nil || form.Source == "" {
+		WriteClientError(w, ErrBadRequest)
+		return
+	}
+
+	err = h.db.UnblockSource(ctx, form.Source)
+	if err != nil {
+		WriteServerError(w, err)
+		return
+	}
+
+	WriteEmpty(w)
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+)
+
+// IPList a list of ips and networks, eg: an ip reputation list
+type IPList struct {
+	nets []*net.IPNet
+}
+
+// NewIPList create a list of ips and CIDR networks
+func NewIPList(items ...string) (*IPList, error) {
+	l := &IPList{}
+	for _, it := range items {
+		if err := l.add(it); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// LoadIPList loads ips and CIDR networks from files, one item per line. Empty lines and comments after # are skipped.
+func LoadIPList(files ...string) (*IPList, error) {
+	l := &IPList{}
+	for _, file := range files {
+		if err := l.load(file); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Contains checks if ip is in the list
+func (l *IPList) Contains(ip string) bool {
+	if l == nil {
+		return false
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+
+	for _, n := range l.nets {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *IPList) load(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line, _, _ := strings.Cut(s.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if err := l.add(fields[0]); err != nil {
+			return err
+		}
+	}
+
+	return s.Err()
+}
+
+func (l *IPList) add(item string) error {
+	if !strings.Contains(item, "/") {
+		ip := net.ParseIP(item)
+		if ip == nil {
+			return ErrInvalidIP
+		}
+
+		if ip.To4() != nil {
+			item += "/32"
+		} else {
+			item += "/128"
+		}
+	}
+
+	_, n, err := net.ParseCIDR(item)
+	if err != nil {
+		return ErrInvalidIP
+	}
+
+	l.nets = append(l.nets, n)
+	return nil
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/yaitoo/sqle"
+)
+
+// KeyStore keeps the data keys of users that are wrapped with the AES key. It should be outside the database that user data is backed up with,
+// eg. a KMS or a database that isn't backed up with it, otherwise backups keep the data keys of purged users and their data is still readable.
+type KeyStore interface {
+	// Get returns the wrapped data key of user, or ErrUserDataShredded if it doesn't exist
+	Get(ctx context.Context, userID int64) (string, error)
+	// Put saves the wrapped data key of user, it fails if user already has one
+	Put(ctx context.Context, userID int64, key string) error
+	// Delete destroys the data key of user
+	Delete(ctx context.Context, userID int64) error
+}
+
+// DBKeyStore a KeyStore that keeps data keys in the `<prefix>user_key` table of its own database. All keys are kept on the first database of db,
+// shards of db are not used.
+type DBKeyStore struct {
+	db     *sqle.DB
+	prefix string
+}
+
+// NewDBKeyStore create a key store on db, it should not be the database of users. The table is created by Init.
+func NewDBKeyStore(db *sqle.DB, prefix string) *DBKeyStore {
+	if prefix != "" && !strings.HasSuffix(prefix, "_") {
+		prefix = prefix + "_"
+	}
+
+	return &DBKeyStore{
+		db:     db,
+		prefix: prefix,
+	}
+}
+
+func (s *DBKeyStore) createBuilder() *sqle.Builder {
+	return sqle.New().Input("prefix", s.prefix)
+}
+
+// Init creates the table of data keys if it doesn't exist
+func (s *DBKeyStore) Init(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS `"+s.prefix+"user_key` ("+
+		"`user_id` bigint NOT NULL, "+
+		"`data_key` text NOT NULL, "+
+		"`created_at` datetime NOT NULL, "+
+		"PRIMARY KEY (`user_id`))")
+
+	return err
+}
+
+// Get returns the wrapped data key of user
+func (s *DBKeyStore) Get(ctx context.Context, userID int64) (string, error) {
+	var key string
+	err := s.db.
+		QueryRowBuilder(ctx, s.createBuilder().
+			Select("<prefix>user_key", "data_key").
+			Where("user_id = {user_id}").
+			Param("user_id", userID)).
+		Scan(&key)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUserDataShredded
+	}
+
+	return key, err
+}
+
+// Put saves the wrapped data key of user
+func (s *DBKeyStore) Put(ctx context.Context, userID int64, key string) error {
+	_, err := s.db.
+		ExecBuilder(ctx, s.createBuilder().
+			Insert("<prefix>user_key").
+			Set("user_id", userID).
+			Set("data_key", key).
+			Set("created_at", time.Now()).
+			End())
+
+	return err
+}
+
+// Delete destroys the data key of user
+func (s *DBKeyStore) Delete(ctx context.Context, userID int64) error {
+	_, err := s.db.
+		ExecBuilder(ctx, s.createBuilder().
+			Delete("<prefix>user_key").
+			Where("user_id = {user_id}").
+			Param("user_id", userID))
+
+	return err
+}
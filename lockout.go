@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"math"
+	"time"
+)
+
+// Lockout locks account after Threshold failed logins. The n-th lock lasts n*Duration, or Duration*2^(n-1) if Exponential, and at most MaxDuration.
+// Threshold < 0 disables lockout.
+type Lockout struct {
+	Threshold   int
+	Duration    time.Duration
+	MaxDuration time.Duration
+	Exponential bool
+}
+
+// lockDuration returns how long the n-th lock lasts
+func (l Lockout) lockDuration(n int) time.Duration {
+	if n < 1 {
+		return 0
+	}
+
+	d := l.Duration
+	if l.Exponential {
+		for i := 1; i < n && d < math.MaxInt64/2 && (l.MaxDuration <= 0 || d < l.MaxDuration); i++ {
+			d *= 2
+		}
+	} else {
+		d = time.Duration(n) * l.Duration
+	}
+
+	if l.MaxDuration > 0 && d > l.MaxDuration {
+		d = l.MaxDuration
+	}
+
+	return d
+}
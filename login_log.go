@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/yaitoo/sqle"
+	"github.com/yaitoo/sqle/shardid"
+)
+
+// LoginMethod the method of a login
+type LoginMethod string
+
+const (
+	// LoginMethodPasswd email/mobile and password
+	LoginMethodPasswd LoginMethod = "E"
+	// LoginMethodPasswordless login code, magic link or passkey
+	LoginMethodPasswordless LoginMethod = "L"
+	// LoginMethodTOTP authenticator app
+	LoginMethodTOTP LoginMethod = "T"
+	// LoginMethodOAuth OAuth/OpenID provider
+	LoginMethodOAuth LoginMethod = "A"
+)
+
+// LoginLog a login attempt of user
+type LoginLog struct {
+	ID        shardid.ID  `json:"id"`
+	UserID    shardid.ID  `json:"userID"`
+	Method    LoginMethod `json:"method"`
+	IsOK      sqle.Bool   `json:"isOK"`
+	IP        string      `json:"ip"`
+	UA        string      `json:"ua"`
+	CreatedAt time.Time   `json:"createdAt"`
+
+	// RiskScore/RiskDecision/RiskReasons the risk that is assessed before session is created
+	RiskScore    int          `json:"riskScore,omitempty"`
+	RiskDecision RiskDecision `json:"riskDecision,omitempty"`
+	RiskReasons  string       `json:"riskReasons,omitempty"`
+}
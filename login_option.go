@@ -9,6 +9,17 @@ type LoginOption struct {
 	// UserAgent user's device info
 	UserAgent string
 
+	// Locale user's locale that messages are rendered in, eg: en, zh-CN
+	Locale string
+
+	// SameBrowser magic link can only be used by the browser that requests it, the browser is identified by BrowserNonce
+	SameBrowser bool
+	// BrowserNonce a random nonce that is kept by the browser, eg. in a cookie. It is required if SameBrowser is true
+	BrowserNonce string
+
+	// TrustedDevice the token issued by VerifyMFA when device is remembered, MFA is skipped while it is valid
+	TrustedDevice string
+
 	// FirstName first name. only use when CreateIfNotExists is true
 	FirstName string
 	// LastName last name. only use when CreateIfNotExists is true
@@ -20,4 +31,8 @@ type ClientInfo struct {
 	UserIP string
 	// UserAgent user's device info
 	UserAgent string
+	// TrustedDevice the token issued by VerifyMFA when device is remembered, MFA is skipped while it is valid
+	TrustedDevice string
+	// BrowserNonce the nonce that the browser keeps, it is checked if the magic link is created for the same browser
+	BrowserNonce string
 }
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/yaitoo/sqle"
+	"github.com/yaitoo/sqle/shardid"
+)
+
+// MFAKind kind of second-factor device
+type MFAKind string
+
+const (
+	// MFATOTP authenticator app with time-based one-time passwords
+	MFATOTP MFAKind = "totp"
+	// MFAEmail one-time code sent by email
+	MFAEmail MFAKind = "email"
+	// MFASMS one-time code sent by SMS
+	MFASMS MFAKind = "sms"
+	// MFAPasskey WebAuthn passkey, it is verified by a passkey ceremony
+	MFAPasskey MFAKind = "passkey"
+)
+
+// channel returns the channel that codes of email/SMS devices are sent by
+func (k MFAKind) channel() Channel {
+	if k == MFASMS {
+		return ChannelSMS
+	}
+	return ChannelEmail
+}
+
+// loginMethod returns the method that login logs record for the device
+func (k MFAKind) loginMethod() LoginMethod {
+	if k == MFATOTP {
+		return LoginMethodTOTP
+	}
+	return LoginMethodPasswordless
+}
+
+// MFADevice a named second-factor device of user
+type MFADevice struct {
+	UserID shardid.ID `json:"userID,omitempty"`
+	ID     string     `json:"id,omitempty"`
+	Kind   MFAKind    `json:"kind,omitempty"`
+	Name   string     `json:"name,omitempty"`
+	// Secret encrypted TOTP key, or email/mobile that codes are sent to
+	Secret     string    `json:"-"`
+	CreatedAt  time.Time `json:"createdAt,omitempty"`
+	LastUsedAt sqle.Time `json:"lastUsedAt,omitempty"`
+}
+
+// MFASetup the pending enrollment of a device. Token should be sent back with the first code generated by the device.
+type MFASetup struct {
+	Token string `json:"token"`
+	// Secret TOTP key for manual entry
+	Secret string `json:"secret,omitempty"`
+	// URL otpauth:// url of TOTP key, it is usually shown as QR code
+	URL string `json:"url,omitempty"`
+	// Code the verification code that should be delivered to email/mobile
+	Code string `json:"-"`
+}
+
+// MFAMethod a method that can be used to pass a second-factor challenge
+type MFAMethod struct {
+	ID   string  `json:"id"`
+	Kind MFAKind `json:"kind"`
+	Name string  `json:"name,omitempty"`
+	// Hint masked email/mobile that code is sent to
+	Hint string `json:"hint,omitempty"`
+}
+
+// MFAChallenge a second-factor challenge of a login
+type MFAChallenge struct {
+	Token   string      `json:"token"`
+	Methods []MFAMethod `json:"methods"`
+}
@@ -0,0 +1,4 @@
+ALTER TABLE `<prefix>login_code`
+  ADD COLUMN `purpose` varchar(20) NOT NULL DEFAULT 'login',
+  DROP PRIMARY KEY,
+  ADD PRIMARY KEY (`user_id`,`purpose`,`hash`);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS `<prefix>passkey_challenge` (
+  `hash` varchar(64) NOT NULL,
+  `expires_at` datetime NOT NULL,
+  PRIMARY KEY (`hash`)
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS `<prefix>audit_chain` (
+  `chain` int NOT NULL,
+  `seq` bigint NOT NULL,
+  `hash` varchar(64) NOT NULL,
+  PRIMARY KEY (`chain`)
+);
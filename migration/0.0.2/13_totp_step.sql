@@ -0,0 +1,2 @@
+ALTER TABLE `<prefix>user_mfa` ADD COLUMN `totp_step` bigint NOT NULL DEFAULT 0;
+ALTER TABLE `<prefix>user_profile` ADD COLUMN `totp_step` bigint NOT NULL DEFAULT 0;
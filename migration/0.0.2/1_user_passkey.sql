@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `<prefix>user_passkey` (
+  `user_id` bigint NOT NULL,
+  `hash` varchar(255) NOT NULL,
+  `cred_id` varchar(1400) NOT NULL,
+  `name` varchar(45) NOT NULL,
+  `public_key` text NOT NULL,
+  `sign_count` bigint NOT NULL DEFAULT 0,
+  `aaguid` varchar(36) NOT NULL,
+  `transports` varchar(125) NOT NULL,
+  `created_at` datetime NOT NULL,
+  `last_used_at` datetime NULL,
+  PRIMARY KEY (`user_id`,`hash`)
+);
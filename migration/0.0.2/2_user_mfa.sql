@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS `<prefix>user_mfa` (
+  `user_id` bigint NOT NULL,
+  `id` varchar(45) NOT NULL,
+  `kind` varchar(10) NOT NULL,
+  `name` varchar(45) NOT NULL,
+  `secret` text NOT NULL,
+  `created_at` datetime NOT NULL,
+  `last_used_at` datetime NULL,
+  PRIMARY KEY (`user_id`,`id`)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE `<prefix>user_device` ADD COLUMN `trust_hash` varchar(64) NULL;
+ALTER TABLE `<prefix>user_device` ADD COLUMN `trusted_until` datetime NULL;
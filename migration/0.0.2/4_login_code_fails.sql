@@ -0,0 +1 @@
+ALTER TABLE `<prefix>login_code` ADD COLUMN `fails` int NOT NULL DEFAULT 0;
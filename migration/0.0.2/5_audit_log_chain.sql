@@ -0,0 +1,6 @@
+ALTER TABLE `<prefix>audit_log` ADD COLUMN `chain` int NOT NULL DEFAULT 0;
+ALTER TABLE `<prefix>audit_log` ADD COLUMN `seq` bigint NOT NULL DEFAULT 0;
+ALTER TABLE `<prefix>audit_log` ADD COLUMN `prev_hash` varchar(64) NOT NULL DEFAULT '';
+ALTER TABLE `<prefix>audit_log` ADD COLUMN `hash` varchar(64) NOT NULL DEFAULT '';
+ALTER TABLE `<prefix>audit_log` ADD COLUMN `checkpoint` varchar(64) NULL;
+ALTER TABLE `<prefix>audit_log` ADD KEY `idx_chain_seq` (`chain`, `seq`);
@@ -0,0 +1,3 @@
+ALTER TABLE `<prefix>login_log` ADD COLUMN `risk_score` int NOT NULL DEFAULT 0;
+ALTER TABLE `<prefix>login_log` ADD COLUMN `risk_decision` varchar(10) NOT NULL DEFAULT '';
+ALTER TABLE `<prefix>login_log` ADD COLUMN `risk_reasons` varchar(100) NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE `<prefix>user` ADD COLUMN `deleted_at` datetime NULL;
+
+CREATE INDEX `idx_user_deleted` ON `<prefix>user` (`deleted_at`);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS `<prefix>user_key` (
+  `user_id` bigint NOT NULL,
+  `data_key` text NOT NULL,
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`user_id`)
+);
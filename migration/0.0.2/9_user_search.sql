@@ -0,0 +1,14 @@
+ALTER TABLE `<prefix>user`
+  MODIFY `first_name` varchar(1024) NOT NULL,
+  MODIFY `last_name` varchar(1024) NOT NULL,
+  MODIFY `email` varchar(1024) NOT NULL,
+  MODIFY `mobile` varchar(1024) NOT NULL;
+
+CREATE TABLE IF NOT EXISTS `<prefix>user_search` (
+  `user_id` bigint NOT NULL,
+  `field` varchar(20) NOT NULL,
+  `token` varchar(64) NOT NULL,
+  PRIMARY KEY (`user_id`,`field`,`token`)
+);
+
+CREATE INDEX `idx_user_search_token` ON `<prefix>user_search` (`field`,`token`);
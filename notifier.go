@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	htmltemplate "html/template"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+// Channel the channel that a message is delivered by
+type Channel string
+
+const (
+	ChannelEmail Channel = "email"
+	ChannelSMS   Channel = "sms"
+)
+
+// MessageType the type of notification
+type MessageType string
+
+const (
+	MessageLoginCode      MessageType = "login_code"
+	MessageVerification   MessageType = "verification"
+	MessagePasswordReset  MessageType = "password_reset"
+	MessageNewDevice      MessageType = "new_device"
+	MessageMagicLink      MessageType = "magic_link"
+	MessageProfileChanged MessageType = "profile_changed"
+)
+
+const defaultLocale = "en"
+
+// Message a rendered notification
+type Message struct {
+	Channel Channel     `json:"channel"`
+	Type    MessageType `json:"type"`
+	Locale  string      `json:"locale,omitempty"`
+	To      string      `json:"to"`
+	// Subject subject of email, it is empty for SMS
+	Subject string `json:"subject,omitempty"`
+	Text    string `json:"text,omitempty"`
+	// HTML html body of email, it is empty for SMS
+	HTML string `json:"html,omitempty"`
+}
+
+// MessageData the data that templates are executed with
+type MessageData struct {
+	AppName string
+	To      string
+	Code    string
+	Link    string
+	// ExpiresIn how long the code/link is valid
+	ExpiresIn time.Duration
+	Device    string
+	IP        string
+	Time      time.Time
+}
+
+// Notifier delivers messages to users
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Templates message templates per channel, message type and locale.
+// Subject and text are text/template, html is html/template.
+type Templates struct {
+	mu       sync.RWMutex
+	locale   string
+	subjects map[string]*texttemplate.Template
+	texts    map[string]*texttemplate.Template
+	htmls    map[string]*htmltemplate.Template
+}
+
+// NewTemplates create an empty template set, locale is used when a message has no template in its locale
+func NewTemplates(locale string) *Templates {
+	if locale == "" {
+		locale = defaultLocale
+	}
+
+	return &Templates{
+		locale:   locale,
+		subjects: make(map[string]*texttemplate.Template),
+		texts:    make(map[string]*texttemplate.Template),
+		htmls:    make(map[string]*htmltemplate.Template),
+	}
+}
+
+// Add parses and adds templates for a message type in locale. subject and html are ignored for SMS.
+func (t *Templates) Add(ch Channel, typ MessageType, locale, subject, text, html string) error {
+	key := templateKey(ch, typ, locale)
+
+	tt, err := texttemplate.New(key).Parse(text)
+	if err != nil {
+		return err
+	}
+
+	var st *texttemplate.Template
+	var ht *htmltemplate.Template
+	if ch == ChannelEmail {
+		st, err = texttemplate.New(key).Parse(subject)
+		if err != nil {
+			return err
+		}
+
+		if html != "" {
+			ht, err = htmltemplate.New(key).Parse(html)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.texts[key] = tt
+	if st != nil {
+		t.subjects[key] = st
+	}
+	if ht != nil {
+		t.htmls[key] = ht
+	} else {
+		delete(t.htmls, key)
+	}
+
+	return nil
+}
+
+// Render renders the message in locale, it falls back to the language of locale and then the default locale.
+func (t *Templates) Render(ch Channel, typ MessageType, locale, to string, data MessageData) (Message, error) {
+	msg := Message{
+		Channel: ch,
+		Type:    typ,
+		To:      to,
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, l := range t.fallbacks(locale) {
+		key := templateKey(ch, typ, l)
+		tt, ok := t.texts[key]
+		if !ok {
+			continue
+		}
+
+		msg.Locale = l
+
+		var buf bytes.Buffer
+		if err := tt.Execute(&buf, data); err != nil {
+			return msg, err
+		}
+		msg.Text = buf.String()
+
+		if st, ok := t.subjects[key]; ok {
+			buf.Reset()
+			if err := st.Execute(&buf, data); err != nil {
+				return msg, err
+			}
+			msg.Subject = buf.String()
+		}
+
+		if ht, ok := t.htmls[key]; ok {
+			buf.Reset()
+			if err := ht.Execute(&buf, data); err != nil {
+				return msg, err
+			}
+			msg.HTML = buf.String()
+		}
+
+		return msg, nil
+	}
+
+	return msg, ErrTemplateNotFound
+}
+
+// fallbacks returns locales that are tried in order, eg: zh-CN, zh, en
+func (t *Templates) fallbacks(locale string) []string {
+	var items []string
+	if locale != "" {
+		items = append(items, locale)
+		if i := strings.IndexAny(locale, "-_"); i > 0 {
+			items = append(items, locale[:i])
+		}
+	}
+
+	return append(items, t.locale)
+}
+
+func templateKey(ch Channel, typ MessageType, locale string) string {
+	return string(ch) + ":" + string(typ) + ":" + strings.ToLower(locale)
+}
+
+// DefaultTemplates returns the built-in English templates of all message types
+func DefaultTemplates() *Templates {
+	t := NewTemplates(defaultLocale)
+
+	for _, it := range defaultTemplates {
+		if err := t.Add(it.ch, it.typ, defaultLocale, it.subject, it.text, it.html); err != nil {
+			panic(err)
+		}
+	}
+
+	return t
+}
+
+var defaultTemplates = []struct {
+	ch      Channel
+	typ     MessageType
+	subject string
+	text    string
+	html    string
+}{
+	{
+		ch:      ChannelEmail,
+		typ:     MessageLoginCode,
+		subject: "Your {{.AppName}} sign in code",
+		text:    "Your sign in code is {{.Code}}. It expires in {{.ExpiresIn}}.",
+		html:    "<p>Your sign in code is <strong>{{.Code}}</strong>.</p><p>It expires in {{.ExpiresIn}}.</p>",
+	},
+	{
+		ch:   ChannelSMS,
+		typ:  MessageLoginCode,
+		text: "{{.AppName}}: your sign in code is {{.Code}}",
+	},
+	{
+		ch:      ChannelEmail,
+		typ:     MessageMagicLink,
+		subject: "Sign in to {{.AppName}}",
+		text:    "Sign in to {{.AppName}}: {{.Link}} The link expires in {{.ExpiresIn}} and can be used once.",
+		html:    "<p><a href=\"{{.Link}}\">Sign in to {{.AppName}}</a></p><p>The link expires in {{.ExpiresIn}} and can be used once.</p>",
+	},
+	{
+		ch:      ChannelEmail,
+		typ:     MessageVerification,
+		subject: "Verify your {{.AppName}} email",
+		text:    "{{if .Code}}Your verification code is {{.Code}}.{{end}}{{if .Link}} Verify your email: {{.Link}}{{end}}",
+		html:    "{{if .Code}}<p>Your verification code is <strong>{{.Code}}</strong>.</p>{{end}}{{if .Link}}<p><a href=\"{{.Link}}\">Verify your email</a></p>{{end}}",
+	},
+	{
+		ch:   ChannelSMS,
+		typ:  MessageVerification,
+		text: "{{.AppName}}: your verification code is {{.Code}}",
+	},
+	{
+		ch:      ChannelEmail,
+		typ:     MessagePasswordReset,
+		subject: "Reset your {{.AppName}} password",
+		text:    "Reset your password: {{.Link}}{{if .Code}} Code: {{.Code}}{{end}}. If you didn't request it, ignore this email.",
+		html:    "<p><a href=\"{{.Link}}\">Reset your password</a>{{if .Code}} Code: <strong>{{.Code}}</strong>{{end}}</p><p>If you didn't request it, ignore this email.</p>",
+	},
+	{
+		ch:   ChannelSMS,
+		typ:  MessagePasswordReset,
+		text: "{{.AppName}}: your password reset code is {{.Code}}",
+	},
+	{
+		ch:      ChannelEmail,
+		typ:     MessageNewDevice,
+		subject: "New sign in to {{.AppName}}",
+		text:    "Your account was signed in from {{.Device}} ({{.IP}}) at {{.Time.Format \"2006-01-02 15:04 MST\"}}. If it wasn't you, change your password.",
+		html:    "<p>Your account was signed in from <strong>{{.Device}}</strong> ({{.IP}}) at {{.Time.Format \"2006-01-02 15:04 MST\"}}.</p><p>If it wasn't you, change your password.</p>",
+	},
+	{
+		ch:      ChannelEmail,
+		typ:     MessageProfileChanged,
+		subject: "Your {{.AppName}} email was changed",
+		text:    "The email of your {{.AppName}} account was changed. If it wasn't you, restore it and sign out everywhere: {{.Link}} The link expires in {{.ExpiresIn}}.",
+		html:    "<p>The email of your {{.AppName}} account was changed.</p><p>If it wasn't you, <a href=\"{{.Link}}\">restore it and sign out everywhere</a>. The link expires in {{.ExpiresIn}}.</p>",
+	},
+	{
+		ch:   ChannelSMS,
+		typ:  MessageProfileChanged,
+		text: "{{.AppName}}: your mobile was changed. If it wasn't you, restore it: {{.Link}}",
+	},
+	{
+		ch:   ChannelSMS,
+		typ:  MessageNewDevice,
+		text: "{{.AppName}}: new sign in from {{.Device}} ({{.IP}})",
+	},
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// FileNotifier writes each message as a json file into an outbox directory
+type FileNotifier struct {
+	dir string
+	seq atomic.Int64
+}
+
+// NewFileNotifier create a notifier that writes messages into dir
+func NewFileNotifier(dir string) (*FileNotifier, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileNotifier{dir: dir}, nil
+}
+
+// Send writes the message to {dir}/{unixnano}_{seq}_{channel}_{type}.json
+func (n *FileNotifier) Send(_ context.Context, msg Message) error {
+	buf, err := json.MarshalIndent(msg, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d_%d_%s_%s.json", time.Now().UnixNano(), n.seq.Add(1), msg.Channel, msg.Type)
+
+	return os.WriteFile(filepath.Join(n.dir, name), buf, 0o600)
+}
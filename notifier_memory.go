@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryNotifier keeps messages in memory, it is designed for tests
+type MemoryNotifier struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewMemoryNotifier create an in-memory notifier
+func NewMemoryNotifier() *MemoryNotifier {
+	return &MemoryNotifier{}
+}
+
+// Send keeps the message
+func (n *MemoryNotifier) Send(_ context.Context, msg Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.messages = append(n.messages, msg)
+	return nil
+}
+
+// Messages returns all sent messages
+func (n *MemoryNotifier) Messages() []Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]Message(nil), n.messages...)
+}
+
+// Last returns the latest message sent to the recipient
+func (n *MemoryNotifier) Last(to string) (Message, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for i := len(n.messages) - 1; i >= 0; i-- {
+		if n.messages[i].To == to {
+			return n.messages[i], true
+		}
+	}
+
+	return Message{}, false
+}
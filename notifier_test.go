@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplates(t *testing.T) {
+	tpl := DefaultTemplates()
+
+	err := tpl.Add(ChannelEmail, MessageLoginCode, "zh", "{{.AppName}} 登录码", "登录码：{{.Code}}", "<b>{{.Code}}</b>")
+	require.NoError(t, err)
+
+	data := MessageData{AppName: "Yaitoo", Code: "<123456>", ExpiresIn: time.Minute}
+
+	msg, err := tpl.Render(ChannelEmail, MessageLoginCode, "zh-CN", "a@mail.com", data)
+	require.NoError(t, err)
+	require.Equal(t, "zh", msg.Locale)
+	require.Equal(t, "Yaitoo 登录码", msg.Subject)
+	require.Equal(t, "登录码：<123456>", msg.Text)
+	require.Equal(t, "<b>&lt;123456&gt;</b>", msg.HTML)
+
+	// falls back to default locale
+	msg, err = tpl.Render(ChannelSMS, MessageLoginCode, "zh-CN", "1+222333444", data)
+	require.NoError(t, err)
+	require.Equal(t, "en", msg.Locale)
+	require.Empty(t, msg.Subject)
+	require.Empty(t, msg.HTML)
+	require.Contains(t, msg.Text, "<123456>")
+
+	_, err = NewTemplates("").Render(ChannelSMS, MessageLoginCode, "", "1+222333444", data)
+	require.ErrorIs(t, err, ErrTemplateNotFound)
+
+	require.Error(t, tpl.Add(ChannelSMS, MessageNewDevice, "en", "", "{{.Code", ""))
+}
+
+func TestFileNotifier(t *testing.T) {
+	dir := t.TempDir()
+	n, err := NewFileNotifier(filepath.Join(dir, "outbox"))
+	require.NoError(t, err)
+
+	msg := Message{Channel: ChannelSMS, Type: MessageLoginCode, To: "1+222333444", Text: "123456"}
+	require.NoError(t, n.Send(context.Background(), msg))
+	require.NoError(t, n.Send(context.Background(), msg))
+
+	files, err := os.ReadDir(filepath.Join(dir, "outbox"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+
+	buf, err := os.ReadFile(filepath.Join(dir, "outbox", files[0].Name()))
+	require.NoError(t, err)
+
+	var got Message
+	require.NoError(t, json.Unmarshal(buf, &got))
+	require.Equal(t, msg, got)
+}
+
+func TestNotifyLoginCode(t *testing.T) {
+	au := createAuthTest("./tests_notify.db")
+	n := NewMemoryNotifier()
+	au.notifier = n
+
+	ctx := context.Background()
+
+	code, err := au.CreateLoginCode(ctx, "notify@mail.com", LoginOption{CreateIfNotExists: true})
+	require.NoError(t, err)
+
+	msg, ok := n.Last("notify@mail.com")
+	require.True(t, ok)
+	require.Equal(t, ChannelEmail, msg.Channel)
+	require.Equal(t, MessageLoginCode, msg.Type)
+	require.Contains(t, msg.Text, code)
+	require.Contains(t, msg.HTML, code)
+
+	code, err = au.CreateLoginMobileCode(ctx, "1+222333444", LoginOption{CreateIfNotExists: true})
+	require.NoError(t, err)
+
+	msg, ok = n.Last("1+222333444")
+	require.True(t, ok)
+	require.Equal(t, ChannelSMS, msg.Channel)
+	require.Contains(t, msg.Text, code)
+
+	_, err = au.CreateLoginCode(ctx, "not_found@mail.com", LoginOption{})
+	require.ErrorIs(t, err, ErrEmailNotFound)
+	require.Len(t, n.Messages(), 2)
+}
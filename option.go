@@ -33,7 +33,15 @@ func WithGenLogin(gen *shardid.Generator) Option {
 // WithGenAuditLog set custom shardid generator for audit log id
 func WithGenAuditLog(gen *shardid.Generator) Option {
 	return func(a *Auth) {
-		a.genUser = gen
+		a.genAuditLog = gen
+	}
+}
+
+// WithAuditCheckpoint writes a HMAC checkpoint every n audit logs of a chain, so the chain can't be rebuilt without key
+func WithAuditCheckpoint(key string, n int) Option {
+	return func(a *Auth) {
+		a.auditCheckpointKey = deriveKey([]byte(key), "audit:checkpoint")
+		a.auditCheckpointEvery = n
 	}
 }
 
@@ -44,13 +52,38 @@ func WithHash(h func() hash.Hash) Option {
 	}
 }
 
-// WithAES setup AES key
+// WithAES setup AES key. The profile data and MFA secrets of each user are encrypted with a per-user data key that is wrapped with it,
+// and the data key is destroyed when user is purged.
 func WithAES(key string) Option {
 	return func(a *Auth) {
 		a.aesKey = getAESKey(key)
 	}
 }
 
+// WithKeyStore keeps the data keys of users in s instead of the user_key table, so backups of the database don't keep the data keys of purged users.
+// The data keys in the user_key table are still used until they are moved by ResealUserData.
+func WithKeyStore(s KeyStore) Option {
+	return func(a *Auth) {
+		a.keyStore = s
+	}
+}
+
+// WithEncryptedField encrypts field of user table with the data key of user, and index tells how it can be searched by WhereUserField.
+// It requires the AES key. The rows that are written before it is enabled are still readable, but they stay in plaintext and
+// are not matched by WhereUserField until ResealUserData encrypts and indexes them.
+//
+// A searchable field is indexed by keyed hashes of its value, so anyone who reads the index can tell which users share a value, and how common it is.
+// SearchPrefix leaks more: it tells which users share the first 3 to 32 characters, and roughly how long values are. Use SearchNone for fields
+// that are never searched, and SearchExact unless prefix search is needed.
+func WithEncryptedField(field UserField, index SearchIndex) Option {
+	return func(a *Auth) {
+		if a.encryptedFields == nil {
+			a.encryptedFields = make(map[UserField]SearchIndex)
+		}
+		a.encryptedFields[field] = index
+	}
+}
+
 // WithAccessTokenTTL  setup ttl for access token
 func WithAccessTokenTTL(d time.Duration) Option {
 	return func(a *Auth) {
@@ -99,3 +132,147 @@ func WithLoginCode(size int, ttl time.Duration) Option {
 		a.loginCodeTTL = ttl
 	}
 }
+
+// WithLoginCodeAttempts set how many wrong guesses a sign in code allows before it is invalidated
+func WithLoginCodeAttempts(n int) Option {
+	return func(a *Auth) {
+		a.loginCodeAttempts = n
+	}
+}
+
+// WithWebAuthn setup the relying party for passkeys. origins defaults to https://{rpID}
+func WithWebAuthn(rpID, rpName string, origins ...string) Option {
+	return func(a *Auth) {
+		a.rpID = rpID
+		a.rpName = rpName
+		a.rpOrigins = origins
+	}
+}
+
+// WithPasskeyTimeout setup how long a passkey ceremony can take
+func WithPasskeyTimeout(d time.Duration) Option {
+	return func(a *Auth) {
+		a.passkeyTimeout = d
+	}
+}
+
+// WithTrustedDeviceTTL setup how long a remembered device can skip MFA
+func WithTrustedDeviceTTL(d time.Duration) Option {
+	return func(a *Auth) {
+		a.trustedDeviceTTL = d
+	}
+}
+
+// WithNotifier set notifier that delivers codes, links and alerts to users
+func WithNotifier(n Notifier) Option {
+	return func(a *Auth) {
+		a.notifier = n
+	}
+}
+
+// WithEventSink set sink that receives security events, wrap it by NewEventDispatcher if it is slow
+func WithEventSink(s EventSink) Option {
+	return func(a *Auth) {
+		a.eventSink = s
+	}
+}
+
+// WithGeoResolver set resolver that resolves country and region of the ips that users signed in from
+func WithGeoResolver(r GeoResolver) Option {
+	return func(a *Auth) {
+		a.geoResolver = r
+	}
+}
+
+// WithRiskEngine set engine that assesses the risk of each login before session is created, eg: DefaultRiskRules
+func WithRiskEngine(e RiskEngine) Option {
+	return func(a *Auth) {
+		a.riskEngine = e
+	}
+}
+
+// WithSourceStore set store that counts failed logins and keeps blocked sources for StuffingGuard, it is in-memory by default
+func WithSourceStore(s SourceStore) Option {
+	return func(a *Auth) {
+		a.sources = s
+	}
+}
+
+// WithStuffingGuard blocks or challenges the ips and networks that fail too many logins across all accounts
+func WithStuffingGuard(g StuffingGuard) Option {
+	return func(a *Auth) {
+		a.stuffingGuard = g
+	}
+}
+
+// WithTemplates set custom message templates, DefaultTemplates is used if it is not set
+func WithTemplates(t *Templates) Option {
+	return func(a *Auth) {
+		a.templates = t
+	}
+}
+
+// WithMagicLink setup how long a magic link is valid, and the origins that it can redirect to. origins defaults to WebAuthn origins
+func WithMagicLink(ttl time.Duration, origins ...string) Option {
+	return func(a *Auth) {
+		a.magicLinkTTL = ttl
+		a.magicLinkOrigins = origins
+	}
+}
+
+// WithCounterStore set the store of throttle counters, an in-memory store is used if it is not set
+func WithCounterStore(s CounterStore) Option {
+	return func(a *Auth) {
+		a.counters = s
+	}
+}
+
+// WithSendThrottle limit how often login codes, magic links and MFA codes are sent, it overrides the default 5/hour per identity and 20/hour per ip
+func WithSendThrottle(t SendThrottle) Option {
+	return func(a *Auth) {
+		a.sendThrottle = t
+	}
+}
+
+// WithWaitingPeriod set how long waiting users can sign in before they are activated. They never expire if it is not set.
+func WithWaitingPeriod(d time.Duration) Option {
+	return func(a *Auth) {
+		a.waitingPeriod = d
+	}
+}
+
+// WithVerification setup how long a verification link or code is valid, and if a waiting user is activated when the email or mobile is verified
+func WithVerification(ttl time.Duration, activate bool) Option {
+	return func(a *Auth) {
+		a.verificationTTL = ttl
+		a.verifyActivate = activate
+	}
+}
+
+// WithProfileRevert setup how long the old email or mobile can revert a change by the link sent to it
+func WithProfileRevert(ttl time.Duration) Option {
+	return func(a *Auth) {
+		a.revertTTL = ttl
+	}
+}
+
+// WithRequireVerified stops unverified emails and mobiles from being used for login with password or otp
+func WithRequireVerified() Option {
+	return func(a *Auth) {
+		a.requireVerified = true
+	}
+}
+
+// WithDeleteGracePeriod set how long a deleted user can be restored before it is purged, it is 30 days by default
+func WithDeleteGracePeriod(d time.Duration) Option {
+	return func(a *Auth) {
+		a.deleteGrace = d
+	}
+}
+
+// WithLockout set how accounts are locked after failed logins
+func WithLockout(l Lockout) Option {
+	return func(a *Auth) {
+		a.lockout = l
+	}
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/yaitoo/sqle"
+	"github.com/yaitoo/sqle/shardid"
+)
+
+// Passkey a WebAuthn credential registered by user
+type Passkey struct {
+	UserID     shardid.ID `json:"userID,omitempty"`
+	Hash       string     `json:"-"`
+	CredID     string     `json:"credID,omitempty"`
+	Name       string     `json:"name,omitempty"`
+	PublicKey  string     `json:"-"`
+	SignCount  int64      `json:"-"`
+	AAGUID     string     `json:"aaguid,omitempty"`
+	Transports string     `json:"transports,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt,omitempty"`
+	LastUsedAt sqle.Time  `json:"lastUsedAt,omitempty"`
+}
+
+// PasskeyRP relying party of PublicKeyCredentialCreationOptions
+type PasskeyRP struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// PasskeyUser user entity of PublicKeyCredentialCreationOptions
+type PasskeyUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// PasskeyParam an item of pubKeyCredParams
+type PasskeyParam struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// PasskeyDescriptor an item of excludeCredentials/allowCredentials
+type PasskeyDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// PasskeySelection authenticatorSelection of PublicKeyCredentialCreationOptions
+type PasskeySelection struct {
+	ResidentKey      string `json:"residentKey,omitempty"`
+	UserVerification string `json:"userVerification,omitempty"`
+}
+
+// PasskeyCreationOptions PublicKeyCredentialCreationOptions for navigator.credentials.create(),
+// binary fields are base64url encoded.
+type PasskeyCreationOptions struct {
+	Challenge              string              `json:"challenge"`
+	RP                     PasskeyRP           `json:"rp"`
+	User                   PasskeyUser         `json:"user"`
+	PubKeyCredParams       []PasskeyParam      `json:"pubKeyCredParams"`
+	Timeout                int64               `json:"timeout,omitempty"`
+	ExcludeCredentials     []PasskeyDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection PasskeySelection    `json:"authenticatorSelection"`
+	Attestation            string              `json:"attestation,omitempty"`
+}
+
+// PasskeyRequestOptions PublicKeyCredentialRequestOptions for navigator.credentials.get(),
+// binary fields are base64url encoded.
+type PasskeyRequestOptions struct {
+	Challenge        string              `json:"challenge"`
+	RPID             string              `json:"rpId"`
+	Timeout          int64               `json:"timeout,omitempty"`
+	AllowCredentials []PasskeyDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string              `json:"userVerification,omitempty"`
+}
+
+// PasskeyRegistration the options of a registration ceremony.
+// Token carries the signed ceremony state, and should be sent back with the attestation.
+type PasskeyRegistration struct {
+	Token     string                 `json:"token"`
+	PublicKey PasskeyCreationOptions `json:"publicKey"`
+}
+
+// PasskeyLogin the options of an authentication ceremony.
+// Token carries the signed ceremony state, and should be sent back with the assertion.
+type PasskeyLogin struct {
+	Token     string                `json:"token"`
+	PublicKey PasskeyRequestOptions `json:"publicKey"`
+}
+
+// PasskeyAttestation the PublicKeyCredential returned by navigator.credentials.create(),
+// binary fields are base64url encoded.
+type PasskeyAttestation struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// PasskeyAssertion the PublicKeyCredential returned by navigator.credentials.get(),
+// binary fields are base64url encoded.
+type PasskeyAssertion struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// descriptor returns the PublicKeyCredentialDescriptor of the passkey
+func (pk Passkey) descriptor() PasskeyDescriptor {
+	d := PasskeyDescriptor{
+		Type: "public-key",
+		ID:   pk.CredID,
+	}
+
+	if pk.Transports != "" {
+		d.Transports = strings.Split(pk.Transports, ",")
+	}
+
+	return d
+}
@@ -17,4 +17,16 @@ type ProfileData struct {
 	Email  string `json:"email,omitempty"`
 	Mobile string `json:"mobile,omitempty"`
 	TKey   string `json:"tkey,omitempty"`
+
+	// PendingEmail/PendingMobile the new email/mobile that waits for confirmation
+	PendingEmail  string `json:"pendingEmail,omitempty"`
+	PendingMobile string `json:"pendingMobile,omitempty"`
+
+	// PrevEmail/PrevMobile the replaced email/mobile that can be restored by the revert link
+	PrevEmail  string `json:"prevEmail,omitempty"`
+	PrevMobile string `json:"prevMobile,omitempty"`
+
+	// PrevEmailExpiresAt/PrevMobileExpiresAt the unix time that the revert link expires, the address can't be changed again before it
+	PrevEmailExpiresAt  int64 `json:"prevEmailExpiresAt,omitempty"`
+	PrevMobileExpiresAt int64 `json:"prevMobileExpiresAt,omitempty"`
 }
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+const (
+	defaultRateLimitSize = 10240
+	// maxIdentityBodySize the body that RateByIdentity reads at most, login forms are far smaller
+	maxIdentityBodySize = 64 << 10
+)
+
+// RateKey returns the bucket key of a request, the request is not limited if the key is empty
+type RateKey func(h *Handler, r *http.Request) string
+
+// RateByIP keys requests by user ip
+func RateByIP(h *Handler, r *http.Request) string {
+	return h.getUserIP(r)
+}
+
+// RateByIdentity keys requests by the email or mobile in json body. A body larger than 64KB is not keyed, and the handler gets it truncated.
+func RateByIdentity(_ *Handler, r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+
+	body := http.MaxBytesReader(nil, r.Body, maxIdentityBodySize)
+	buf, err := io.ReadAll(body)
+	body.Close()
+	// the body is restored for the handler
+	r.Body = io.NopCloser(bytes.NewReader(buf))
+	if err != nil {
+		return ""
+	}
+
+	var form struct {
+		Email  string `json:"email"`
+		Mobile string `json:"mobile"`
+	}
+
+	if json.Unmarshal(buf, &form) != nil {
+		return ""
+	}
+
+	if form.Email != "" {
+		return "email:" + strings.ToLower(strings.TrimSpace(form.Email))
+	}
+
+	if form.Mobile != "" {
+		return "mobile:" + strings.TrimSpace(form.Mobile)
+	}
+
+	return ""
+}
+
+// RateBy keys requests by a custom function
+func RateBy(fn func(r *http.Request) string) RateKey {
+	return func(_ *Handler, r *http.Request) string {
+		return fn(r)
+	}
+}
+
+// RateLimit a token bucket that holds Burst tokens and refills a token every Every, each request takes a token.
+//
+// The buckets are kept in memory and are best-effort: at most Size keys(10240 by default) are tracked, and the least recently used bucket is evicted
+// even if it isn't full. So a client that sends many distinct keys can reset the bucket of others, eg junk emails with RateByIdentity.
+// Set Size above the number of keys expected in Burst*Every, and pair key limits with RateByIP.
+type RateLimit struct {
+	Burst int
+	Every time.Duration
+	Key   RateKey
+	Size  int
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps token buckets of a RateLimit, idle buckets are evicted after they are full, or when more than Size keys are tracked
+type rateLimiter struct {
+	RateLimit
+
+	mu      sync.Mutex
+	buckets *expirable.LRU[string, *tokenBucket]
+}
+
+func newRateLimiter(l RateLimit) *rateLimiter {
+	if l.Size < 1 {
+		l.Size = defaultRateLimitSize
+	}
+
+	return &rateLimiter{
+		RateLimit: l,
+		buckets:   expirable.NewLRU[string, *tokenBucket](l.Size, nil, time.Duration(l.Burst)*l.Every),
+	}
+}
+
+// take takes a token from the bucket of key, and returns how long to wait if the bucket is empty
+func (l *rateLimiter) take(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets.Get(key)
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.Burst), last: now}
+	}
+
+	b.tokens += float64(now.Sub(b.last)) / float64(l.Every)
+	if b.tokens > float64(l.Burst) {
+		b.tokens = float64(l.Burst)
+	}
+	b.last = now
+
+	var wait time.Duration
+	if b.tokens >= 1 {
+		b.tokens--
+	} else {
+		wait = time.Duration((1 - b.tokens) * float64(l.Every))
+	}
+
+	l.buckets.Add(key, b)
+
+	return wait
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// RiskDecision what to do with a login attempt
+type RiskDecision string
+
+const (
+	RiskAllow     RiskDecision = "allow"
+	RiskChallenge RiskDecision = "challenge"
+	RiskBlock     RiskDecision = "block"
+)
+
+// risk reasons
+const (
+	RiskBadIP            = "bad_ip"
+	RiskNewDevice        = "new_device"
+	RiskImpossibleTravel = "impossible_travel"
+	RiskRecentFails      = "recent_fails"
+	// RiskCredentialStuffing the source is challenged by StuffingGuard
+	RiskCredentialStuffing = "credential_stuffing"
+)
+
+// RiskSignals the signals of a login attempt that risk is assessed by
+type RiskSignals struct {
+	UserID int64
+	Method LoginMethod
+	IP     string
+	UA     string
+
+	// Geo location of IP
+	Geo Geo
+	// PrevGeo location of the ip of previous successful login at PrevAt, PrevAt is zero if it is the first login
+	PrevGeo Geo
+	PrevAt  time.Time
+
+	// NewDevice user never signed in from UA
+	NewDevice bool
+	// RecentFails failed logins since last successful login
+	RecentFails int
+}
+
+// Risk the assessment of a login attempt
+type Risk struct {
+	Score    int          `json:"score"`
+	Decision RiskDecision `json:"decision"`
+	Reasons  []string     `json:"reasons,omitempty"`
+}
+
+// RiskEngine assesses the risk of a login attempt before session is created
+type RiskEngine interface {
+	Assess(ctx context.Context, s RiskSignals) (Risk, error)
+}
+
+// RiskRules scores a login attempt by weighted signals. The attempt is challenged for MFA if its score reaches Challenge,
+// and it is blocked if its score reaches Block. Zero Challenge/Block disables it.
+type RiskRules struct {
+	// BadIPs ip reputation list
+	BadIPs     *IPList
+	BadIPScore int
+
+	NewDeviceScore int
+
+	// MaxSpeed km/h between consecutive logins, the travel is impossible if it is faster than MaxSpeed
+	MaxSpeed              float64
+	ImpossibleTravelScore int
+
+	// FailScore the score of each recent failed login
+	FailScore int
+
+	Challenge int
+	Block     int
+}
+
+// DefaultRiskRules challenges logins from bad ips, impossible travels and new devices after 2 fails, and blocks impossible travels from bad ips
+var DefaultRiskRules = RiskRules{
+	BadIPScore:            60,
+	NewDeviceScore:        20,
+	MaxSpeed:              1000,
+	ImpossibleTravelScore: 50,
+	FailScore:             10,
+	Challenge:             40,
+	Block:                 100,
+}
+
+// Assess scores the signals
+func (r RiskRules) Assess(_ context.Context, s RiskSignals) (Risk, error) {
+	risk := Risk{Decision: RiskAllow}
+
+	add := func(score int, reason string) {
+		if score > 0 {
+			risk.Score += score
+			risk.Reasons = append(risk.Reasons, reason)
+		}
+	}
+
+	if r.BadIPs.Contains(s.IP) {
+		add(r.BadIPScore, RiskBadIP)
+	}
+
+	if s.NewDevice {
+		add(r.NewDeviceScore, RiskNewDevice)
+	}
+
+	if r.MaxSpeed > 0 && isImpossibleTravel(s.PrevGeo, s.Geo, s.PrevAt, time.Now(), r.MaxSpeed) {
+		add(r.ImpossibleTravelScore, RiskImpossibleTravel)
+	}
+
+	if s.RecentFails > 0 {
+		add(r.FailScore*s.RecentFails, RiskRecentFails)
+	}
+
+	switch {
+	case r.Block > 0 && risk.Score >= r.Block:
+		risk.Decision = RiskBlock
+	case r.Challenge > 0 && risk.Score >= r.Challenge:
+		risk.Decision = RiskChallenge
+	}
+
+	return risk, nil
+}
+
+// isImpossibleTravel checks if it is faster than maxSpeed(km/h) to travel from prev to cur
+func isImpossibleTravel(prev, cur Geo, prevAt, now time.Time, maxSpeed float64) bool {
+	if prevAt.IsZero() || !prev.hasLocation() || !cur.hasLocation() {
+		return false
+	}
+
+	km := prev.distance(cur)
+	// nearby locations are allowed, the resolution of ip geolocation is low
+	if km < 100 {
+		return false
+	}
+
+	hours := now.Sub(prevAt).Hours()
+	if hours <= 0 {
+		return true
+	}
+
+	return km/hours > maxSpeed
+}
+
+func (g Geo) hasLocation() bool {
+	return g.Latitude != 0 || g.Longitude != 0
+}
+
+// distance returns the great-circle distance in km
+func (g Geo) distance(o Geo) float64 {
+	const earthRadius = 6371.0
+	rad := func(d float64) float64 { return d * math.Pi / 180 }
+
+	dLat := rad(o.Latitude - g.Latitude)
+	dLon := rad(o.Longitude - g.Longitude)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(g.Latitude))*math.Cos(rad(o.Latitude))*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
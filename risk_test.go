@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPList(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bad_ips.txt")
+	require.NoError(t, os.WriteFile(file, []byte("# tor exits\n1.2.3.4\n\n10.0.0.0/8 # botnet\n2001:db8::/32\n"), 0o600))
+
+	l, err := LoadIPList(file)
+	require.NoError(t, err)
+
+	require.True(t, l.Contains("1.2.3.4"))
+	require.False(t, l.Contains("1.2.3.5"))
+	require.True(t, l.Contains("10.1.2.3"))
+	require.True(t, l.Contains("2001:db8::1"))
+	require.False(t, l.Contains("bad"))
+
+	_, err = NewIPList("1.2.3")
+	require.ErrorIs(t, err, ErrInvalidIP)
+
+	var empty *IPList
+	require.False(t, empty.Contains("1.2.3.4"))
+}
+
+func TestRiskRules(t *testing.T) {
+	ctx := context.Background()
+	bad, err := NewIPList("6.6.6.0/24")
+	require.NoError(t, err)
+
+	r := DefaultRiskRules
+	r.BadIPs = bad
+
+	sydney := Geo{Country: "AU", Latitude: -33.87, Longitude: 151.21}
+	london := Geo{Country: "GB", Latitude: 51.51, Longitude: -0.13}
+
+	tests := []struct {
+		name     string
+		signals  RiskSignals
+		decision RiskDecision
+		reasons  []string
+	}{
+		{
+			name:     "known_device",
+			signals:  RiskSignals{IP: "1.1.1.1"},
+			decision: RiskAllow,
+		},
+		{
+			name:     "new_device",
+			signals:  RiskSignals{IP: "1.1.1.1", NewDevice: true},
+			decision: RiskAllow,
+			reasons:  []string{RiskNewDevice},
+		},
+		{
+			name:     "new_device_after_fails",
+			signals:  RiskSignals{IP: "1.1.1.1", NewDevice: true, RecentFails: 2},
+			decision: RiskChallenge,
+			reasons:  []string{RiskNewDevice, RiskRecentFails},
+		},
+		{
+			name:     "bad_ip",
+			signals:  RiskSignals{IP: "6.6.6.6"},
+			decision: RiskChallenge,
+			reasons:  []string{RiskBadIP},
+		},
+		{
+			name:     "possible_travel",
+			signals:  RiskSignals{IP: "1.1.1.1", Geo: london, PrevGeo: sydney, PrevAt: time.Now().Add(-24 * time.Hour)},
+			decision: RiskAllow,
+		},
+		{
+			name:     "impossible_travel",
+			signals:  RiskSignals{IP: "1.1.1.1", Geo: london, PrevGeo: sydney, PrevAt: time.Now().Add(-1 * time.Hour)},
+			decision: RiskChallenge,
+			reasons:  []string{RiskImpossibleTravel},
+		},
+		{
+			name:     "impossible_travel_from_bad_ip",
+			signals:  RiskSignals{IP: "6.6.6.6", Geo: london, PrevGeo: sydney, PrevAt: time.Now().Add(-1 * time.Hour)},
+			decision: RiskBlock,
+			reasons:  []string{RiskBadIP, RiskImpossibleTravel},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			risk, err := r.Assess(ctx, test.signals)
+			require.NoError(t, err)
+			require.Equal(t, test.decision, risk.Decision)
+			require.Equal(t, test.reasons, risk.Reasons)
+		})
+	}
+
+	require.InDelta(t, 16990, sydney.distance(london), 50)
+}
@@ -12,6 +12,13 @@ type Session struct {
 	LastName     string `json:"lastName,omitempty"`
 	AccessToken  string `json:"accessToken,omitempty"`
 	RefreshToken string `json:"refreshToken,omitempty"`
+
+	// MFA the second-factor challenge that should be passed by VerifyMFA or a passkey login
+	MFA *MFAChallenge `json:"mfa,omitempty"`
+	// TrustedDevice the token that skips MFA on this device, it is only issued when the device is remembered
+	TrustedDevice string `json:"trustedDevice,omitempty"`
+	// NewDevice it is the first sign in from this device
+	NewDevice bool `json:"newDevice,omitempty"`
 }
 
 type UserClaims struct {
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SourceStore counts failed logins of sources in sliding windows, and keeps blocked sources. It can be backed by redis or db in a cluster.
+type SourceStore interface {
+	// Hit counts a failed login of source, and returns the failed logins in the sliding window
+	Hit(ctx context.Context, source string, window time.Duration) (int64, error)
+	// Block blocks or challenges the source until s.Until
+	Block(ctx context.Context, s BlockedSource) error
+	// Unblock removes the block of source
+	Unblock(ctx context.Context, source string) error
+	// GetBlocks returns the blocks of sources that are not expired
+	GetBlocks(ctx context.Context, sources ...string) ([]BlockedSource, error)
+	// ListBlocks returns all blocks that are not expired
+	ListBlocks(ctx context.Context) ([]BlockedSource, error)
+}
+
+// slidingCounter approximates a sliding window by weighting the count of previous window
+type slidingCounter struct {
+	start time.Time
+	prev  int64
+	cur   int64
+}
+
+// MemorySourceStore an in-memory SourceStore for single instance
+type MemorySourceStore struct {
+	mu       sync.Mutex
+	counters map[string]*slidingCounter
+	windows  map[string]time.Duration
+	blocks   map[string]BlockedSource
+	swept    time.Time
+}
+
+// NewMemorySourceStore create an in-memory source store
+func NewMemorySourceStore() *MemorySourceStore {
+	return &MemorySourceStore{
+		counters: make(map[string]*slidingCounter),
+		windows:  make(map[string]time.Duration),
+		blocks:   make(map[string]BlockedSource),
+	}
+}
+
+// Hit counts a failed login of source
+func (s *MemorySourceStore) Hit(_ context.Context, source string, window time.Duration) (int64, error) {
+	now := time.Now()
+	start := now.Truncate(window)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	c, ok := s.counters[source]
+	if !ok {
+		c = &slidingCounter{start: start}
+		s.counters[source] = c
+		s.windows[source] = window
+	}
+
+	if !c.start.Equal(start) {
+		if c.start.Equal(start.Add(-window)) {
+			c.prev = c.cur
+		} else {
+			c.prev = 0
+		}
+		c.cur = 0
+		c.start = start
+	}
+
+	c.cur++
+
+	weight := float64(window-now.Sub(start)) / float64(window)
+
+	return c.cur + int64(float64(c.prev)*weight), nil
+}
+
+// Block blocks or challenges the source
+func (s *MemorySourceStore) Block(_ context.Context, b BlockedSource) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blocks[b.Source] = b
+	return nil
+}
+
+// Unblock removes the block of source, and resets its counter
+func (s *MemorySourceStore) Unblock(_ context.Context, source string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.blocks, source)
+	delete(s.counters, source)
+	delete(s.windows, source)
+	return nil
+}
+
+// GetBlocks returns the blocks of sources that are not expired
+func (s *MemorySourceStore) GetBlocks(_ context.Context, sources ...string) ([]BlockedSource, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var items []BlockedSource
+	for _, it := range sources {
+		if b, ok := s.blocks[it]; ok && now.Before(b.Until) {
+			items = append(items, b)
+		}
+	}
+
+	return items, nil
+}
+
+// ListBlocks returns all blocks that are not expired, ordered by source
+func (s *MemorySourceStore) ListBlocks(_ context.Context) ([]BlockedSource, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := make([]BlockedSource, 0, len(s.blocks))
+	for _, b := range s.blocks {
+		if now.Before(b.Until) {
+			items = append(items, b)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Source < items[j].Source
+	})
+
+	return items, nil
+}
+
+// sweep removes stale counters and expired blocks at most once a minute
+func (s *MemorySourceStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < time.Minute {
+		return
+	}
+
+	s.swept = now
+	for k, c := range s.counters {
+		if now.Sub(c.start) >= 2*s.windows[k] {
+			delete(s.counters, k)
+			delete(s.windows, k)
+		}
+	}
+
+	for k, b := range s.blocks {
+		if !now.Before(b.Until) {
+			delete(s.blocks, k)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"net"
+	"time"
+)
+
+// StuffingGuard detects credential stuffing by failed logins from a source across all accounts.
+// A source is an ip, or its /24(IPv4) or /64(IPv6) network. Zero threshold disables it.
+type StuffingGuard struct {
+	// Window sliding window that failed logins are counted in
+	Window time.Duration
+	// IPThreshold failed logins from an ip in Window
+	IPThreshold int64
+	// NetworkThreshold failed logins from a /24 or /64 network in Window
+	NetworkThreshold int64
+	// Cooldown how long a source is blocked or challenged after a threshold is crossed
+	Cooldown time.Duration
+	// Action RiskBlock blocks logins from the source, RiskChallenge challenges them for MFA
+	Action RiskDecision
+}
+
+// BlockedSource a source that is blocked or challenged for credential stuffing
+type BlockedSource struct {
+	// Source ip or network in CIDR
+	Source string       `json:"source"`
+	Action RiskDecision `json:"action"`
+	Fails  int64        `json:"fails"`
+	Until  time.Time    `json:"until"`
+}
+
+// sourceNetwork returns the /24 network of IPv4 or /64 network of IPv6
+func sourceNetwork(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+
+	if v4 := addr.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+
+	return (&net.IPNet{IP: addr.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"strconv"
+	"time"
+)
+
+// Limit allows Count events in Window
+type Limit struct {
+	Count  int64
+	Window time.Duration
+}
+
+// SendThrottle limits how often codes and links are sent, eg: []Limit{{1, time.Minute}, {5, time.Hour}}.
+// Identity and IP are 5/hour and 20/hour if they are nil, an empty slice disables them. Global is disabled by default.
+type SendThrottle struct {
+	// Identity limits per email/mobile
+	Identity []Limit
+	// IP limits per user ip
+	IP []Limit
+	// Global limits across all identities and ips
+	Global []Limit
+}
+
+// RetryAfterError is returned when a limit is hit, it matches ErrTooManyRequests
+type RetryAfterError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return ErrTooManyRequests.Error() + ": retry after " + strconv.FormatInt(int64(e.RetryAfter.Seconds()), 10) + "s"
+}
+
+func (e *RetryAfterError) Is(target error) bool {
+	return target == ErrTooManyRequests
+}
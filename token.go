@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TokenClaims claims of the short-lived tokens that carry state between the steps of a flow,
+// eg. the challenge of a passkey ceremony. Each purpose is signed with its own derived key,
+// so a token can never be replayed as another kind of token.
+type TokenClaims struct {
+	ID             int64  `json:"id,omitempty"`
+	Purpose        string `json:"pur,omitempty"`
+	Data           string `json:"data,omitempty"`
+	Nonce          string `json:"nonce,omitempty"`
+	ExpirationTime int64  `json:"exp,omitempty"`
+	IssuedAt       int64  `json:"iat,omitempty"`
+}
+
+// GetExpirationTime implements the Claims interface.
+func (m TokenClaims) GetExpirationTime() (*jwt.NumericDate, error) {
+	return jwt.NewNumericDate(time.Unix(m.ExpirationTime, 0)), nil
+}
+
+// GetNotBefore implements the Claims interface.
+func (TokenClaims) GetNotBefore() (*jwt.NumericDate, error) {
+	return nil, nil
+}
+
+// GetIssuedAt implements the Claims interface.
+func (m TokenClaims) GetIssuedAt() (*jwt.NumericDate, error) {
+	return jwt.NewNumericDate(time.Unix(m.IssuedAt, 0)), nil
+}
+
+// GetAudience implements the Claims interface.
+func (TokenClaims) GetAudience() (jwt.ClaimStrings, error) {
+	return nil, nil
+}
+
+// GetIssuer implements the Claims interface.
+func (TokenClaims) GetIssuer() (string, error) {
+	return "", nil
+}
+
+// GetSubject implements the Claims interface.
+func (TokenClaims) GetSubject() (string, error) {
+	return "", nil
+}
@@ -11,7 +11,7 @@ import (
 type UserStatus int
 
 const (
-	// UserStatusWaiting waiting for verifying, can do anything within waiting period
+	// UserStatusWaiting waiting for verifying, can do anything within waiting period. See WithWaitingPeriod
 	UserStatusWaiting UserStatus = 0
 	// UserStatusActivated activated means can do anything
 	UserStatusActivated UserStatus = 1
@@ -41,4 +41,7 @@ type User struct {
 
 	CreatedAt time.Time `json:"createdAt,omitempty"`
 	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+
+	// DeletedAt the time that user is deleted, it is purged after the grace period of deletion
+	DeletedAt sqle.Time `json:"deletedAt,omitempty"`
 }
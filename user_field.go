@@ -0,0 +1,41 @@
+package auth
+
+// UserField a PII column of user table that can be encrypted at rest, see WithEncryptedField
+type UserField string
+
+const (
+	UserFirstName UserField = "first_name"
+	UserLastName  UserField = "last_name"
+	// UserEmail the masked email in user table, the full email is indexed if it is searchable
+	UserEmail UserField = "email"
+	// UserMobile the masked mobile in user table, the full mobile is indexed if it is searchable
+	UserMobile UserField = "mobile"
+)
+
+// SearchIndex how an encrypted field can be searched in QueryUsers, see WhereUserField
+type SearchIndex int
+
+const (
+	// SearchNone the field can't be searched
+	SearchNone SearchIndex = iota
+	// SearchExact the field can be matched exactly by its blind index
+	SearchExact
+	// SearchPrefix the field can be matched exactly or by prefix by its prefix tokens
+	SearchPrefix
+)
+
+const (
+	// searchPrefixMin the min runes of prefix that can be searched, shorter prefixes are shared by too many values and tell them apart by frequency
+	searchPrefixMin = 3
+	// searchPrefixMax the max runes of prefix that can be searched
+	searchPrefixMax = 32
+)
+
+func (f UserField) valid() bool {
+	switch f {
+	case UserFirstName, UserLastName, UserEmail, UserMobile:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"slices"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const (
+	webauthnCreate = "webauthn.create"
+	webauthnGet    = "webauthn.get"
+
+	// authenticator data flags
+	flagUserPresent  byte = 0x01
+	flagUserVerified byte = 0x04
+	flagAttested     byte = 0x40
+
+	// COSE algorithms
+	coseES256 = -7
+	coseEdDSA = -8
+	coseRS256 = -257
+
+	// COSE key types
+	coseKeyOKP = 1
+	coseKeyEC2 = 2
+	coseKeyRSA = 3
+)
+
+var (
+	errBadClientData       = errors.New("webauthn: bad client data")
+	errBadAuthData         = errors.New("webauthn: bad authenticator data")
+	errBadPublicKey        = errors.New("webauthn: bad public key")
+	errBadAttestation      = errors.New("webauthn: bad attestation")
+	errBadSignature        = errors.New("webauthn: bad signature")
+	errUnsupportedAlg      = errors.New("webauthn: unsupported algorithm")
+	errChallengeNotMatched = errors.New("webauthn: challenge not matched")
+	errOriginNotAllowed    = errors.New("webauthn: origin not allowed")
+	errRPIDNotMatched      = errors.New("webauthn: rp id not matched")
+	errUserNotPresent      = errors.New("webauthn: user not present")
+	errUserNotVerified     = errors.New("webauthn: user not verified")
+)
+
+// clientData the parsed clientDataJSON of a WebAuthn ceremony
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// authData the parsed authenticator data
+type authData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// attestationObject the CBOR encoded attestation returned by navigator.credentials.create()
+type attestationObject struct {
+	Fmt      string          `cbor:"fmt"`
+	AttStmt  cbor.RawMessage `cbor:"attStmt"`
+	AuthData []byte          `cbor:"authData"`
+}
+
+// coseKey the COSE_Key of a credential public key
+type coseKey struct {
+	Kty int    `cbor:"1,keyasint"`
+	Alg int    `cbor:"3,keyasint"`
+	Crv int    `cbor:"-1,keyasint,omitempty"`
+	X   []byte `cbor:"-2,keyasint,omitempty"`
+	Y   []byte `cbor:"-3,keyasint,omitempty"`
+}
+
+// coseRSAKey the COSE_Key of a RSA public key, its parameters share labels with EC2 keys
+type coseRSAKey struct {
+	Kty int    `cbor:"1,keyasint"`
+	Alg int    `cbor:"3,keyasint"`
+	N   []byte `cbor:"-1,keyasint"`
+	E   []byte `cbor:"-2,keyasint"`
+}
+
+func encodeBase64URL(buf []byte) string {
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// decodeBase64URL decodes base64url with or without padding, browsers and libraries don't agree on it
+func decodeBase64URL(s string) ([]byte, error) {
+	s = string(bytes.TrimRight([]byte(s), "="))
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// parseClientData parses clientDataJSON, and verifies its type, challenge and origin.
+func parseClientData(buf []byte, typ, challenge string, origins []string) error {
+	var cd clientData
+	if err := json.Unmarshal(buf, &cd); err != nil {
+		return errBadClientData
+	}
+
+	if cd.Type != typ {
+		return errBadClientData
+	}
+
+	if subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(challenge)) != 1 {
+		return errChallengeNotMatched
+	}
+
+	if !slices.Contains(origins, cd.Origin) {
+		return errOriginNotAllowed
+	}
+
+	return nil
+}
+
+// parseAuthData parses the authenticator data, and verifies rp id hash and user presence.
+// The attested credential data is only parsed when AT flag is set.
+func parseAuthData(buf []byte, rpID string, requireUV bool) (authData, error) {
+	var ad authData
+
+	if len(buf) < 37 {
+		return ad, errBadAuthData
+	}
+
+	ad.RPIDHash = buf[:32]
+	ad.Flags = buf[32]
+	ad.SignCount = binary.BigEndian.Uint32(buf[33:37])
+
+	h := sha256.Sum256([]byte(rpID))
+	if subtle.ConstantTimeCompare(h[:], ad.RPIDHash) != 1 {
+		return ad, errRPIDNotMatched
+	}
+
+	if ad.Flags&flagUserPresent == 0 {
+		return ad, errUserNotPresent
+	}
+
+	if requireUV && ad.Flags&flagUserVerified == 0 {
+		return ad, errUserNotVerified
+	}
+
+	if ad.Flags&flagAttested == 0 {
+		return ad, nil
+	}
+
+	rest := buf[37:]
+	if len(rest) < 18 {
+		return ad, errBadAuthData
+	}
+
+	ad.AAGUID = rest[:16]
+	n := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if n == 0 || len(rest) < n {
+		return ad, errBadAuthData
+	}
+
+	ad.CredentialID = rest[:n]
+	rest = rest[n:]
+
+	// public key is the first CBOR item, extensions may follow it
+	var pk cbor.RawMessage
+	if err := cbor.NewDecoder(bytes.NewReader(rest)).Decode(&pk); err != nil {
+		return ad, errBadPublicKey
+	}
+	ad.PublicKey = pk
+
+	return ad, nil
+}
+
+// parseAttestation decodes the attestation object. Only "none" attestation is accepted,
+// because credentials are registered with attestation conveyance "none".
+func parseAttestation(buf []byte) (attestationObject, error) {
+	var ao attestationObject
+	if err := cbor.Unmarshal(buf, &ao); err != nil {
+		return ao, errBadAttestation
+	}
+
+	if ao.Fmt != "none" {
+		return ao, errBadAttestation
+	}
+
+	return ao, nil
+}
+
+// parsePublicKey converts a COSE_Key into a crypto.PublicKey
+func parsePublicKey(buf []byte) (crypto.PublicKey, int, error) {
+	var k coseKey
+	if err := cbor.Unmarshal(buf, &k); err != nil {
+		return nil, 0, errBadPublicKey
+	}
+
+	switch k.Kty {
+	case coseKeyEC2:
+		if k.Alg != coseES256 || k.Crv != 1 || len(k.X) != 32 || len(k.Y) != 32 {
+			return nil, k.Alg, errUnsupportedAlg
+		}
+		// reject points that are not on the curve
+		point := append([]byte{0x04}, k.X...)
+		if _, err := ecdh.P256().NewPublicKey(append(point, k.Y...)); err != nil {
+			return nil, k.Alg, errBadPublicKey
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(k.X),
+			Y:     new(big.Int).SetBytes(k.Y),
+		}, k.Alg, nil
+	case coseKeyOKP:
+		if k.Alg != coseEdDSA || k.Crv != 6 || len(k.X) != ed25519.PublicKeySize {
+			return nil, k.Alg, errUnsupportedAlg
+		}
+		return ed25519.PublicKey(k.X), k.Alg, nil
+	case coseKeyRSA:
+		var rk coseRSAKey
+		if err := cbor.Unmarshal(buf, &rk); err != nil {
+			return nil, 0, errBadPublicKey
+		}
+		if rk.Alg != coseRS256 || len(rk.N) == 0 || len(rk.E) == 0 || len(rk.E) > 4 {
+			return nil, rk.Alg, errUnsupportedAlg
+		}
+		e := 0
+		for _, b := range rk.E {
+			e = e<<8 | int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(rk.N), E: e}, rk.Alg, nil
+	}
+
+	return nil, k.Alg, errUnsupportedAlg
+}
+
+// verifySignature verifies an assertion signature over authenticatorData || sha256(clientDataJSON)
+func verifySignature(publicKey []byte, authData, clientDataJSON, sig []byte) error {
+	pub, _, err := parsePublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	cdh := sha256.Sum256(clientDataJSON)
+	signed := make([]byte, 0, len(authData)+len(cdh))
+	signed = append(signed, authData...)
+	signed = append(signed, cdh[:]...)
+
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(signed)
+		if ecdsa.VerifyASN1(k, h[:], sig) {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(k, signed, sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		h := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig) == nil {
+			return nil
+		}
+	}
+
+	return errBadSignature
+}
+
+// formatAAGUID formats AAGUID as an uuid string
+func formatAAGUID(id []byte) string {
+	if len(id) != 16 {
+		return ""
+	}
+	s := hex.EncodeToString(id)
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}